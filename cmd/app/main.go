@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/commands"
 	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	patreonprovider "github.com/TicketsBot/subscriptions-app/internal/providers/patreon"
 	"github.com/TicketsBot/subscriptions-app/internal/server"
+	"github.com/TicketsBot/subscriptions-app/internal/supervisor"
 	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
 	"github.com/getsentry/sentry-go"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -61,84 +68,145 @@ func main() {
 		panic(err)
 	}
 
-	patreonClient := patreon.NewClient(conf, logger.With(zap.String("component", "patreon_client")))
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		_, err := patreonClient.GrantCredentials(ctx)
-		cancel()
-		if err == nil {
-			logger.Info("Granted credentials successfully")
-			break
-		} else {
-			logger.Error("Failed to grant credentials, retrying in 10s", zap.Error(err))
-			time.Sleep(time.Second * 10)
-		}
-	}
+	defer logger.Sync()
 
-	pledgeCh := make(chan map[string]patreon.Patron)
-	go startPatreonLoop(context.Background(), logger, patreonClient, pledgeCh)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	server := server.NewServer(conf, logger.With(zap.String("component", "server")))
+	var patreonOpts []patreon.Option
+	if conf.Patreon.BaseUrl != "" {
+		patreonOpts = append(patreonOpts, patreon.WithBaseUrl(conf.Patreon.BaseUrl))
+	}
 
-	go func() {
-		for pledges := range pledgeCh {
-			server.UpdatePledges(pledges)
-		}
-	}()
+	patreonClient := patreon.NewClient(conf, logger.With(zap.String("component", "patreon_client")), patreonOpts...)
+
+	// Each provider runs in the background, sending updates to be merged into the server's subscribers
+	subscriptionProviders := []providers.Provider{
+		patreonprovider.NewProvider(patreonClient, logger.With(zap.String("component", "patreon_provider"))),
+	}
 
-	if err := server.Run(); err != nil {
+	server, err := server.NewServer(conf, logger.With(zap.String("component", "server")))
+	if err != nil {
 		panic(err)
 	}
-}
 
-func startPatreonLoop(ctx context.Context, logger *zap.Logger, patreonClient *patreon.Client, ch chan map[string]patreon.Patron) {
-	for {
-		fetchPledges(ctx, logger, patreonClient, ch)
-		time.Sleep(time.Minute)
+	if conf.Discord.SyncCommands != "" {
+		syncCommands(ctx, conf, logger.With(zap.String("component", "command_registrar")))
 	}
-}
 
-func fetchPledges(
-	ctx context.Context,
-	logger *zap.Logger,
-	patreonClient *patreon.Client,
-	ch chan map[string]patreon.Patron,
-) {
-	if patreonClient.Tokens.ExpiresAt.Before(time.Now()) {
-		logger.Fatal(
-			"Refresh token has already expired (expired at %s)",
-			zap.Time("expires_at", patreonClient.Tokens.ExpiresAt),
-		)
-		return
+	subscriptionProviders = append(subscriptionProviders, server.Providers()...)
+
+	sup := supervisor.New(logger.With(zap.String("component", "supervisor")), time.Second*5)
+
+	updates := make(chan providers.Update)
+	for _, provider := range subscriptionProviders {
+		provider := provider
+		sup.Go(ctx, provider.Name()+"_provider", func(ctx context.Context) {
+			provider.Watch(ctx, updates)
+		})
 	}
 
-	if time.Until(patreonClient.Tokens.ExpiresAt) < time.Hour*24*3 {
-		logger.Info(
-			"Token expires in less than 3 days, refreshing",
-			zap.Time("expires_at", patreonClient.Tokens.ExpiresAt),
-		)
+	if publisher := server.RedisPublisher(); publisher != nil {
+		sup.Go(ctx, "redis_publisher", publisher.Run)
+	}
 
-		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
-		defer cancel()
+	if webhookOutbox := server.Outbox(); webhookOutbox != nil {
+		sup.Go(ctx, "outbox", webhookOutbox.Run)
+	}
 
-		tokens, err := patreonClient.DoRefresh(ctx)
-		if err != nil { // We can still continue if this fails
-			logger.Error("Failed to refresh token", zap.Error(err))
-		} else {
-			logger.Info("Tokens refreshed successfully", zap.Time("expires_at", tokens.ExpiresAt))
+	if conf.Grpc.Addr != "" {
+		sup.Go(ctx, "grpc_server", func(ctx context.Context) {
+			// Like the HTTP server, the app cannot serve its clients without it, so exit if it fails
+			if err := server.RunGrpc(ctx); err != nil {
+				logger.Error("gRPC server exited with error", zap.Error(err))
+				stop()
+			}
+		})
+	}
+
+	sup.Go(ctx, "update_consumer", func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-updates:
+				server.ApplyUpdate(update)
+				server.ProcessDeclines(ctx)
+			}
 		}
+	})
 
-		cancel()
+	if err := server.Run(ctx); err != nil {
+		logger.Error("Server exited with error", zap.Error(err))
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	// Server.Run may have returned due to an error rather than a signal, so make sure the background tasks stop
+	stop()
+
+	logger.Info("Waiting for background tasks to exit", zap.Duration("timeout", conf.ShutdownTimeout.Duration()))
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout.Duration())
 	defer cancel()
 
-	pledges, err := patreonClient.FetchPledges(ctx)
+	if !sup.Wait(waitCtx) {
+		logger.Warn("Timed out waiting for background tasks to exit")
+	}
+
+	logger.Info("Shutdown complete")
+}
+
+// syncCommands registers the commands for each application with a bot token. Failures are logged rather than
+// being fatal, as the commands may already be registered.
+func syncCommands(ctx context.Context, conf config.Config, logger *zap.Logger) {
+	if conf.Discord.SyncCommands != config.SyncCommandsGlobal && conf.Discord.SyncCommands != config.SyncCommandsGuild {
+		logger.Error("Unknown command sync mode", zap.String("mode", conf.Discord.SyncCommands))
+		return
+	}
+
+	catalogue, err := i18n.Load()
 	if err != nil {
-		logger.Error("Failed to fetch pledges", zap.Error(err))
+		logger.Error("Failed to load message catalogue", zap.Error(err))
 		return
 	}
 
-	ch <- pledges
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	// The same application may be listed multiple times with different public keys
+	synced := make(map[string]bool)
+	for _, app := range conf.Applications() {
+		if app.BotToken == "" || synced[app.BotToken] {
+			continue
+		}
+
+		synced[app.BotToken] = true
+
+		var registrar *commands.Registrar
+		if app.ApplicationId == 0 {
+			var err error
+			registrar, err = commands.NewRegistrarFromToken(ctx, app.BotToken, catalogue, logger)
+			if err != nil {
+				logger.Error("Failed to create command registrar", zap.Error(err))
+				continue
+			}
+		} else {
+			registrar = commands.NewRegistrar(app.BotToken, app.ApplicationId, catalogue, logger)
+		}
+
+		var guildIds []uint64
+		if conf.Discord.SyncCommands == config.SyncCommandsGuild {
+			guildIds = app.AllowedGuilds
+
+			if len(guildIds) == 0 {
+				logger.Warn("No allowed guilds to sync commands in", zap.Uint64("application_id", registrar.ApplicationId()))
+				continue
+			}
+		}
+
+		if _, err := registrar.Sync(ctx, guildIds, false); err != nil {
+			logger.Error("Failed to sync commands", zap.Uint64("application_id", registrar.ApplicationId()), zap.Error(err))
+		} else {
+			logger.Info("Commands synced", zap.Uint64("application_id", registrar.ApplicationId()))
+		}
+	}
 }
@@ -4,28 +4,19 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"github.com/rxdn/gdl/objects/interaction"
-	"github.com/rxdn/gdl/rest"
+	"github.com/TicketsBot/subscriptions-app/internal/commands"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
 )
 
-var commands = []rest.CreateCommandData{
-	{
-		Name:        "lookup",
-		Description: "Look up information about a user's subscription",
-		Options: []interaction.ApplicationCommandOption{
-			{
-				Type:        interaction.OptionTypeString,
-				Name:        "email",
-				Description: "The Patreon email address of the user to lookup",
-				Required:    true,
-			},
-		},
-		Type: interaction.ApplicationCommandTypeChatInput,
-	},
-}
-
 var (
-	token = flag.String("token", "", "Bot token")
+	token       = flag.String("token", "", "Bot token")
+	guilds      = flag.String("guilds", "", "Comma-separated list of guild IDs to register commands in, instead of globally")
+	guildScoped = flag.Bool("guild-scoped", false, "Register commands in the allowed guilds from the app config, instead of globally")
+	dryRun      = flag.Bool("dry-run", false, "Show the changes that would be made, without making them")
 )
 
 func main() {
@@ -35,14 +26,72 @@ func main() {
 		panic("no token provided")
 	}
 
-	self, err := rest.GetCurrentUser(context.Background(), *token, nil)
+	catalogue, err := i18n.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	if _, err := rest.ModifyGlobalCommands(context.Background(), *token, nil, self.Id, commands); err != nil {
+	registrar, err := commands.NewRegistrarFromToken(context.Background(), *token, catalogue, zap.NewNop())
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Commands created successfully")
+	var guildIds []uint64
+	if *guilds != "" {
+		for _, raw := range strings.Split(*guilds, ",") {
+			guildId, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("invalid guild ID %q", raw))
+			}
+
+			guildIds = append(guildIds, guildId)
+		}
+	} else if *guildScoped {
+		guildIds, err = allowedGuilds(registrar.ApplicationId())
+		if err != nil {
+			panic(err)
+		}
+
+		if len(guildIds) == 0 {
+			panic("no allowed guilds configured for application")
+		}
+	}
+
+	diffs, err := registrar.Sync(context.Background(), guildIds, *dryRun)
+	for _, diff := range diffs {
+		fmt.Print(diff.String())
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	if *dryRun {
+		fmt.Println("Dry run, no changes made")
+	} else {
+		fmt.Println("Commands created successfully")
+	}
+}
+
+func allowedGuilds(applicationId uint64) ([]uint64, error) {
+	conf, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer an exact match, but fall back to the legacy application, which has no ID
+	var fallback []uint64
+	for _, app := range conf.Applications() {
+		if app.ApplicationId == applicationId {
+			return app.AllowedGuilds, nil
+		} else if app.ApplicationId == 0 {
+			fallback = app.AllowedGuilds
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("application %d not found in config", applicationId)
+	}
+
+	return fallback, nil
 }
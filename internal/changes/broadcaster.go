@@ -0,0 +1,73 @@
+package changes
+
+import "sync"
+
+// Broadcaster is a Sink that fans events out to any number of subscribers, such as open streams. A subscriber that
+// does not keep up is dropped rather than blocking the publisher.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events published after it was created. Events is closed when the subscription is
+// cancelled, or when it is dropped for falling more than its buffer size behind.
+type Subscription struct {
+	Events <-chan Event
+
+	events chan Event
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription which buffers up to bufferSize events. It must be cancelled with Unsubscribe.
+func (b *Broadcaster) Subscribe(bufferSize int) *Subscription {
+	events := make(chan Event, bufferSize)
+	subscription := &Subscription{
+		Events: events,
+		events: events,
+	}
+
+	b.mu.Lock()
+	b.subscribers[subscription] = struct{}{}
+	b.mu.Unlock()
+
+	return subscription
+}
+
+// Unsubscribe cancels the subscription, if it has not already been dropped.
+func (b *Broadcaster) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[subscription]; ok {
+		delete(b.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+func (b *Broadcaster) Publish(_ Snapshot, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscribers {
+		for _, event := range events {
+			select {
+			case subscription.events <- event:
+				continue
+			default:
+			}
+
+			delete(b.subscribers, subscription)
+			close(subscription.events)
+			break
+		}
+	}
+}
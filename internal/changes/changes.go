@@ -0,0 +1,120 @@
+// Package changes detects changes to subscribers, and to the entitlements of Discord users, between updates, so that
+// they can be shared with other services as they happen.
+package changes
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Values of Event.Type
+const (
+	TypeCreated            = "created"
+	TypeUpdated            = "updated"
+	TypeDeleted            = "deleted"
+	TypeEntitlementChanged = "entitlement_changed"
+)
+
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// The subscriber that was created, updated or deleted, as it was before it was deleted. nil for entitlement events.
+	Subscriber *providers.Subscriber `json:"subscriber,omitempty"`
+	// The user whose entitlement changed. 0 for subscriber events.
+	DiscordId uint64 `json:"discord_id,string,omitempty"`
+	// The user's new entitlement, which is nil if they are no longer entitled to anything
+	Entitlement *entitlements.Entitlement `json:"entitlement,omitempty"`
+}
+
+// Snapshot is the state of every subscriber, and the effective entitlement of every Discord user, after an update.
+type Snapshot struct {
+	Time         time.Time
+	Subscribers  map[string]providers.Subscriber     // Subscriber.Key() -> Subscriber
+	Entitlements map[uint64]entitlements.Entitlement // Discord ID -> Entitlement, for users entitled to something
+}
+
+// Sink receives every snapshot and the changes it introduced. Publish is called with the server's update lock held,
+// so it must not block.
+type Sink interface {
+	Publish(snapshot Snapshot, events []Event)
+}
+
+// Detector compares each snapshot with the one before it.
+type Detector struct {
+	mu       sync.Mutex
+	previous *Snapshot
+}
+
+func NewDetector() *Detector {
+	return &Detector{}
+}
+
+// Detect returns the changes since the previous snapshot, ordered by subscriber key and then by Discord ID. The first
+// snapshot only sets the baseline, as it reflects the state at startup rather than any change.
+func (d *Detector) Detect(snapshot Snapshot) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous := d.previous
+	d.previous = &snapshot
+
+	if previous == nil {
+		return nil
+	}
+
+	var events []Event
+	for _, key := range unionKeys(previous.Subscribers, snapshot.Subscribers) {
+		before, existed := previous.Subscribers[key]
+		after, exists := snapshot.Subscribers[key]
+
+		switch {
+		case !existed:
+			events = append(events, Event{Type: TypeCreated, Time: snapshot.Time, Subscriber: &after})
+		case !exists:
+			events = append(events, Event{Type: TypeDeleted, Time: snapshot.Time, Subscriber: &before})
+		case !reflect.DeepEqual(before, after):
+			events = append(events, Event{Type: TypeUpdated, Time: snapshot.Time, Subscriber: &after})
+		}
+	}
+
+	for _, discordId := range unionKeys(previous.Entitlements, snapshot.Entitlements) {
+		before, existed := previous.Entitlements[discordId]
+		after, exists := snapshot.Entitlements[discordId]
+
+		if existed == exists && reflect.DeepEqual(before, after) {
+			continue
+		}
+
+		event := Event{Type: TypeEntitlementChanged, Time: snapshot.Time, DiscordId: discordId}
+		if exists {
+			event.Entitlement = &after
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func unionKeys[K string | uint64, V any](a, b map[K]V) []K {
+	keys := make([]K, 0, len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,119 @@
+package changes
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"testing"
+	"time"
+)
+
+func subscriber(id, status string) providers.Subscriber {
+	return providers.Subscriber{Provider: "patreon", Id: id, Status: status}
+}
+
+func snapshot(subscribers []providers.Subscriber, entitled map[uint64]entitlements.Entitlement) Snapshot {
+	byKey := make(map[string]providers.Subscriber, len(subscribers))
+	for _, s := range subscribers {
+		byKey[s.Key()] = s
+	}
+
+	return Snapshot{Time: time.Now(), Subscribers: byKey, Entitlements: entitled}
+}
+
+func TestDetect(t *testing.T) {
+	d := NewDetector()
+	premium := entitlements.Entitlement{Type: entitlements.TypePremium, MaxGuilds: 1}
+
+	// The first snapshot is the baseline
+	if events := d.Detect(snapshot(
+		[]providers.Subscriber{subscriber("1", providers.StatusActive), subscriber("2", providers.StatusActive)},
+		map[uint64]entitlements.Entitlement{10: premium},
+	)); len(events) != 0 {
+		t.Fatalf("expected no events for the baseline, got %+v", events)
+	}
+
+	// Nothing changed
+	if events := d.Detect(snapshot(
+		[]providers.Subscriber{subscriber("1", providers.StatusActive), subscriber("2", providers.StatusActive)},
+		map[uint64]entitlements.Entitlement{10: premium},
+	)); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+
+	whitelabel := entitlements.Entitlement{Type: entitlements.TypeWhitelabel, MaxGuilds: 1}
+	events := d.Detect(snapshot(
+		[]providers.Subscriber{subscriber("2", providers.StatusDeclined), subscriber("3", providers.StatusActive)},
+		map[uint64]entitlements.Entitlement{11: whitelabel},
+	))
+
+	want := []struct {
+		eventType string
+		key       string
+		discordId uint64
+		entitled  bool
+	}{
+		{TypeDeleted, "patreon:1", 0, false},
+		{TypeUpdated, "patreon:2", 0, false},
+		{TypeCreated, "patreon:3", 0, false},
+		{TypeEntitlementChanged, "", 10, false},
+		{TypeEntitlementChanged, "", 11, true},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+
+	for i, w := range want {
+		event := events[i]
+		if event.Type != w.eventType || event.DiscordId != w.discordId || (event.Entitlement != nil) != w.entitled {
+			t.Errorf("event %d: expected %+v, got %+v", i, w, event)
+		}
+
+		if w.key != "" && (event.Subscriber == nil || event.Subscriber.Key() != w.key) {
+			t.Errorf("event %d: expected subscriber %s, got %+v", i, w.key, event.Subscriber)
+		}
+	}
+
+	// Deleted subscribers are sent as they were
+	if events[0].Subscriber.Status != providers.StatusActive {
+		t.Errorf("expected the deleted subscriber's last state, got %+v", events[0].Subscriber)
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+
+	fast := b.Subscribe(2)
+	slow := b.Subscribe(1)
+
+	created := Event{Type: TypeCreated}
+	updated := Event{Type: TypeUpdated}
+	b.Publish(Snapshot{}, []Event{created, updated})
+
+	if event := <-fast.Events; event.Type != TypeCreated {
+		t.Errorf("expected a created event, got %q", event.Type)
+	}
+
+	if event := <-fast.Events; event.Type != TypeUpdated {
+		t.Errorf("expected an updated event, got %q", event.Type)
+	}
+
+	// The slow subscriber could only buffer the first event, so it is dropped rather than blocking
+	if event := <-slow.Events; event.Type != TypeCreated {
+		t.Errorf("expected a created event, got %q", event.Type)
+	}
+
+	if _, ok := <-slow.Events; ok {
+		t.Error("expected the slow subscription to be closed")
+	}
+
+	b.Unsubscribe(slow)
+	b.Unsubscribe(fast)
+
+	if _, ok := <-fast.Events; ok {
+		t.Error("expected the cancelled subscription to be closed")
+	}
+
+	// Publishing without subscribers does nothing
+	b.Publish(Snapshot{}, []Event{created})
+}
@@ -0,0 +1,351 @@
+// Package commands defines the application commands provided by the app. The same definitions are used by the
+// server to dispatch interactions, and by the Registrar to register the commands with Discord, so that the two
+// cannot drift apart.
+package commands
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/rxdn/gdl/objects/interaction"
+)
+
+// ManageGuild is the permission bit set that hides admin commands from members without the Manage Server
+// permission, unless a guild overrides it in its integration settings.
+const ManageGuild = "32"
+
+type Command struct {
+	Name        string
+	Description string
+	Options     []interaction.ApplicationCommandOption
+	Type        interaction.ApplicationCommandType
+
+	// DefaultMemberPermissions is the permission bit set members need to see the command, or empty for everyone.
+	DefaultMemberPermissions string
+
+	// UserFacing commands only act on the user running them, so they can be used outside the allowed guilds, e.g. in
+	// the servers the user is giving premium to.
+	UserFacing bool
+}
+
+var Lookup = Command{
+	Name:        "lookup",
+	Description: "Look up information about a user's subscription",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "email",
+			Description: "The Patreon email address of the user to lookup",
+			Required:    true,
+		},
+		{
+			Type:        interaction.OptionTypeBoolean,
+			Name:        "public",
+			Description: "Post the result visibly in the channel (privileged roles only)",
+		},
+		{
+			Type:        interaction.OptionTypeBoolean,
+			Name:        "show_email",
+			Description: "Show the full email address (privileged roles only)",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var MySubscription = Command{
+	Name:        "mysubscription",
+	Description: "Check the status of your own subscription",
+	Type:        interaction.ApplicationCommandTypeChatInput,
+	UserFacing:  true,
+}
+
+var BulkLookup = Command{
+	Name:        "bulk-lookup",
+	Description: "Look up many users at once, by email address or Discord ID",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeAttachment,
+			Name:        "file",
+			Description: "A CSV or text file of email addresses or Discord IDs",
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "values",
+			Description: "A comma-separated list of email addresses or Discord IDs",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Export = Command{
+	Name:        "export",
+	Description: "Export the current patron list as a file (admin only)",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "format",
+			Description: "The file format, CSV by default",
+			Choices: []interaction.ApplicationCommandOptionChoice{
+				{Name: "CSV", Value: "csv"},
+				{Name: "NDJSON", Value: "ndjson"},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "columns",
+			Description: "A comma-separated list of columns to include, all by default",
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "tiers",
+			Description: "Only include patrons entitled to one of these comma-separated tier IDs",
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "status",
+			Description: "Only include patrons with this patron status",
+			Choices: []interaction.ApplicationCommandOptionChoice{
+				{Name: "Active", Value: providers.StatusActive},
+				{Name: "Declined", Value: providers.StatusDeclined},
+				{Name: "Former", Value: providers.StatusFormer},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "charge_status",
+			Description: "Only include patrons whose last charge had this status, e.g. Paid or Declined",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Stats = Command{
+	Name:                     "stats",
+	Description:              "Show statistics about the campaign's patrons (admin only)",
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Trend = Command{
+	Name:        "trend",
+	Description: "Show how a campaign metric has changed over time (admin only)",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "metric",
+			Description: "The metric to show, active patrons by default",
+			Choices: []interaction.ApplicationCommandOptionChoice{
+				{Name: "Active patrons", Value: "active"},
+				{Name: "All patrons", Value: "total"},
+				{Name: "Declined patrons", Value: "declined"},
+				{Name: "Estimated revenue", Value: "revenue"},
+				{Name: "New pledges", Value: "new_pledges"},
+				{Name: "Churned patrons", Value: "churned"},
+				{Name: "Declined charges", Value: "declines"},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeInteger,
+			Name:        "days",
+			Description: "How many days to show, 30 by default",
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "format",
+			Description: "Show a text summary or a chart",
+			Choices: []interaction.ApplicationCommandOptionChoice{
+				{Name: "Text", Value: "text"},
+				{Name: "Chart", Value: "png"},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "tier",
+			Description: "Only count active patrons entitled to this tier ID",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Premium = Command{
+	Name:        "premium",
+	Description: "Choose which servers receive your premium",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeSubCommand,
+			Name:        "assign",
+			Description: "Give a server your premium",
+			Options: []interaction.ApplicationCommandOption{
+				{
+					Type:        interaction.OptionTypeString,
+					Name:        "guild",
+					Description: "The ID of the server",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeSubCommand,
+			Name:        "unassign",
+			Description: "Remove your premium from a server",
+			Options: []interaction.ApplicationCommandOption{
+				{
+					Type:        interaction.OptionTypeString,
+					Name:        "guild",
+					Description: "The ID of the server",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeSubCommand,
+			Name:        "list",
+			Description: "List the servers you have given your premium",
+		},
+	},
+	Type:       interaction.ApplicationCommandTypeChatInput,
+	UserFacing: true,
+}
+
+var Grant = Command{
+	Name:        "grant",
+	Description: "Give a user an entitlement without a subscription (admin only)",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeUser,
+			Name:        "user",
+			Description: "The user to give the entitlement to",
+			Required:    true,
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "type",
+			Description: "What to give the user",
+			Required:    true,
+			Choices: []interaction.ApplicationCommandOptionChoice{
+				{Name: "Premium", Value: entitlements.TypePremium},
+				{Name: "Whitelabel", Value: entitlements.TypeWhitelabel},
+			},
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "reason",
+			Description: "Why the user is being given the entitlement",
+			Required:    true,
+		},
+		{
+			Type:        interaction.OptionTypeInteger,
+			Name:        "max_guilds",
+			Description: "How many servers the user can assign it to, 1 by default",
+		},
+		{
+			Type:        interaction.OptionTypeString,
+			Name:        "expires",
+			Description: "When it expires, as a duration (e.g. 30d) or a date (YYYY-MM-DD). Never by default",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Revoke = Command{
+	Name:        "revoke",
+	Description: "Revoke a user's granted entitlement (admin only)",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeUser,
+			Name:        "user",
+			Description: "The user to revoke the entitlement from",
+			Required:    true,
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+var Grants = Command{
+	Name:        "grants",
+	Description: "List granted entitlements (admin only)",
+	Options: []interaction.ApplicationCommandOption{
+		{
+			Type:        interaction.OptionTypeBoolean,
+			Name:        "all",
+			Description: "Include grants that have expired or been revoked",
+		},
+	},
+	Type:                     interaction.ApplicationCommandTypeChatInput,
+	DefaultMemberPermissions: ManageGuild,
+}
+
+// All returns every command that should be registered.
+func All() []Command {
+	return []Command{
+		Lookup,
+		MySubscription,
+		BulkLookup,
+		Export,
+		Stats,
+		Trend,
+		Premium,
+		Grant,
+		Revoke,
+		Grants,
+	}
+}
+
+// Find returns the command with the given name, if there is one.
+func Find(name string) (Command, bool) {
+	for _, cmd := range All() {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+
+	return Command{}, false
+}
+
+// Data converts the command into the payload sent to Discord, with names and descriptions translated from the
+// catalogue keys commands.<name>.name, commands.<name>.description, commands.<name>.options.<option>.name and so on.
+func (c Command) Data(catalogue *i18n.Catalogue) CommandData {
+	prefix := "commands." + c.Name
+
+	return CommandData{
+		Name:                     c.Name,
+		NameLocalizations:        catalogue.Localizations(prefix + ".name"),
+		Description:              c.Description,
+		DescriptionLocalizations: catalogue.Localizations(prefix + ".description"),
+		Options:                  optionData(catalogue, prefix, c.Options),
+		Type:                     c.Type,
+		DefaultMemberPermissions: c.DefaultMemberPermissions,
+	}
+}
+
+func optionData(catalogue *i18n.Catalogue, prefix string, options []interaction.ApplicationCommandOption) []OptionData {
+	if len(options) == 0 {
+		return nil
+	}
+
+	data := make([]OptionData, len(options))
+	for i, option := range options {
+		optionPrefix := prefix + ".options." + option.Name
+
+		data[i] = OptionData{
+			Type:                     option.Type,
+			Name:                     option.Name,
+			NameLocalizations:        catalogue.Localizations(optionPrefix + ".name"),
+			Description:              option.Description,
+			DescriptionLocalizations: catalogue.Localizations(optionPrefix + ".description"),
+			Required:                 option.Required,
+			Choices:                  option.Choices,
+			Options:                  optionData(catalogue, optionPrefix, option.Options),
+			ChannelTypes:             option.ChannelTypes,
+			Autocomplete:             option.Autocomplete,
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"github.com/rxdn/gdl/objects/channel"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/rest/ratelimit"
+	"github.com/rxdn/gdl/rest/request"
+)
+
+// CommandData is the application command payload sent to and received from Discord. gdl's equivalent types do not
+// support localizations, so the commands endpoints are called directly.
+type CommandData struct {
+	Id                       uint64                             `json:"id,string,omitempty"`
+	Name                     string                             `json:"name"`
+	NameLocalizations        map[string]string                  `json:"name_localizations,omitempty"`
+	Description              string                             `json:"description"`
+	DescriptionLocalizations map[string]string                  `json:"description_localizations,omitempty"`
+	Options                  []OptionData                       `json:"options,omitempty"`
+	Type                     interaction.ApplicationCommandType `json:"type"`
+	DefaultMemberPermissions string                             `json:"default_member_permissions,omitempty"`
+}
+
+type OptionData struct {
+	Type                     interaction.ApplicationCommandOptionType     `json:"type"`
+	Name                     string                                       `json:"name"`
+	NameLocalizations        map[string]string                            `json:"name_localizations,omitempty"`
+	Description              string                                       `json:"description"`
+	DescriptionLocalizations map[string]string                            `json:"description_localizations,omitempty"`
+	Required                 bool                                         `json:"required,omitempty"`
+	Choices                  []interaction.ApplicationCommandOptionChoice `json:"choices,omitempty"`
+	Options                  []OptionData                                 `json:"options,omitempty"`
+	ChannelTypes             []channel.ChannelType                        `json:"channel_types,omitempty"`
+	Autocomplete             bool                                         `json:"autocomplete,omitempty"`
+}
+
+func commandsPath(applicationId, guildId uint64) string {
+	if guildId == 0 {
+		return fmt.Sprintf("/applications/%d/commands", applicationId)
+	} else {
+		return fmt.Sprintf("/applications/%d/guilds/%d/commands", applicationId, guildId)
+	}
+}
+
+func getCommands(ctx context.Context, token string, applicationId, guildId uint64) ([]CommandData, error) {
+	endpoint := request.Endpoint{
+		RequestType: request.GET,
+		ContentType: request.Nil,
+		Endpoint:    commandsPath(applicationId, guildId) + "?with_localizations=true",
+		Route:       ratelimit.NewApplicationRoute(ratelimit.RouteGetGlobalCommands, applicationId),
+	}
+
+	var commands []CommandData
+	err, _ := endpoint.Request(ctx, token, nil, &commands)
+	return commands, err
+}
+
+func overwriteCommands(ctx context.Context, token string, applicationId, guildId uint64, data []CommandData) error {
+	endpoint := request.Endpoint{
+		RequestType: request.PUT,
+		ContentType: request.ApplicationJson,
+		Endpoint:    commandsPath(applicationId, guildId),
+		Route:       ratelimit.NewApplicationRoute(ratelimit.RouteModifyGlobalCommands, applicationId),
+	}
+
+	err, _ := endpoint.Request(ctx, token, data, nil)
+	return err
+}
@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff describes the changes needed to bring the commands registered in a scope in line with the definitions.
+type Diff struct {
+	GuildId   uint64 // 0 for global commands
+	Create    []CommandData
+	Update    []CommandData
+	Delete    []CommandData
+	Unchanged []CommandData
+}
+
+func (d Diff) HasChanges() bool {
+	return len(d.Create) > 0 || len(d.Update) > 0 || len(d.Delete) > 0
+}
+
+func (d Diff) String() string {
+	var b strings.Builder
+
+	if d.GuildId == 0 {
+		b.WriteString("Global commands:\n")
+	} else {
+		_, _ = fmt.Fprintf(&b, "Guild %d commands:\n", d.GuildId)
+	}
+
+	for _, cmd := range d.Create {
+		_, _ = fmt.Fprintf(&b, "  + %s\n", cmd.Name)
+	}
+
+	for _, cmd := range d.Update {
+		_, _ = fmt.Fprintf(&b, "  ~ %s\n", cmd.Name)
+	}
+
+	for _, cmd := range d.Delete {
+		_, _ = fmt.Fprintf(&b, "  - %s\n", cmd.Name)
+	}
+
+	for _, cmd := range d.Unchanged {
+		_, _ = fmt.Fprintf(&b, "    %s\n", cmd.Name)
+	}
+
+	return b.String()
+}
+
+// ComputeDiff compares the desired command payloads with the commands currently registered in a scope.
+func ComputeDiff(guildId uint64, desired []CommandData, registered []CommandData) Diff {
+	diff := Diff{
+		GuildId: guildId,
+	}
+
+	existing := make(map[string]CommandData)
+	for _, cmd := range registered {
+		existing[cmd.Name] = cmd
+	}
+
+	for _, cmd := range desired {
+		current, ok := existing[cmd.Name]
+		if !ok {
+			diff.Create = append(diff.Create, cmd)
+			continue
+		}
+
+		delete(existing, cmd.Name)
+
+		if equal(cmd, current) {
+			diff.Unchanged = append(diff.Unchanged, cmd)
+		} else {
+			diff.Update = append(diff.Update, cmd)
+		}
+	}
+
+	for _, cmd := range existing {
+		diff.Delete = append(diff.Delete, cmd)
+	}
+
+	sort.Slice(diff.Delete, func(i, j int) bool {
+		return diff.Delete[i].Name < diff.Delete[j].Name
+	})
+
+	return diff
+}
+
+// equal compares the JSON encoding of two commands, ignoring their IDs. Encoding the commands normalises nil and
+// empty values, and the types of option choice values, which may be decoded differently to how they were defined.
+func equal(a, b CommandData) bool {
+	a.Id, b.Id = 0, 0
+
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	return bytes.Equal(encodedA, encodedB)
+}
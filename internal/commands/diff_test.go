@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/rxdn/gdl/objects/interaction"
+	"testing"
+)
+
+func TestComputeDiff(t *testing.T) {
+	catalogue, err := i18n.Load()
+	if err != nil {
+		t.Fatalf("failed to load catalogue: %v", err)
+	}
+
+	lookup := Lookup.Data(catalogue)
+
+	registered := lookup
+	registered.Id = 1234
+
+	removed := CommandData{
+		Id:          5678,
+		Name:        "removed",
+		Description: "No longer exists",
+	}
+
+	added := CommandData{
+		Name:        "added",
+		Description: "New command",
+		Type:        interaction.ApplicationCommandTypeChatInput,
+	}
+
+	diff := ComputeDiff(0, []CommandData{lookup, added}, []CommandData{registered, removed})
+	if len(diff.Create) != 1 || diff.Create[0].Name != "added" {
+		t.Errorf("expected added to be created, got %+v", diff.Create)
+	}
+
+	if len(diff.Unchanged) != 1 || diff.Unchanged[0].Name != Lookup.Name {
+		t.Errorf("expected lookup to be unchanged, got %+v", diff.Unchanged)
+	}
+
+	if len(diff.Delete) != 1 || diff.Delete[0].Name != "removed" {
+		t.Errorf("expected removed to be deleted, got %+v", diff.Delete)
+	}
+
+	changed := lookup
+	changed.Description = "Changed description"
+
+	if diff := ComputeDiff(0, []CommandData{changed}, []CommandData{registered}); len(diff.Update) != 1 {
+		t.Errorf("expected description change to be detected, got %+v", diff)
+	}
+
+	// Localizations are compared too
+	unlocalized := registered
+	unlocalized.NameLocalizations = nil
+
+	if diff := ComputeDiff(0, []CommandData{lookup}, []CommandData{unlocalized}); len(diff.Update) != 1 {
+		t.Errorf("expected localization change to be detected, got %+v", diff)
+	}
+
+	// Commands registered without default permissions are visible to everyone, so they are updated
+	unrestricted := registered
+	unrestricted.DefaultMemberPermissions = ""
+
+	if diff := ComputeDiff(0, []CommandData{lookup}, []CommandData{unrestricted}); len(diff.Update) != 1 {
+		t.Errorf("expected default permissions change to be detected, got %+v", diff)
+	}
+
+	if diff := ComputeDiff(0, []CommandData{lookup}, []CommandData{registered}); diff.HasChanges() {
+		t.Errorf("expected no changes, got %+v", diff)
+	}
+}
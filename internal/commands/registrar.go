@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/rest"
+	"go.uber.org/zap"
+)
+
+// Registrar registers the command definitions with Discord for a single application.
+type Registrar struct {
+	token         string
+	applicationId uint64
+	catalogue     *i18n.Catalogue
+	logger        *zap.Logger
+}
+
+func NewRegistrar(token string, applicationId uint64, catalogue *i18n.Catalogue, logger *zap.Logger) *Registrar {
+	return &Registrar{
+		token:         token,
+		applicationId: applicationId,
+		catalogue:     catalogue,
+		logger:        logger,
+	}
+}
+
+// NewRegistrarFromToken creates a registrar, looking up the application ID using the bot token.
+func NewRegistrarFromToken(ctx context.Context, token string, catalogue *i18n.Catalogue, logger *zap.Logger) (*Registrar, error) {
+	self, err := rest.GetCurrentUser(ctx, token, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get current user")
+	}
+
+	return NewRegistrar(token, self.Id, catalogue, logger), nil
+}
+
+func (r *Registrar) ApplicationId() uint64 {
+	return r.applicationId
+}
+
+// Diff compares the command definitions against the commands registered in the given guild, or globally if
+// guildId is 0.
+func (r *Registrar) Diff(ctx context.Context, guildId uint64) (Diff, error) {
+	registered, err := getCommands(ctx, r.token, r.applicationId, guildId)
+	if err != nil {
+		return Diff{}, errors.Wrapf(err, "failed to fetch registered commands for guild %d", guildId)
+	}
+
+	return ComputeDiff(guildId, r.data(), registered), nil
+}
+
+func (r *Registrar) data() []CommandData {
+	data := make([]CommandData, 0, len(All()))
+	for _, cmd := range All() {
+		data = append(data, cmd.Data(r.catalogue))
+	}
+
+	return data
+}
+
+// Sync registers the command definitions in each of the given guilds, or globally if no guilds are given. Scopes
+// that are already up to date are left untouched. If dryRun is true, the diffs are computed but nothing is changed.
+func (r *Registrar) Sync(ctx context.Context, guildIds []uint64, dryRun bool) ([]Diff, error) {
+	if len(guildIds) == 0 {
+		guildIds = []uint64{0}
+	}
+
+	data := r.data()
+
+	diffs := make([]Diff, 0, len(guildIds))
+	for _, guildId := range guildIds {
+		diff, err := r.Diff(ctx, guildId)
+		if err != nil {
+			return diffs, err
+		}
+
+		diffs = append(diffs, diff)
+
+		if dryRun || !diff.HasChanges() {
+			continue
+		}
+
+		r.logger.Info(
+			"Registering commands",
+			zap.Uint64("application_id", r.applicationId),
+			zap.Uint64("guild_id", guildId),
+			zap.Int("created", len(diff.Create)),
+			zap.Int("updated", len(diff.Update)),
+			zap.Int("deleted", len(diff.Delete)),
+		)
+
+		if err := overwriteCommands(ctx, r.token, r.applicationId, guildId, data); err != nil {
+			return diffs, errors.Wrapf(err, "failed to register commands for guild %d", guildId)
+		}
+	}
+
+	return diffs, nil
+}
@@ -2,9 +2,14 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/caarlos0/env/v9"
 	"github.com/pkg/errors"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,9 +17,31 @@ type Config struct {
 	ProductionMode bool    `env:"PRODUCTION_MODE" envDefault:"false" json:"production_mode"`
 	SentryDsn      *string `env:"SENTRY_DSN" json:"sentry_dsn"`
 
+	ShutdownTimeout Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s" json:"shutdown_timeout"`
+
+	// Directory for persistent state, such as the metrics history. Created if it does not exist.
+	DataDir string `env:"DATA_DIR" envDefault:"data" json:"data_dir"`
+
+	// The ISO 4217 code of the currency revenue is reported in. Patreon does not say which currency its amounts are in,
+	// so they are assumed to be in this one. Amounts paid through other providers in other currencies are left out.
+	Currency string `env:"CURRENCY" envDefault:"USD" json:"currency"`
+
 	Discord struct {
-		PublicKey     string   `env:"PUBLIC_KEY,required" json:"public_key"`
-		AllowedGuilds []uint64 `env:"ALLOWED_GUILDS,required" json:"allowed_guilds"`
+		// Deprecated: use Applications instead. If set, treated as an additional application with no ID.
+		PublicKey string `env:"PUBLIC_KEY" json:"public_key"`
+		// Used by any application which does not specify its own allowed guilds
+		AllowedGuilds []uint64      `env:"ALLOWED_GUILDS" json:"allowed_guilds"`
+		Applications  []Application `env:"APPLICATIONS" json:"applications"`
+		// Used by the legacy application configured via PublicKey
+		BotToken string `env:"BOT_TOKEN" json:"bot_token"`
+		// One of "", "global" or "guild". If set, commands are registered for each application with a bot token
+		// on startup.
+		SyncCommands string `env:"SYNC_COMMANDS" json:"sync_commands"`
+
+		MaxTimestampAge Duration `env:"MAX_TIMESTAMP_AGE" envDefault:"5m" json:"max_timestamp_age"`
+
+		// Members with any of these roles may use admin-only commands, such as /export
+		AdminRoles []uint64 `env:"ADMIN_ROLES" json:"admin_roles"`
 	} `envPrefix:"DISCORD_" json:"discord"`
 
 	Patreon struct {
@@ -22,9 +49,280 @@ type Config struct {
 		ClientSecret      string `env:"CLIENT_SECRET,required" json:"client_secret"`
 		CampaignId        int    `env:"CAMPAIGN_ID,required" json:"campaign_id"`
 		RequestsPerMinute int    `env:"REQUESTS_PER_MINUTE" envDefault:"100" json:"requests_per_minute"`
+		BaseUrl           string `env:"BASE_URL" json:"base_url"`
 	} `envPrefix:"PATREON_" json:"patreon"`
 
+	// Subscriptions sold through Discord, fetched with the bot token of each application that has an ID. SKU IDs are
+	// used as tier IDs, so each SKU is named in Tiers and given an entitlement in Entitlements like a Patreon tier.
+	DiscordSubscriptions struct {
+		Enabled         bool     `env:"ENABLED" json:"enabled"`
+		RefreshInterval Duration `env:"REFRESH_INTERVAL" envDefault:"10m" json:"refresh_interval"`
+		BaseUrl         string   `env:"BASE_URL" json:"base_url"`
+	} `envPrefix:"DISCORD_SUBSCRIPTIONS_" json:"discord_subscriptions"`
+
+	// Subscriptions paid directly through Stripe, received at /webhooks/stripe. Enabled if WebhookSecret is set. Prices
+	// maps the ID of each Stripe price to the tier it grants, which is named in Tiers and given an entitlement in
+	// Entitlements like a Patreon tier. The API key is optional, and is only used to look up the email address and
+	// Discord ID of a subscription's customer.
+	Stripe struct {
+		WebhookSecret string            `env:"WEBHOOK_SECRET" json:"webhook_secret"`
+		ApiKey        string            `env:"API_KEY" json:"api_key"`
+		BaseUrl       string            `env:"BASE_URL" json:"base_url"`
+		Prices        map[string]uint64 `env:"PRICES" json:"prices"`
+	} `envPrefix:"STRIPE_" json:"stripe"`
+
+	// Donations and memberships paid through Ko-fi, received at /webhooks/kofi. Enabled if VerificationToken is set.
+	// Tiers maps the name of each Ko-fi membership tier to the tier it grants.
+	Kofi struct {
+		VerificationToken string            `env:"VERIFICATION_TOKEN" json:"verification_token"`
+		Tiers             map[string]uint64 `env:"TIERS" json:"tiers"`
+	} `envPrefix:"KOFI_" json:"kofi"`
+
+	// Shares the entitlement of each Discord user, and events for changes to subscribers, with other services through
+	// Redis. Enabled if Addr is set. Keys are rewritten after every update, so the TTL must be longer than the time
+	// between updates; if the app stops updating them, they expire rather than going stale.
+	Redis struct {
+		Addr      string   `env:"ADDR" json:"addr"`
+		Password  string   `env:"PASSWORD" json:"password"`
+		Db        int      `env:"DB" json:"db"`
+		KeyPrefix string   `env:"KEY_PREFIX" envDefault:"subscriptions:" json:"key_prefix"`
+		Ttl       Duration `env:"TTL" envDefault:"10m" json:"ttl"`
+		Channel   string   `env:"CHANNEL" envDefault:"subscriptions:events" json:"channel"`
+	} `envPrefix:"REDIS_" json:"redis"`
+
+	// Outbound webhooks, which are sent an event for every change to a subscriber or entitlement. Deliveries are
+	// queued in the data directory, and retried with exponential backoff from InitialBackoff up to MaxBackoff, until
+	// MaxAttempts have failed. Once an endpoint has MaxPending deliveries queued, the oldest are marked as failed to
+	// make room for new ones.
+	Webhooks struct {
+		Endpoints      []WebhookEndpoint `env:"ENDPOINTS" json:"endpoints"`
+		MaxAttempts    int               `env:"MAX_ATTEMPTS" envDefault:"10" json:"max_attempts"`
+		InitialBackoff Duration          `env:"INITIAL_BACKOFF" envDefault:"30s" json:"initial_backoff"`
+		MaxBackoff     Duration          `env:"MAX_BACKOFF" envDefault:"1h" json:"max_backoff"`
+		Timeout        Duration          `env:"TIMEOUT" envDefault:"10s" json:"timeout"`
+		MaxPending     int               `env:"MAX_PENDING" envDefault:"10000" json:"max_pending"`
+	} `envPrefix:"WEBHOOKS_" json:"webhooks"`
+
 	Tiers map[uint64]string `env:"TIERS" json:"tiers"`
+	// What each tier entitles its patrons to. Tiers without an entitlement grant nothing.
+	Entitlements []TierEntitlement `env:"ENTITLEMENTS" json:"entitlements"`
+
+	// The HTTP API under /api/v1 is disabled unless at least one key is set. Clients authenticate with an
+	// "Authorization: Bearer <key>" header.
+	Api struct {
+		Keys []string `env:"KEYS" json:"keys"`
+	} `envPrefix:"API_" json:"api"`
+
+	// The gRPC service defined in pkg/subscriptionspb, served alongside the HTTP server. Enabled if Addr is set. Clients
+	// authenticate with the same keys as the HTTP API, sent as "authorization: Bearer <key>" metadata.
+	Grpc struct {
+		Addr string `env:"ADDR" json:"addr"`
+		// How many events a WatchChanges stream may fall behind by before it is closed
+		StreamBuffer int `env:"STREAM_BUFFER" envDefault:"1000" json:"stream_buffer"`
+	} `envPrefix:"GRPC_" json:"grpc"`
+
+	// The defaults are private: lookups are ephemeral, and emails are masked
+	Privacy struct {
+		PublicLookups bool `env:"PUBLIC_LOOKUPS" json:"public_lookups"`
+		ShowEmails    bool `env:"SHOW_EMAILS" json:"show_emails"`
+		// Members with any of these roles may override the policy for a single lookup
+		PrivilegedRoles []uint64 `env:"PRIVILEGED_ROLES" json:"privileged_roles"`
+	} `envPrefix:"PRIVACY_" json:"privacy"`
+
+	// Follow-up of declined payments. Cases are recorded whenever this is enabled, while notifications are only sent
+	// if a staff channel is set or reminders are enabled.
+	Declines struct {
+		Enabled        bool   `env:"ENABLED" json:"enabled"`
+		StaffChannelId uint64 `env:"STAFF_CHANNEL_ID" json:"staff_channel_id"`
+		SendReminders  bool   `env:"SEND_REMINDERS" json:"send_reminders"`
+		// How long after the decline is detected to send the reminder
+		GracePeriod Duration `env:"GRACE_PERIOD" envDefault:"72h" json:"grace_period"`
+		// A Go text/template, executed with declines.ReminderData
+		ReminderMessage string `env:"REMINDER_MESSAGE" json:"reminder_message"`
+		// Defaults to the bot token of the first application that has one
+		BotToken string `env:"BOT_TOKEN" json:"bot_token"`
+	} `envPrefix:"DECLINES_" json:"declines"`
+
+	// How many days patrons keep their previous entitlement after their pledge lapses, counted from their last charge
+	// date. 0 disables the grace period for that kind of lapse.
+	Grace struct {
+		DeclinedDays  int `env:"DECLINED_DAYS" json:"declined_days"`
+		CancelledDays int `env:"CANCELLED_DAYS" json:"cancelled_days"`
+	} `envPrefix:"GRACE_" json:"grace"`
+
+	// Embeds overrides the default embed templates, keyed by template name. As templates are difficult to express in
+	// environment variables, EmbedsFile may instead point to a JSON file containing the same map.
+	Embeds     map[string]EmbedTemplate `json:"embeds"`
+	EmbedsFile string                   `env:"EMBEDS_FILE" json:"embeds_file"`
+}
+
+// EmbedTemplate describes an embed, where each string is a Go text/template.
+type EmbedTemplate struct {
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	Url         string               `json:"url"`
+	Color       string               `json:"color"` // Hex (#eb4034) or decimal, after rendering
+	Footer      string               `json:"footer"`
+	Fields      []EmbedFieldTemplate `json:"fields"`
+}
+
+type EmbedFieldTemplate struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Application is a Discord application that interactions are accepted from. An application ID may be listed more
+// than once with different public keys, allowing keys to be rotated without downtime.
+type Application struct {
+	ApplicationId uint64   `json:"application_id"`
+	PublicKey     string   `json:"public_key"`
+	AllowedGuilds []uint64 `json:"allowed_guilds"`
+	BotToken      string   `json:"bot_token"` // Optional, only required to register commands or fetch Discord subscriptions
+}
+
+// TierEntitlement describes what a Patreon tier entitles its patrons to.
+type TierEntitlement struct {
+	TierId    uint64 `json:"tier_id"`
+	Type      string `json:"type"` // "premium" or "whitelabel"
+	MaxGuilds int    `json:"max_guilds"`
+	// If a patron is entitled to more than one tier, the entitlement with the highest priority applies
+	Priority      int  `json:"priority"`
+	LegacyPricing bool `json:"legacy_pricing"`
+}
+
+// WebhookEndpoint is a URL that events are delivered to, signed with its secret.
+type WebhookEndpoint struct {
+	Name   string   `json:"name"` // Identifies the endpoint in logs and the API
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"` // The types of event to send, or empty for every type
+}
+
+const (
+	SyncCommandsGlobal = "global"
+	SyncCommandsGuild  = "guild"
+)
+
+// Applications returns the configured applications, including the legacy DISCORD_PUBLIC_KEY if set. Applications
+// without their own allowed guilds inherit DISCORD_ALLOWED_GUILDS.
+func (c Config) Applications() []Application {
+	applications := make([]Application, 0, len(c.Discord.Applications)+1)
+	for _, app := range c.Discord.Applications {
+		if len(app.AllowedGuilds) == 0 {
+			app.AllowedGuilds = c.Discord.AllowedGuilds
+		}
+
+		applications = append(applications, app)
+	}
+
+	if c.Discord.PublicKey != "" {
+		applications = append(applications, Application{
+			PublicKey:     c.Discord.PublicKey,
+			AllowedGuilds: c.Discord.AllowedGuilds,
+			BotToken:      c.Discord.BotToken,
+		})
+	}
+
+	return applications
+}
+
+// parseApplication parses an application from an environment variable, in the format
+// application_id:public_key[:guild_id;guild_id...[:bot_token]]
+func parseApplication(value string) (any, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return nil, fmt.Errorf("invalid application %q, expected application_id:public_key[:guild_id;guild_id...[:bot_token]]", value)
+	}
+
+	applicationId, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid application ID %q", parts[0])
+	}
+
+	app := Application{
+		ApplicationId: applicationId,
+		PublicKey:     parts[1],
+	}
+
+	if len(parts) >= 3 && parts[2] != "" {
+		for _, raw := range strings.Split(parts[2], ";") {
+			guildId, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid guild ID %q", raw)
+			}
+
+			app.AllowedGuilds = append(app.AllowedGuilds, guildId)
+		}
+	}
+
+	if len(parts) == 4 {
+		app.BotToken = parts[3]
+	}
+
+	return app, nil
+}
+
+// parseTierEntitlement parses a tier entitlement from an environment variable, in the format
+// tier_id:type:max_guilds:priority[:legacy]
+func parseTierEntitlement(value string) (any, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 4 || len(parts) > 5 {
+		return nil, fmt.Errorf("invalid entitlement %q, expected tier_id:type:max_guilds:priority[:legacy]", value)
+	}
+
+	tierId, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid tier ID %q", parts[0])
+	}
+
+	maxGuilds, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid max guilds %q", parts[2])
+	}
+
+	priority, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid priority %q", parts[3])
+	}
+
+	entitlement := TierEntitlement{
+		TierId:    tierId,
+		Type:      parts[1],
+		MaxGuilds: maxGuilds,
+		Priority:  priority,
+	}
+
+	if len(parts) == 5 {
+		if parts[4] != "legacy" {
+			return nil, fmt.Errorf("invalid entitlement flag %q, expected legacy", parts[4])
+		}
+
+		entitlement.LegacyPricing = true
+	}
+
+	return entitlement, nil
+}
+
+// parseWebhookEndpoint parses a webhook endpoint from an environment variable, in the format
+// name|url|secret[|type;type...]. The URL may contain colons, so | is used as the separator.
+func parseWebhookEndpoint(value string) (any, error) {
+	parts := strings.Split(value, "|")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("invalid webhook endpoint %q, expected name|url|secret[|type;type...]", value)
+	}
+
+	endpoint := WebhookEndpoint{
+		Name:   parts[0],
+		Url:    parts[1],
+		Secret: parts[2],
+	}
+
+	if len(parts) == 4 && parts[3] != "" {
+		endpoint.Events = strings.Split(parts[3], ";")
+	}
+
+	return endpoint, nil
 }
 
 func LoadConfig() (Config, error) {
@@ -39,12 +337,137 @@ func LoadConfig() (Config, error) {
 			return Config{}, errors.Wrap(err, "failed to decode config.json")
 		}
 	} else if errors.Is(err, os.ErrNotExist) { // If config.json does not exist, load from envvars
-		if err := env.Parse(&conf); err != nil {
+		opts := env.Options{
+			FuncMap: map[reflect.Type]env.ParserFunc{
+				reflect.TypeOf(Application{}):     parseApplication,
+				reflect.TypeOf(TierEntitlement{}): parseTierEntitlement,
+				reflect.TypeOf(WebhookEndpoint{}): parseWebhookEndpoint,
+			},
+		}
+
+		if err := env.ParseWithOptions(&conf, opts); err != nil {
 			return Config{}, errors.Wrap(err, "failed to parse env vars")
 		}
 	} else {
 		return conf, errors.Wrap(err, "failed to check if config.json exists")
 	}
 
+	if conf.EmbedsFile != "" {
+		if err := conf.loadEmbedsFile(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	conf.setDefaults()
+
 	return conf, nil
 }
+
+// loadEmbedsFile merges the templates from EmbedsFile into Embeds, with templates defined directly taking priority.
+func (c *Config) loadEmbedsFile() error {
+	f, err := os.Open(c.EmbedsFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to open embeds file")
+	}
+
+	defer f.Close()
+
+	var embeds map[string]EmbedTemplate
+	if err := json.NewDecoder(f).Decode(&embeds); err != nil {
+		return errors.Wrap(err, "failed to decode embeds file")
+	}
+
+	if c.Embeds == nil {
+		c.Embeds = make(map[string]EmbedTemplate)
+	}
+
+	for name, tmpl := range embeds {
+		if _, ok := c.Embeds[name]; !ok {
+			c.Embeds[name] = tmpl
+		}
+	}
+
+	return nil
+}
+
+// setDefaults fills in zero-valued fields that have a default value, as envDefault tags are not applied when
+// loading from config.json.
+func (c *Config) setDefaults() {
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = Duration(time.Second * 10)
+	}
+
+	if c.DataDir == "" {
+		c.DataDir = "data"
+	}
+
+	if c.Currency == "" {
+		c.Currency = "USD"
+	}
+
+	if c.Discord.MaxTimestampAge == 0 {
+		c.Discord.MaxTimestampAge = Duration(time.Minute * 5)
+	}
+
+	if c.Declines.GracePeriod == 0 {
+		c.Declines.GracePeriod = Duration(time.Hour * 72)
+	}
+
+	if c.Patreon.RequestsPerMinute == 0 {
+		c.Patreon.RequestsPerMinute = 100
+	}
+
+	if c.DiscordSubscriptions.RefreshInterval == 0 {
+		c.DiscordSubscriptions.RefreshInterval = Duration(time.Minute * 10)
+	}
+
+	if c.Redis.KeyPrefix == "" {
+		c.Redis.KeyPrefix = "subscriptions:"
+	}
+
+	if c.Redis.Ttl == 0 {
+		c.Redis.Ttl = Duration(time.Minute * 10)
+	}
+
+	if c.Redis.Channel == "" {
+		c.Redis.Channel = "subscriptions:events"
+	}
+
+	if c.Webhooks.MaxAttempts == 0 {
+		c.Webhooks.MaxAttempts = 10
+	}
+
+	if c.Webhooks.InitialBackoff == 0 {
+		c.Webhooks.InitialBackoff = Duration(time.Second * 30)
+	}
+
+	if c.Webhooks.MaxBackoff == 0 {
+		c.Webhooks.MaxBackoff = Duration(time.Hour)
+	}
+
+	if c.Webhooks.Timeout == 0 {
+		c.Webhooks.Timeout = Duration(time.Second * 10)
+	}
+
+	if c.Webhooks.MaxPending == 0 {
+		c.Webhooks.MaxPending = 10000
+	}
+
+	if c.Grpc.StreamBuffer == 0 {
+		c.Grpc.StreamBuffer = 1000
+	}
+}
+
+// TierNames returns the configured name of each tier, or the tier ID if it has no name.
+func (c Config) TierNames(tiers []uint64) []string {
+	names := make([]string, len(tiers))
+	for i, tier := range tiers {
+		if name, ok := c.Tiers[tier]; ok {
+			names[i] = name
+		} else {
+			names[i] = strconv.FormatUint(tier, 10)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration wraps time.Duration so that it can be specified as a string, such as "10s" or "1h30m", in both
+// config.json and environment variables.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,501 @@
+// Package declines follows up on subscribers whose payments are declined: staff are notified when a case is opened,
+// the subscriber is optionally reminded via DM after a grace period, and the case is closed once the payment recovers
+// or the deadline passes.
+package declines
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/privacy"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	documentName = "declines"
+	// Closed cases are kept for reference, up to this many
+	maxClosedCases = 1000
+	// Used when the subscriber has no next charge date to recover by
+	defaultRecoveryWindow = time.Hour * 24 * 30
+	// How long to wait for Discord when sending each notification
+	notificationTimeout = time.Second * 10
+)
+
+// Values of Case.Reason
+const (
+	ReasonChargeDeclined = "charge_declined"
+	ReasonStatusDeclined = "status_declined"
+)
+
+// Values of Case.Outcome
+const (
+	OutcomeRecovered = "recovered"
+	OutcomeLapsed    = "lapsed"
+)
+
+// DefaultReminderMessage links Patreon members to their membership settings. Other providers have no single page to
+// manage a subscription from, so their subscribers are asked to update their payment method wherever they subscribed.
+const DefaultReminderMessage = `{{if eq .Provider "patreon"}}Hi! The latest payment for your Patreon membership was ` +
+	`declined. Please update your payment method at https://www.patreon.com/settings/memberships before ` +
+	`{{else}}Hi! The latest payment for your subscription was declined. Please update your payment method before ` +
+	`{{end}}<t:{{.Deadline.Unix}}:D> to keep your perks.`
+
+type Case struct {
+	PatronId        uint64     `json:"patron_id"` // The subscriber's Subscriber.PatronId, or 0 for other providers
+	Email           string     `json:"email"`
+	DiscordId       *uint64    `json:"discord_id,string,omitempty"`
+	Tiers           []uint64   `json:"tiers"`
+	Reason          string     `json:"reason"`
+	ChargeDate      time.Time  `json:"charge_date"` // The date of the declined charge
+	OpenedAt        time.Time  `json:"opened_at"`
+	Deadline        time.Time  `json:"deadline"`
+	StaffNotifiedAt *time.Time `json:"staff_notified_at,omitempty"`
+	ReminderSentAt  *time.Time `json:"reminder_sent_at,omitempty"`
+	ReminderFailed  bool       `json:"reminder_failed,omitempty"`
+	Outcome         string     `json:"outcome,omitempty"` // Empty while the case is open
+	ClosedAt        *time.Time `json:"closed_at,omitempty"`
+	// Opened from the first snapshot after the workflow was enabled, so the subscriber may have been declined long
+	// before. No notification or reminder is sent for these cases.
+	Backfilled bool `json:"backfilled,omitempty"`
+	// The subscriber's Subscriber.Key. Cases opened before there was more than one provider have only a PatronId.
+	Key string `json:"key"`
+}
+
+func (c Case) Open() bool {
+	return c.Outcome == ""
+}
+
+type document struct {
+	Cases       []Case `json:"cases"`
+	Initialised bool   `json:"initialised"`
+}
+
+// ReminderData is passed to the reminder message template.
+type ReminderData struct {
+	Provider  string // The name of the provider the subscription is with, e.g. patreon
+	TierNames []string
+	Deadline  time.Time
+}
+
+type Tracker struct {
+	config    config.Config
+	store     *store.Store
+	notifier  Notifier
+	embeds    *embeds.Renderer
+	localizer i18n.Localizer
+	reminder  *template.Template
+	logger    *zap.Logger
+
+	processMu sync.Mutex // Held for the whole of Process, so that notifications are not sent twice
+	mu        sync.RWMutex
+	doc       document
+}
+
+// Kinds of notification
+const (
+	notificationStaffOpened = iota
+	notificationStaffOutcome
+	notificationReminder
+)
+
+// notification is a message that is due to be sent. Messages are rendered while the cases are updated, and sent
+// after the lock is released, so that a slow Discord API does not block readers.
+type notification struct {
+	kind     int
+	key      string    // The case's Key
+	openedAt time.Time // The case's OpenedAt, which with the key identifies the case
+	embed    *embed.Embed
+	userId   uint64 // The user to send reminders to
+	content  string
+}
+
+// NewTracker loads previously recorded cases from the store. The notifier is only used if a staff channel is set
+// or reminders are enabled. Staff messages are rendered with the renderer's event template.
+func NewTracker(
+	conf config.Config,
+	store *store.Store,
+	notifier Notifier,
+	renderer *embeds.Renderer,
+	localizer i18n.Localizer,
+	logger *zap.Logger,
+) (*Tracker, error) {
+	message := conf.Declines.ReminderMessage
+	if message == "" {
+		message = DefaultReminderMessage
+	}
+
+	reminder, err := template.New("reminder").Parse(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse reminder message template")
+	}
+
+	t := &Tracker{
+		config:    conf,
+		store:     store,
+		notifier:  notifier,
+		embeds:    renderer,
+		localizer: localizer,
+		reminder:  reminder,
+		logger:    logger,
+	}
+
+	if err := store.Load(documentName, &t.doc); err != nil {
+		return nil, err
+	}
+
+	for i, c := range t.doc.Cases {
+		if c.Key == "" && c.PatronId != 0 {
+			t.doc.Cases[i].Key = providers.MigrateKey(strconv.FormatUint(c.PatronId, 10))
+		}
+	}
+
+	return t, nil
+}
+
+// Process updates the cases from a snapshot, sending any notifications and reminders that are due.
+func (t *Tracker) Process(ctx context.Context, now time.Time, subscribers []providers.Subscriber) error {
+	t.processMu.Lock()
+	defer t.processMu.Unlock()
+
+	notifications, err := t.update(now, subscribers)
+	if err != nil {
+		return err
+	}
+
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	sent := make([]bool, len(notifications))
+	for i, n := range notifications {
+		if err := t.send(ctx, n); err != nil {
+			t.logger.Warn("Failed to send declined payment notification", zap.String("key", n.key), zap.Int("kind", n.kind), zap.Error(err))
+			continue
+		}
+
+		sent[i] = true
+	}
+
+	return t.recordSent(now, notifications, sent)
+}
+
+// update opens and closes cases from the snapshot, and returns the notifications that are due.
+func (t *Tracker) update(now time.Time, subscribers []providers.Subscriber) ([]notification, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var notifications []notification
+
+	byKey := make(map[string]providers.Subscriber, len(subscribers))
+	for _, subscriber := range subscribers {
+		byKey[subscriber.Key()] = subscriber
+	}
+
+	open := make(map[string]bool)
+	lapsedCharges := make(map[string]time.Time) // Key -> charge date of the most recent lapsed case
+	for i := range t.doc.Cases {
+		c := &t.doc.Cases[i]
+		if !c.Open() {
+			if c.Outcome == OutcomeLapsed {
+				lapsedCharges[c.Key] = c.ChargeDate
+			} else {
+				delete(lapsedCharges, c.Key)
+			}
+
+			continue
+		}
+
+		subscriber, ok := byKey[c.Key]
+		switch {
+		case ok && recovered(subscriber):
+			notifications = t.close(notifications, now, c, OutcomeRecovered)
+		case !ok || subscriber.Status == providers.StatusFormer || now.After(c.Deadline):
+			notifications = t.close(notifications, now, c, OutcomeLapsed)
+			lapsedCharges[c.Key] = c.ChargeDate
+		default:
+			open[c.Key] = true
+			notifications = t.notifyStaff(notifications, c)
+			notifications = t.remind(notifications, now, c)
+		}
+	}
+
+	for _, subscriber := range subscribers {
+		key := subscriber.Key()
+		if open[key] || !declined(subscriber) {
+			continue
+		}
+
+		// Don't reopen a case that has lapsed until another charge is declined
+		if chargeDate, ok := lapsedCharges[key]; ok && chargeDate.Equal(subscriber.LastChargeDate) {
+			continue
+		}
+
+		c := Case{
+			PatronId:   subscriber.PatronId(),
+			Email:      subscriber.Email,
+			DiscordId:  subscriber.DiscordId,
+			Tiers:      subscriber.Tiers,
+			Reason:     ReasonChargeDeclined,
+			ChargeDate: subscriber.LastChargeDate,
+			OpenedAt:   now,
+			Deadline:   now.Add(defaultRecoveryWindow),
+			Backfilled: !t.doc.Initialised,
+			Key:        key,
+		}
+
+		if subscriber.Status == providers.StatusDeclined {
+			c.Reason = ReasonStatusDeclined
+		}
+
+		if subscriber.NextChargeDate.After(now) {
+			c.Deadline = subscriber.NextChargeDate
+		}
+
+		t.logger.Info("Opened declined payment case", zap.String("key", c.Key), zap.String("reason", c.Reason))
+
+		t.doc.Cases = append(t.doc.Cases, c)
+		notifications = t.notifyStaff(notifications, &t.doc.Cases[len(t.doc.Cases)-1])
+	}
+
+	t.doc.Initialised = true
+	t.pruneClosed()
+
+	if err := t.store.Save(documentName, t.doc); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func (t *Tracker) send(ctx context.Context, n notification) error {
+	ctx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	defer cancel()
+
+	if n.kind == notificationReminder {
+		return t.notifier.SendDirectMessage(ctx, n.userId, n.content)
+	}
+
+	return t.notifier.SendChannelMessage(ctx, t.config.Declines.StaffChannelId, n.embed)
+}
+
+// recordSent records the outcome of each notification on its case. Staff notifications that failed are retried on
+// the next snapshot. As reminders usually fail due to the user's privacy settings, they are not retried.
+func (t *Tracker) recordSent(now time.Time, notifications []notification, sent []bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, n := range notifications {
+		c := t.find(n.key, n.openedAt)
+		if c == nil {
+			continue
+		}
+
+		switch {
+		case n.kind == notificationStaffOpened && sent[i]:
+			c.StaffNotifiedAt = &now
+		case n.kind == notificationReminder && sent[i]:
+			c.ReminderSentAt = &now
+		case n.kind == notificationReminder:
+			c.ReminderFailed = true
+		}
+	}
+
+	return t.store.Save(documentName, t.doc)
+}
+
+// find returns the case with the given key and opening time, or nil if it has been pruned. The caller must hold the
+// lock.
+func (t *Tracker) find(key string, openedAt time.Time) *Case {
+	for i := range t.doc.Cases {
+		if t.doc.Cases[i].Key == key && t.doc.Cases[i].OpenedAt.Equal(openedAt) {
+			return &t.doc.Cases[i]
+		}
+	}
+
+	return nil
+}
+
+// Cases returns the recorded cases, most recently opened first.
+func (t *Tracker) Cases() []Case {
+	t.mu.RLock()
+	cases := make([]Case, len(t.doc.Cases))
+	copy(cases, t.doc.Cases)
+	t.mu.RUnlock()
+
+	sort.SliceStable(cases, func(i, j int) bool {
+		return cases[i].OpenedAt.After(cases[j].OpenedAt)
+	})
+
+	return cases
+}
+
+func declined(subscriber providers.Subscriber) bool {
+	return subscriber.Status == providers.StatusDeclined ||
+		(subscriber.Active() && subscriber.LastChargeStatus == providers.ChargeStatusDeclined)
+}
+
+func recovered(subscriber providers.Subscriber) bool {
+	return subscriber.Active() && subscriber.LastChargeStatus != providers.ChargeStatusDeclined
+}
+
+func (t *Tracker) close(notifications []notification, now time.Time, c *Case, outcome string) []notification {
+	c.Outcome = outcome
+	c.ClosedAt = &now
+
+	t.logger.Info("Closed declined payment case", zap.String("key", c.Key), zap.String("outcome", outcome))
+
+	// Only report the outcome of cases that staff were told about
+	if c.StaffNotifiedAt == nil || t.config.Declines.StaffChannelId == 0 {
+		return notifications
+	}
+
+	return t.appendStaffNotification(notifications, notificationStaffOutcome, c)
+}
+
+// notifyStaff posts the case to the staff channel, if it has not been already.
+func (t *Tracker) notifyStaff(notifications []notification, c *Case) []notification {
+	if c.Backfilled || c.StaffNotifiedAt != nil || t.config.Declines.StaffChannelId == 0 {
+		return notifications
+	}
+
+	return t.appendStaffNotification(notifications, notificationStaffOpened, c)
+}
+
+func (t *Tracker) appendStaffNotification(notifications []notification, kind int, c *Case) []notification {
+	e, err := t.staffEmbed(c)
+	if err != nil {
+		t.logger.Error("Failed to render staff embed", zap.String("key", c.Key), zap.Error(err))
+		return notifications
+	}
+
+	return append(notifications, notification{
+		kind:     kind,
+		key:      c.Key,
+		openedAt: c.OpenedAt,
+		embed:    e,
+	})
+}
+
+// remind sends the reminder DM once the grace period has passed.
+func (t *Tracker) remind(notifications []notification, now time.Time, c *Case) []notification {
+	if !t.config.Declines.SendReminders || c.Backfilled || c.DiscordId == nil || c.ReminderSentAt != nil || c.ReminderFailed {
+		return notifications
+	}
+
+	if now.Sub(c.OpenedAt) < t.config.Declines.GracePeriod.Duration() {
+		return notifications
+	}
+
+	var content strings.Builder
+	provider, _, _ := strings.Cut(c.Key, ":")
+	data := ReminderData{
+		Provider:  provider,
+		TierNames: t.config.TierNames(c.Tiers),
+		Deadline:  c.Deadline,
+	}
+
+	if err := t.reminder.Execute(&content, data); err != nil {
+		t.logger.Error("Failed to render reminder message", zap.Error(err))
+		return notifications
+	}
+
+	return append(notifications, notification{
+		kind:     notificationReminder,
+		key:      c.Key,
+		openedAt: c.OpenedAt,
+		userId:   *c.DiscordId,
+		content:  content.String(),
+	})
+}
+
+// staffEmbed renders the case with the event embed template.
+func (t *Tracker) staffEmbed(c *Case) (*embed.Embed, error) {
+	event := embeds.EventData{
+		Type:     embeds.EventPaymentDeclined,
+		Reason:   c.Reason,
+		Deadline: c.Deadline,
+		Reminder: "not_sent",
+	}
+
+	switch c.Outcome {
+	case OutcomeRecovered:
+		event.Type = embeds.EventPaymentRecovered
+	case OutcomeLapsed:
+		event.Type = embeds.EventPaymentLapsed
+	}
+
+	if c.ReminderSentAt != nil {
+		event.Reminder = "sent"
+	} else if c.ReminderFailed {
+		event.Reminder = "failed"
+	}
+
+	provider, id, _ := strings.Cut(c.Key, ":")
+	subscriber := providers.Subscriber{
+		Provider:  provider,
+		Id:        id,
+		Email:     c.Email,
+		DiscordId: c.DiscordId,
+		Tiers:     c.Tiers,
+	}
+
+	if !t.config.Privacy.ShowEmails {
+		subscriber.Email = privacy.MaskEmail(subscriber.Email)
+	}
+
+	data := embeds.NewData(t.localizer)
+	data.Patron = &subscriber
+	data.TierNames = t.config.TierNames(c.Tiers)
+	data.Event = &event
+
+	e, err := t.embeds.Render(embeds.Event, data)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Timestamp = ptr(c.OpenedAt)
+	return e, nil
+}
+
+// pruneClosed drops the oldest closed cases beyond maxClosedCases. Open cases are always kept.
+func (t *Tracker) pruneClosed() {
+	closed := 0
+	for _, c := range t.doc.Cases {
+		if !c.Open() {
+			closed++
+		}
+	}
+
+	if closed <= maxClosedCases {
+		return
+	}
+
+	drop := closed - maxClosedCases
+	cases := t.doc.Cases[:0]
+	for _, c := range t.doc.Cases {
+		// Cases are appended as they are opened, so the first closed cases are the oldest
+		if !c.Open() && drop > 0 {
+			drop--
+			continue
+		}
+
+		cases = append(cases, c)
+	}
+
+	t.doc.Cases = cases
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,344 @@
+package declines
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+const staffChannelId = 700
+
+type directMessage struct {
+	userId  uint64
+	content string
+}
+
+type fakeNotifier struct {
+	directMessages []directMessage
+	staffMessages  []*embed.Embed
+	failDMs        bool
+}
+
+func (n *fakeNotifier) SendDirectMessage(_ context.Context, userId uint64, content string) error {
+	if n.failDMs {
+		return context.DeadlineExceeded
+	}
+
+	n.directMessages = append(n.directMessages, directMessage{userId: userId, content: content})
+	return nil
+}
+
+func (n *fakeNotifier) SendChannelMessage(_ context.Context, channelId uint64, e *embed.Embed) error {
+	if channelId == staffChannelId {
+		n.staffMessages = append(n.staffMessages, e)
+	}
+
+	return nil
+}
+
+func newTestTracker(t *testing.T, notifier Notifier) *Tracker {
+	t.Helper()
+	return newTestTrackerWithEmbeds(t, notifier, nil)
+}
+
+func newTestTrackerWithEmbeds(t *testing.T, notifier Notifier, overrides map[string]config.EmbedTemplate) *Tracker {
+	t.Helper()
+
+	var conf config.Config
+	conf.Declines.Enabled = true
+	conf.Declines.StaffChannelId = staffChannelId
+	conf.Declines.SendReminders = true
+	conf.Declines.GracePeriod = config.Duration(time.Hour * 24)
+	conf.Declines.ReminderMessage = "Tiers: {{range .TierNames}}{{.}}{{end}}"
+	conf.Tiers = map[uint64]string{1: "Premium"}
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalogue, err := i18n.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renderer, err := embeds.NewRenderer(overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := NewTracker(conf, s, notifier, renderer, catalogue.Localizer(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tracker
+}
+
+func testPatron(status, chargeStatus string, nextChargeDate time.Time) providers.Subscriber {
+	discordId := uint64(12345)
+
+	return providers.Subscriber{
+		Provider:         "patreon",
+		Id:               "1",
+		Email:            "patron@example.com",
+		DiscordId:        &discordId,
+		Status:           status,
+		LastChargeStatus: chargeStatus,
+		NextChargeDate:   nextChargeDate,
+		Tiers:            []uint64{1},
+	}
+}
+
+func process(t *testing.T, tracker *Tracker, now time.Time, subscribers ...providers.Subscriber) {
+	t.Helper()
+
+	if err := tracker.Process(context.Background(), now, subscribers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecovered(t *testing.T) {
+	notifier := &fakeNotifier{}
+	tracker := newTestTracker(t, notifier)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	deadline := start.AddDate(0, 0, 7)
+
+	process(t, tracker, start, testPatron(providers.StatusActive, providers.ChargeStatusPaid, deadline))
+	process(t, tracker, start.Add(time.Hour), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, deadline))
+
+	if len(notifier.staffMessages) != 1 || notifier.staffMessages[0].Title != "Declined Payment" {
+		t.Fatalf("expected staff to be notified of the decline, got %+v", notifier.staffMessages)
+	}
+
+	if notifier.staffMessages[0].Fields[0].Value != "p***@example.com (1)" {
+		t.Errorf("expected masked email, got %q", notifier.staffMessages[0].Fields[0].Value)
+	}
+
+	if fields := notifier.staffMessages[0].Fields; len(fields) != 6 || fields[1].Value != "<@12345>" || fields[2].Value != "Premium" || fields[5].Value != "Not sent" {
+		t.Errorf("unexpected staff message fields %+v", fields)
+	}
+
+	// Still within the grace period
+	process(t, tracker, start.Add(time.Hour*2), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, deadline))
+	if len(notifier.directMessages) != 0 {
+		t.Fatalf("expected no reminder during the grace period, got %+v", notifier.directMessages)
+	}
+
+	process(t, tracker, start.Add(time.Hour*26), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, deadline))
+	process(t, tracker, start.Add(time.Hour*27), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, deadline))
+	if len(notifier.directMessages) != 1 || notifier.directMessages[0] != (directMessage{userId: 12345, content: "Tiers: Premium"}) {
+		t.Fatalf("expected a single reminder, got %+v", notifier.directMessages)
+	}
+
+	process(t, tracker, start.Add(time.Hour*48), testPatron(providers.StatusActive, providers.ChargeStatusPaid, deadline.AddDate(0, 1, 0)))
+
+	cases := tracker.Cases()
+	if len(cases) != 1 || cases[0].Outcome != OutcomeRecovered || cases[0].Reason != ReasonChargeDeclined {
+		t.Fatalf("expected a single recovered case, got %+v", cases)
+	}
+
+	if !cases[0].Deadline.Equal(deadline) || cases[0].ReminderSentAt == nil {
+		t.Errorf("unexpected case %+v", cases[0])
+	}
+
+	if len(notifier.staffMessages) != 2 || notifier.staffMessages[1].Title != "Payment Recovered" {
+		t.Errorf("expected staff to be notified of the recovery, got %+v", notifier.staffMessages)
+	}
+}
+
+func TestLapsed(t *testing.T) {
+	notifier := &fakeNotifier{failDMs: true}
+	tracker := newTestTracker(t, notifier)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	deadline := start.AddDate(0, 0, 7)
+
+	process(t, tracker, start)
+	process(t, tracker, start.Add(time.Hour), testPatron(providers.StatusDeclined, providers.ChargeStatusDeclined, deadline))
+	process(t, tracker, start.AddDate(0, 0, 2), testPatron(providers.StatusDeclined, providers.ChargeStatusDeclined, deadline))
+	process(t, tracker, start.AddDate(0, 0, 8), testPatron(providers.StatusDeclined, providers.ChargeStatusDeclined, deadline))
+
+	cases := tracker.Cases()
+	if len(cases) != 1 || cases[0].Outcome != OutcomeLapsed || cases[0].Reason != ReasonStatusDeclined || !cases[0].ReminderFailed {
+		t.Fatalf("expected a single lapsed case, got %+v", cases)
+	}
+
+	if len(notifier.staffMessages) != 2 || notifier.staffMessages[1].Title != "Payment Not Recovered" {
+		t.Errorf("expected staff to be notified of the lapse, got %+v", notifier.staffMessages)
+	}
+
+	// The lapsed case is not reopened while the same charge remains declined
+	process(t, tracker, start.AddDate(0, 0, 9), testPatron(providers.StatusDeclined, providers.ChargeStatusDeclined, time.Time{}))
+	if cases := tracker.Cases(); len(cases) != 1 {
+		t.Fatalf("expected the lapsed case not to be reopened, got %+v", cases)
+	}
+
+	// A further decline opens a new case
+	patron := testPatron(providers.StatusDeclined, providers.ChargeStatusDeclined, time.Time{})
+	patron.LastChargeDate = start.AddDate(0, 0, 10)
+	process(t, tracker, start.AddDate(0, 0, 10), patron)
+	if cases := tracker.Cases(); len(cases) != 2 || !cases[0].Open() {
+		t.Errorf("expected a new open case, got %+v", cases)
+	}
+}
+
+func TestStaffEmbedTemplate(t *testing.T) {
+	notifier := &fakeNotifier{}
+	tracker := newTestTrackerWithEmbeds(t, notifier, map[string]config.EmbedTemplate{
+		"event": {
+			Title:       `{{ .Event.Type }}: {{ .Patron.Provider }}`,
+			Description: `{{ .Event.Reason }} until {{ .Event.Deadline.Format "2006-01-02" }}`,
+		},
+	})
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	deadline := start.AddDate(0, 0, 7)
+
+	process(t, tracker, start)
+	process(t, tracker, start.Add(time.Hour), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, deadline))
+
+	if len(notifier.staffMessages) != 1 {
+		t.Fatalf("expected staff to be notified, got %+v", notifier.staffMessages)
+	}
+
+	if e := notifier.staffMessages[0]; e.Title != "payment_declined: patreon" || e.Description != "charge_declined until 2023-01-08" || len(e.Fields) != 0 {
+		t.Errorf("expected the overridden event template to be used, got %+v", e)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	notifier := &fakeNotifier{}
+	tracker := newTestTracker(t, notifier)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Patrons that are already declined when the workflow is enabled are tracked silently
+	process(t, tracker, start, testPatron(providers.StatusActive, providers.ChargeStatusDeclined, time.Time{}))
+	process(t, tracker, start.AddDate(0, 0, 2), testPatron(providers.StatusActive, providers.ChargeStatusDeclined, time.Time{}))
+
+	if len(notifier.staffMessages) != 0 || len(notifier.directMessages) != 0 {
+		t.Errorf("expected no notifications for backfilled cases, got %+v and %+v", notifier.staffMessages, notifier.directMessages)
+	}
+
+	cases := tracker.Cases()
+	if len(cases) != 1 || !cases[0].Backfilled || !cases[0].Deadline.Equal(start.Add(defaultRecoveryWindow)) {
+		t.Errorf("unexpected cases %+v", cases)
+	}
+}
+
+func TestMigrateLegacyCases(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cases opened before there was more than one provider
+	legacy := map[string]any{
+		"initialised": true,
+		"cases":       []map[string]any{{"patron_id": 1, "reason": ReasonChargeDeclined}},
+	}
+
+	if err := s.Save(documentName, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	catalogue, err := i18n.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renderer, err := embeds.NewRenderer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := NewTracker(config.Config{}, s, &fakeNotifier{}, renderer, catalogue.Localizer(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cases := tracker.Cases(); len(cases) != 1 || cases[0].Key != "patreon:1" || cases[0].PatronId != 1 {
+		t.Errorf("expected the legacy case to be keyed by subscriber, got %+v", cases)
+	}
+}
+
+// blockingNotifier waits for release before sending each message
+type blockingNotifier struct {
+	fakeNotifier
+	sending     chan struct{}
+	release     chan struct{}
+	hasDeadline bool
+}
+
+func (n *blockingNotifier) SendChannelMessage(ctx context.Context, channelId uint64, e *embed.Embed) error {
+	_, n.hasDeadline = ctx.Deadline()
+	n.sending <- struct{}{}
+	<-n.release
+
+	return n.fakeNotifier.SendChannelMessage(ctx, channelId, e)
+}
+
+func TestNotificationsSentWithoutLock(t *testing.T) {
+	notifier := &blockingNotifier{sending: make(chan struct{}), release: make(chan struct{})}
+	tracker := newTestTracker(t, notifier)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	process(t, tracker, start)
+
+	done := make(chan error)
+	go func() {
+		done <- tracker.Process(context.Background(), start.Add(time.Hour), []providers.Subscriber{
+			testPatron(providers.StatusActive, providers.ChargeStatusDeclined, start.AddDate(0, 0, 7)),
+		})
+	}()
+
+	<-notifier.sending
+
+	// The case is readable while the staff notification is being sent
+	if cases := tracker.Cases(); len(cases) != 1 || cases[0].StaffNotifiedAt != nil {
+		t.Errorf("expected an open case that staff have not been notified of yet, got %+v", cases)
+	}
+
+	close(notifier.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if !notifier.hasDeadline {
+		t.Error("expected the notification to be sent with a timeout")
+	}
+
+	if cases := tracker.Cases(); len(cases) != 1 || cases[0].StaffNotifiedAt == nil {
+		t.Errorf("expected the staff notification to be recorded, got %+v", cases)
+	}
+}
+
+func TestDefaultReminderMessage(t *testing.T) {
+	reminder := template.Must(template.New("reminder").Parse(DefaultReminderMessage))
+	deadline := time.Date(2023, 1, 8, 0, 0, 0, 0, time.UTC)
+
+	for provider, want := range map[string]string{
+		"patreon": "https://www.patreon.com/settings/memberships",
+		"stripe":  "Hi! The latest payment for your subscription was declined. Please update your payment method before <t:1673136000:D> to keep your perks.",
+	} {
+		var content strings.Builder
+		if err := reminder.Execute(&content, ReminderData{Provider: provider, Deadline: deadline}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(content.String(), want) || !strings.Contains(content.String(), "<t:1673136000:D>") {
+			t.Errorf("%s: unexpected reminder %q", provider, content.String())
+		}
+	}
+}
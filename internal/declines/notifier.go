@@ -0,0 +1,48 @@
+package declines
+
+import (
+	"context"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/rest"
+	"github.com/rxdn/gdl/rest/ratelimit"
+)
+
+// Notifier sends the messages produced by the workflow.
+type Notifier interface {
+	SendDirectMessage(ctx context.Context, userId uint64, content string) error
+	SendChannelMessage(ctx context.Context, channelId uint64, e *embed.Embed) error
+}
+
+// DiscordNotifier sends messages through the Discord REST API as a bot.
+type DiscordNotifier struct {
+	token       string
+	rateLimiter *ratelimit.Ratelimiter
+}
+
+var _ Notifier = (*DiscordNotifier)(nil)
+
+func NewDiscordNotifier(token string) *DiscordNotifier {
+	return &DiscordNotifier{
+		token:       token,
+		rateLimiter: ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 1),
+	}
+}
+
+func (n *DiscordNotifier) SendDirectMessage(ctx context.Context, userId uint64, content string) error {
+	ch, err := rest.CreateDM(ctx, n.token, n.rateLimiter, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = rest.CreateMessage(ctx, n.token, n.rateLimiter, ch.Id, rest.CreateMessageData{
+		Content: content,
+	})
+	return err
+}
+
+func (n *DiscordNotifier) SendChannelMessage(ctx context.Context, channelId uint64, e *embed.Embed) error {
+	_, err := rest.CreateMessage(ctx, n.token, n.rateLimiter, channelId, rest.CreateMessageData{
+		Embeds: []*embed.Embed{e},
+	})
+	return err
+}
@@ -0,0 +1,167 @@
+package embeds
+
+import "github.com/TicketsBot/subscriptions-app/internal/config"
+
+const (
+	Lookup                 = "lookup"
+	NotFound               = "not_found"
+	Event                  = "event"
+	MySubscription         = "my_subscription"
+	MySubscriptionNotFound = "my_subscription_not_found"
+)
+
+const (
+	red   = "#eb4034"
+	blue  = "#4287f5"
+	green = "#3ba55c"
+)
+
+// entitlementValue describes .Entitlement, e.g. "Premium, 3 server(s), in grace period until <date>"
+const entitlementValue = `{{ with .Entitlement }}{{ $.T (print "entitlement.type." .Type) }}, {{ $.T "entitlement.max_guilds" .MaxGuilds }}` +
+	`{{ if .LegacyPricing }}, {{ $.T "entitlement.legacy_pricing" }}{{ end }}` +
+	`{{ if eq .Source "grant" }}, {{ with .ExpiresAt }}{{ $.T "entitlement.granted_until" (date .) }}{{ else }}{{ $.T "entitlement.granted" }}{{ end }}{{ end }}` +
+	`{{ with .GraceUntil }}, {{ $.T "entitlement.grace_until" (date .) }}{{ end }}{{ end }}`
+
+// subscriptionsValue lists .Subscriptions, one per line, e.g. "Discord: Active (Premium)"
+const subscriptionsValue = `{{ range .Subscriptions }}{{ $.T (print "provider." .Provider) }}: ` +
+	`{{ with .Status }}{{ $.T (print "status." .) }}{{ else }}{{ $.T "status.none" }}{{ end }}` +
+	`{{ with .TierNames }} ({{ join . ", " }}){{ end }}
+{{ end }}`
+
+// subscriberUrl links to the subscriber's profile, where the provider has one
+const subscriberUrl = `{{ if eq .Patron.Provider "patreon" }}https://www.patreon.com/user?u={{ .Patron.Id }}{{ end }}`
+
+// mySubscriptionLapsed is true if the subscription is inactive, and they are not otherwise entitled to anything
+// except by a grace period
+const mySubscriptionLapsed = `and (ne .Patron.Status "active") (or (not .Entitlement) .Entitlement.GraceUntil)`
+
+const mySubscriptionDescription = `{{ if ` + mySubscriptionLapsed + ` }}{{ .T "mysubscription.inactive" }}{{ end }}` +
+	`{{ with .Entitlement }}{{ with .GraceUntil }} {{ $.T "mysubscription.grace" (date .) }}{{ end }}{{ end }}`
+
+// Defaults are used for any template not overridden in the config
+var Defaults = map[string]config.EmbedTemplate{
+	Lookup: {
+		Title: `{{ .T "lookup.found.title" }}`,
+		Url:   subscriberUrl,
+		Color: blue,
+		Fields: []config.EmbedFieldTemplate{
+			{
+				Name:   `{{ .T "lookup.field.status" }}`,
+				Value:  `{{ .Patron.PatronStatus }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.last_charge_status" }}`,
+				Value:  `{{ .Patron.LastChargeStatus }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.last_charge_date" }}`,
+				Value:  `{{ timestamp .Patron.LastChargeDate }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.join_date" }}`,
+				Value:  `{{ timestamp .Patron.StartedAt }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.active_tiers" }}`,
+				Value:  `{{ join .TierNames ", " }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.discord_account" }}`,
+				Value:  `{{ with .Patron.DiscordId }}<@{{ . }}> ({{ . }}){{ else }}{{ $.T "lookup.not_linked" }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.entitlement" }}`,
+				Value:  entitlementValue,
+				Inline: true,
+			},
+			{
+				Name:  `{{ .T "lookup.field.subscriptions" }}`,
+				Value: subscriptionsValue,
+			},
+		},
+	},
+	NotFound: {
+		Title:       `{{ .T "lookup.not_found.title" }}`,
+		Description: `{{ .T "lookup.not_found.description" .Query }}`,
+		Color:       red,
+	},
+	Event: {
+		Title: `{{ .T (print "event." .Event.Type ".title") }}`,
+		Color: `{{ if eq .Event.Type "` + EventPaymentRecovered + `" }}` + green + `{{ else }}` + red + `{{ end }}`,
+		Fields: []config.EmbedFieldTemplate{
+			{
+				Name:   `{{ .T "declines.staff.field.patron" }}`,
+				Value:  `{{ .Patron.Email }} ({{ with .Patron.PatronId }}{{ . }}{{ else }}{{ .Patron.Key }}{{ end }})`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "declines.staff.field.discord" }}`,
+				Value:  `{{ with .Patron.DiscordId }}<@{{ . }}>{{ else }}{{ $.T "declines.staff.not_linked" }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "declines.staff.field.tiers" }}`,
+				Value:  `{{ with .TierNames }}{{ join . ", " }}{{ else }}{{ $.T "declines.staff.no_tiers" }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "declines.staff.field.reason" }}`,
+				Value:  `{{ with .Event.Reason }}{{ $.T (print "declines.reason." .) }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "declines.staff.field.deadline" }}`,
+				Value:  `{{ date .Event.Deadline }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "declines.staff.field.reminder" }}`,
+				Value:  `{{ with .Event.Reminder }}{{ $.T (print "declines.staff.reminder." .) }}{{ end }}`,
+				Inline: true,
+			},
+		},
+	},
+	MySubscription: {
+		Title:       `{{ .T "mysubscription.found.title" }}`,
+		Description: mySubscriptionDescription,
+		Color:       `{{ if ` + mySubscriptionLapsed + ` }}` + red + `{{ else }}` + blue + `{{ end }}`,
+		Fields: []config.EmbedFieldTemplate{
+			{
+				Name:   `{{ .T "lookup.field.status" }}`,
+				Value:  `{{ with .Patron.Status }}{{ $.T (print "status." .) }}{{ else }}{{ $.T "status.none" }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.active_tiers" }}`,
+				Value:  `{{ with .TierNames }}{{ join . ", " }}{{ else }}{{ $.T "mysubscription.no_tiers" }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "mysubscription.field.next_charge_date" }}`,
+				Value:  `{{ if eq .Patron.Status "active" }}{{ date .Patron.NextChargeDate }}{{ end }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.last_charge_status" }}`,
+				Value:  `{{ .Patron.LastChargeStatus }}`,
+				Inline: true,
+			},
+			{
+				Name:   `{{ .T "lookup.field.entitlement" }}`,
+				Value:  entitlementValue,
+				Inline: true,
+			},
+		},
+	},
+	MySubscriptionNotFound: {
+		Title:       `{{ .T "mysubscription.not_found.title" }}`,
+		Description: `{{ .T "mysubscription.not_found.description" }}`,
+		Color:       red,
+	},
+}
@@ -0,0 +1,238 @@
+// Package embeds renders the embeds sent in responses from templates, which may be overridden in the config to
+// change the layout, colours and fields shown without modifying the code.
+package embeds
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data is the value passed to each template.
+type Data struct {
+	Patron    *providers.Subscriber // nil for the not found embed
+	Query     string                // The search term the user entered, if any
+	TierNames []string              // Names of the patron's tiers, as configured in the tiers map
+	Event     *EventData            // The event being reported, for event embeds
+
+	// The patron's effective entitlement, or nil if they are not entitled to anything
+	Entitlement *entitlements.Entitlement
+
+	// Other subscriptions linked to the patron's Discord account, e.g. with a different provider
+	Subscriptions []Subscription
+
+	localizer i18n.Localizer
+}
+
+// Values of EventData.Type
+const (
+	EventPaymentDeclined  = "payment_declined"
+	EventPaymentRecovered = "payment_recovered"
+	EventPaymentLapsed    = "payment_lapsed"
+)
+
+// EventData describes something that happened to a subscription, such as a payment being declined.
+type EventData struct {
+	Type     string
+	Reason   string    // Why a declined payment case was opened, e.g. charge_declined
+	Deadline time.Time // When the payment must be recovered by
+	Reminder string    // Whether the subscriber has been reminded: sent, not_sent or failed
+}
+
+type Subscription struct {
+	providers.Subscriber
+	TierNames []string
+}
+
+func NewData(localizer i18n.Localizer) Data {
+	return Data{
+		localizer: localizer,
+	}
+}
+
+// T translates a message from the catalogue into the locale the embed is being rendered for.
+func (d Data) T(key string, args ...any) string {
+	return d.localizer.T(key, args...)
+}
+
+type Renderer struct {
+	templates map[string]compiledTemplate
+}
+
+type compiledTemplate struct {
+	title       *template.Template
+	description *template.Template
+	url         *template.Template
+	color       *template.Template
+	footer      *template.Template
+	fields      []compiledField
+}
+
+type compiledField struct {
+	name   *template.Template
+	value  *template.Template
+	inline bool
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"timestamp": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+
+		return fmt.Sprintf("<t:%d>", t.Unix())
+	},
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+
+		return fmt.Sprintf("<t:%d:D>", t.Unix())
+	},
+}
+
+// NewRenderer compiles the default templates, replacing any that are overridden.
+func NewRenderer(overrides map[string]config.EmbedTemplate) (*Renderer, error) {
+	renderer := &Renderer{
+		templates: make(map[string]compiledTemplate),
+	}
+
+	for name, tmpl := range Defaults {
+		if override, ok := overrides[name]; ok {
+			tmpl = override
+		}
+
+		compiled, err := compile(name, tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		renderer.templates[name] = compiled
+	}
+
+	for name := range overrides {
+		if _, ok := Defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown embed template %s", name)
+		}
+	}
+
+	return renderer, nil
+}
+
+func compile(name string, tmpl config.EmbedTemplate) (compiledTemplate, error) {
+	var err error
+	parse := func(field, text string) *template.Template {
+		if err != nil || text == "" {
+			return nil
+		}
+
+		var parsed *template.Template
+		parsed, err = template.New(name + "." + field).Funcs(funcs).Parse(text)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse %s of embed template %s", field, name)
+		}
+
+		return parsed
+	}
+
+	compiled := compiledTemplate{
+		title:       parse("title", tmpl.Title),
+		description: parse("description", tmpl.Description),
+		url:         parse("url", tmpl.Url),
+		color:       parse("color", tmpl.Color),
+		footer:      parse("footer", tmpl.Footer),
+	}
+
+	for i, field := range tmpl.Fields {
+		compiled.fields = append(compiled.fields, compiledField{
+			name:   parse(fmt.Sprintf("fields[%d].name", i), field.Name),
+			value:  parse(fmt.Sprintf("fields[%d].value", i), field.Value),
+			inline: field.Inline,
+		})
+	}
+
+	return compiled, err
+}
+
+// Render executes the named template. Fields whose name or value render as blank are omitted, so templates can
+// hide fields conditionally.
+func (r *Renderer) Render(name string, data Data) (*embed.Embed, error) {
+	tmpl, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown embed template %s", name)
+	}
+
+	var err error
+	execute := func(t *template.Template) string {
+		if err != nil || t == nil {
+			return ""
+		}
+
+		var b strings.Builder
+		if err = t.Execute(&b, data); err != nil {
+			err = errors.Wrapf(err, "failed to render %s", t.Name())
+		}
+
+		return strings.TrimSpace(b.String())
+	}
+
+	e := &embed.Embed{
+		Title:       execute(tmpl.title),
+		Description: execute(tmpl.description),
+		Url:         execute(tmpl.url),
+	}
+
+	if color := execute(tmpl.color); color != "" {
+		parsed, parseErr := parseColor(color)
+		if parseErr != nil {
+			return nil, errors.Wrapf(parseErr, "invalid color in embed template %s", name)
+		}
+
+		e.Color = parsed
+	}
+
+	if footer := execute(tmpl.footer); footer != "" {
+		e.Footer = &embed.EmbedFooter{
+			Text: footer,
+		}
+	}
+
+	for _, field := range tmpl.fields {
+		fieldName, value := execute(field.name), execute(field.value)
+		if fieldName == "" || value == "" {
+			continue
+		}
+
+		e.Fields = append(e.Fields, &embed.EmbedField{
+			Name:   fieldName,
+			Value:  value,
+			Inline: field.inline,
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// parseColor accepts either a hex colour such as #eb4034, or a decimal integer
+func parseColor(color string) (int, error) {
+	if strings.HasPrefix(color, "#") {
+		parsed, err := strconv.ParseInt(color[1:], 16, 32)
+		return int(parsed), err
+	}
+
+	parsed, err := strconv.ParseInt(color, 10, 32)
+	return int(parsed), err
+}
@@ -0,0 +1,75 @@
+package embeds
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"testing"
+)
+
+func TestRenderOverride(t *testing.T) {
+	catalogue, err := i18n.Load()
+	if err != nil {
+		t.Fatalf("failed to load catalogue: %v", err)
+	}
+
+	renderer, err := NewRenderer(map[string]config.EmbedTemplate{
+		Lookup: {
+			Title: `{{ .T "lookup.found.title" }}: {{ .Patron.Id }}`,
+			Color: `{{ if eq .Patron.PatronStatus "active_patron" }}#00ff00{{ else }}#ff0000{{ end }}`,
+			Fields: []config.EmbedFieldTemplate{
+				{Name: "Email", Value: `{{ .Patron.Email }}`},
+				{Name: "Discord", Value: `{{ with .Patron.DiscordId }}{{ . }}{{ end }}`}, // Omitted when blank
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create renderer: %v", err)
+	}
+
+	data := NewData(catalogue.Localizer(i18n.FallbackLocale))
+	data.Patron = &providers.Subscriber{
+		Provider: "patreon",
+		Id:       "42",
+		Email:    "patron@example.com",
+		Status:   providers.StatusActive,
+	}
+
+	e, err := renderer.Render(Lookup, data)
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	if e.Title != "Account Found: 42" {
+		t.Errorf("unexpected title %q", e.Title)
+	}
+
+	if e.Color != 0x00ff00 {
+		t.Errorf("unexpected color %x", e.Color)
+	}
+
+	if len(e.Fields) != 1 || e.Fields[0].Value != "patron@example.com" {
+		t.Errorf("unexpected fields %+v", e.Fields)
+	}
+
+	// Templates that are not overridden keep their defaults
+	data.Query = "missing@example.com"
+	e, err = renderer.Render(NotFound, data)
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	if e.Description != "No subscription with email `missing@example.com` found" {
+		t.Errorf("unexpected description %q", e.Description)
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	if _, err := NewRenderer(map[string]config.EmbedTemplate{"unknown": {}}); err == nil {
+		t.Errorf("expected unknown template name to be rejected")
+	}
+
+	if _, err := NewRenderer(map[string]config.EmbedTemplate{Lookup: {Title: "{{ .Unclosed"}}); err == nil {
+		t.Errorf("expected invalid template to be rejected")
+	}
+}
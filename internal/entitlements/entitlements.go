@@ -0,0 +1,115 @@
+// Package entitlements resolves what a subscriber is entitled to from the tiers they are subscribed to.
+package entitlements
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"time"
+)
+
+// Values of Entitlement.Type
+const (
+	TypePremium    = "premium"
+	TypeWhitelabel = "whitelabel"
+)
+
+// Entitlement.Source is the name of the provider the entitlement comes from, or SourceGrant
+const SourceGrant = "grant"
+
+type Entitlement struct {
+	Type          string     `json:"type"`
+	MaxGuilds     int        `json:"max_guilds"`
+	Priority      int        `json:"priority"`
+	LegacyPricing bool       `json:"legacy_pricing"`
+	Source        string     `json:"source"`
+	TierId        uint64     `json:"tier_id,omitempty"`     // The tier the entitlement comes from, if from a provider
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`  // When a granted entitlement expires, if ever
+	GraceUntil    *time.Time `json:"grace_until,omitempty"` // Set if the entitlement is only kept by a grace period
+}
+
+type Resolver struct {
+	byTier map[uint64]config.TierEntitlement
+}
+
+// NewResolver validates the configured tier entitlements.
+func NewResolver(tiers []config.TierEntitlement) (*Resolver, error) {
+	byTier := make(map[uint64]config.TierEntitlement, len(tiers))
+	for _, tier := range tiers {
+		if tier.Type != TypePremium && tier.Type != TypeWhitelabel {
+			return nil, fmt.Errorf("tier %d has unknown entitlement type %q", tier.TierId, tier.Type)
+		}
+
+		if tier.MaxGuilds < 0 {
+			return nil, fmt.Errorf("tier %d has negative max guilds", tier.TierId)
+		}
+
+		if _, ok := byTier[tier.TierId]; ok {
+			return nil, fmt.Errorf("tier %d has more than one entitlement", tier.TierId)
+		}
+
+		byTier[tier.TierId] = tier
+	}
+
+	return &Resolver{
+		byTier: byTier,
+	}, nil
+}
+
+// Resolve returns the highest priority entitlement from the subscriber's tiers, as ordered by Better.
+func (r *Resolver) Resolve(subscriber providers.Subscriber) (Entitlement, bool) {
+	var best *Entitlement
+	for _, tierId := range subscriber.Tiers {
+		tier, ok := r.byTier[tierId]
+		if !ok {
+			continue
+		}
+
+		entitlement := Entitlement{
+			Type:          tier.Type,
+			MaxGuilds:     tier.MaxGuilds,
+			Priority:      tier.Priority,
+			LegacyPricing: tier.LegacyPricing,
+			Source:        subscriber.Provider,
+			TierId:        tier.TierId,
+		}
+
+		if best == nil || Better(entitlement, *best) {
+			best = &entitlement
+		}
+	}
+
+	if best == nil {
+		return Entitlement{}, false
+	}
+
+	return *best, true
+}
+
+// Priority returns the highest priority configured for the entitlement type, so that entitlements from other sources
+// can be ranked alongside tiers of the same type. It is 0 if no tier grants the type.
+func (r *Resolver) Priority(entitlementType string) int {
+	priority := 0
+	for _, tier := range r.byTier {
+		if tier.Type == entitlementType && tier.Priority > priority {
+			priority = tier.Priority
+		}
+	}
+
+	return priority
+}
+
+// Better reports whether a should be preferred over b: the higher priority wins, with ties broken by the number of
+// guilds, and then by tier ID so that the result is stable. Entitlements not from a tier have a tier ID of 0, and so
+// win ties.
+func Better(a, b Entitlement) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	if a.MaxGuilds != b.MaxGuilds {
+		return a.MaxGuilds > b.MaxGuilds
+	}
+
+	return a.TierId < b.TierId
+}
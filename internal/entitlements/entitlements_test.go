@@ -0,0 +1,80 @@
+package entitlements
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := NewResolver([]config.TierEntitlement{
+		{TierId: 1, Type: TypePremium, MaxGuilds: 1, Priority: 1},
+		{TierId: 2, Type: TypePremium, MaxGuilds: 5, Priority: 2, LegacyPricing: true},
+		{TierId: 3, Type: TypeWhitelabel, MaxGuilds: 5, Priority: 3},
+		{TierId: 4, Type: TypePremium, MaxGuilds: 10, Priority: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		tiers      []uint64
+		wantTierId uint64
+		wantOk     bool
+	}{
+		{name: "no tiers"},
+		{name: "unknown tier", tiers: []uint64{100}},
+		{name: "single tier", tiers: []uint64{1}, wantTierId: 1, wantOk: true},
+		{name: "highest priority", tiers: []uint64{1, 3, 2}, wantTierId: 3, wantOk: true},
+		{name: "tie broken by guilds", tiers: []uint64{2, 4}, wantTierId: 4, wantOk: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			entitlement, ok := resolver.Resolve(providers.Subscriber{Provider: "patreon", Tiers: tc.tiers})
+			if ok != tc.wantOk || entitlement.TierId != tc.wantTierId {
+				t.Errorf("expected tier %d (%v), got %+v (%v)", tc.wantTierId, tc.wantOk, entitlement, ok)
+			}
+
+			if ok && entitlement.Source != "patreon" {
+				t.Errorf("expected source %q, got %q", "patreon", entitlement.Source)
+			}
+		})
+	}
+}
+
+func TestPriority(t *testing.T) {
+	resolver, err := NewResolver([]config.TierEntitlement{
+		{TierId: 1, Type: TypePremium, MaxGuilds: 1, Priority: 1},
+		{TierId: 2, Type: TypePremium, MaxGuilds: 5, Priority: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resolver.Priority(TypePremium); got != 2 {
+		t.Errorf("expected premium priority 2, got %d", got)
+	}
+
+	if got := resolver.Priority(TypeWhitelabel); got != 0 {
+		t.Errorf("expected whitelabel priority 0, got %d", got)
+	}
+
+	// An entitlement from outside a tier wins ties against tiers
+	granted := Entitlement{Type: TypePremium, MaxGuilds: 5, Priority: 2, Source: SourceGrant}
+	tier := Entitlement{Type: TypePremium, MaxGuilds: 5, Priority: 2, Source: "patreon", TierId: 2}
+	if !Better(granted, tier) || Better(tier, granted) {
+		t.Error("expected the granted entitlement to win the tie")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := NewResolver([]config.TierEntitlement{{TierId: 1, Type: "gold"}}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+
+	if _, err := NewResolver([]config.TierEntitlement{{TierId: 1, Type: TypePremium}, {TierId: 1, Type: TypePremium}}); err == nil {
+		t.Error("expected error for duplicate tier")
+	}
+}
@@ -0,0 +1,176 @@
+// Package grace lets subscribers keep their previous entitlement for a while after their subscription lapses, by
+// remembering the tiers each subscriber was entitled to while their subscription was active.
+package grace
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"sync"
+	"time"
+)
+
+const documentName = "grace"
+
+type Policy struct {
+	DeclinedDays  int // Grace period after a subscriber's payment is declined
+	CancelledDays int // Grace period after a subscriber cancels
+}
+
+// Enabled reports whether the policy has a grace period for either kind of lapse.
+func (p Policy) Enabled() bool {
+	return p.DeclinedDays > 0 || p.CancelledDays > 0
+}
+
+func (p Policy) days(status string) int {
+	if status == providers.StatusDeclined {
+		return p.DeclinedDays
+	}
+
+	return p.CancelledDays
+}
+
+func (p Policy) maxDays() int {
+	if p.DeclinedDays > p.CancelledDays {
+		return p.DeclinedDays
+	}
+
+	return p.CancelledDays
+}
+
+// Record is what is remembered about a subscriber
+type Record struct {
+	Tiers        []uint64   `json:"tiers"`          // The tiers the subscriber was last entitled to while active
+	LastActiveAt time.Time  `json:"last_active_at"` // Zero if the subscriber had already lapsed when first seen
+	LapsedAt     *time.Time `json:"lapsed_at,omitempty"`
+}
+
+// Window is a grace period that a subscriber is currently in
+type Window struct {
+	Tiers []uint64
+	Until time.Time
+}
+
+type document struct {
+	Records map[string]Record `json:"records"` // Keyed by Subscriber.Key
+}
+
+type Tracker struct {
+	store  *store.Store
+	policy Policy
+	mu     sync.RWMutex
+	doc    document
+}
+
+// NewTracker loads the existing records from the store.
+func NewTracker(store *store.Store, policy Policy) (*Tracker, error) {
+	t := &Tracker{
+		store:  store,
+		policy: policy,
+	}
+
+	if err := store.Load(documentName, &t.doc); err != nil {
+		return nil, err
+	}
+
+	// Records from before there was more than one provider are keyed by Patreon ID
+	records := make(map[string]Record, len(t.doc.Records))
+	for key, record := range t.doc.Records {
+		records[providers.MigrateKey(key)] = record
+	}
+
+	t.doc.Records = records
+
+	return t, nil
+}
+
+// Update records the tiers of active subscribers, and when subscribers were first seen to have lapsed. Records of
+// subscribers whose grace period can no longer apply are removed.
+func (t *Tracker) Update(now time.Time, subscribers []providers.Subscriber) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := false
+	for _, subscriber := range subscribers {
+		key := subscriber.Key()
+		record, exists := t.doc.Records[key]
+
+		if subscriber.Active() {
+			if len(subscriber.Tiers) > 0 {
+				t.doc.Records[key] = Record{
+					Tiers:        subscriber.Tiers,
+					LastActiveAt: now,
+				}
+				changed = true
+			}
+
+			continue
+		}
+
+		if !exists {
+			// Providers may still list the tiers of a subscriber who has just lapsed, which is the best we can do for
+			// subscribers who had already lapsed before they were first seen
+			if len(subscriber.Tiers) == 0 {
+				continue
+			}
+
+			record.Tiers = subscriber.Tiers
+		} else if record.LapsedAt != nil {
+			continue
+		}
+
+		record.LapsedAt = &now
+		t.doc.Records[key] = record
+		changed = true
+	}
+
+	expiry := now.AddDate(0, 0, -t.policy.maxDays())
+	for id, record := range t.doc.Records {
+		if record.LapsedAt != nil && record.LapsedAt.Before(expiry) {
+			delete(t.doc.Records, id)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return t.store.Save(documentName, t.doc)
+}
+
+// Window returns the grace period the subscriber is in, if any. Subscribers who are not active are in a grace period
+// for the configured number of days after their last charge date, or after they were first seen to have lapsed if
+// there was no charge, and keep the tiers they had while active.
+func (t *Tracker) Window(now time.Time, subscriber providers.Subscriber) (Window, bool) {
+	if subscriber.Active() {
+		return Window{}, false
+	}
+
+	days := t.policy.days(subscriber.Status)
+	if days <= 0 {
+		return Window{}, false
+	}
+
+	t.mu.RLock()
+	record, ok := t.doc.Records[subscriber.Key()]
+	t.mu.RUnlock()
+
+	if !ok || record.LapsedAt == nil || len(record.Tiers) == 0 {
+		return Window{}, false
+	}
+
+	anchor := subscriber.LastChargeDate
+	if anchor.IsZero() {
+		anchor = *record.LapsedAt
+	}
+
+	until := anchor.AddDate(0, 0, days)
+	if !now.Before(until) {
+		return Window{}, false
+	}
+
+	return Window{
+		Tiers: record.Tiers,
+		Until: until,
+	}, true
+}
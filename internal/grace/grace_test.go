@@ -0,0 +1,123 @@
+package grace
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"testing"
+	"time"
+)
+
+func newTestTracker(t *testing.T, policy Policy) (*Tracker, *store.Store) {
+	t.Helper()
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := NewTracker(s, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tracker, s
+}
+
+func subscriber(status string, lastCharge time.Time, tiers ...uint64) providers.Subscriber {
+	return providers.Subscriber{
+		Provider:       "patreon",
+		Id:             "1",
+		Status:         status,
+		LastChargeDate: lastCharge,
+		Tiers:          tiers,
+	}
+}
+
+func snapshot(s providers.Subscriber) []providers.Subscriber {
+	return []providers.Subscriber{s}
+}
+
+func TestDeclinedGracePeriod(t *testing.T) {
+	tracker, s := newTestTracker(t, Policy{DeclinedDays: 7})
+
+	charge := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := tracker.Update(charge.AddDate(0, 0, -10), snapshot(subscriber(providers.StatusActive, charge.AddDate(0, -1, 0), 1, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The charge fails and Patreon drops the tiers
+	declined := subscriber(providers.StatusDeclined, charge)
+	if err := tracker.Update(charge.Add(time.Hour), snapshot(declined)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from disk
+	tracker, err := NewTracker(s, Policy{DeclinedDays: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	window, ok := tracker.Window(charge.AddDate(0, 0, 3), declined)
+	if !ok || len(window.Tiers) != 2 || !window.Until.Equal(charge.AddDate(0, 0, 7)) {
+		t.Fatalf("expected a grace period until 7 days after the charge, got %+v (%v)", window, ok)
+	}
+
+	if _, ok := tracker.Window(charge.AddDate(0, 0, 7), declined); ok {
+		t.Error("expected the grace period to have ended")
+	}
+
+	// Cancellations have no grace period under this policy
+	if _, ok := tracker.Window(charge.AddDate(0, 0, 3), subscriber(providers.StatusFormer, charge)); ok {
+		t.Error("expected no grace period for a cancellation")
+	}
+
+	// The payment is recovered, so a later decline starts a new grace period
+	if err := tracker.Update(charge.AddDate(0, 0, 4), snapshot(subscriber(providers.StatusActive, charge.AddDate(0, 0, 4), 3))); err != nil {
+		t.Fatal(err)
+	}
+
+	next := charge.AddDate(0, 1, 0)
+	declined = subscriber(providers.StatusDeclined, next)
+	if err := tracker.Update(next, snapshot(declined)); err != nil {
+		t.Fatal(err)
+	}
+
+	if window, ok := tracker.Window(next.Add(time.Hour), declined); !ok || len(window.Tiers) != 1 || window.Tiers[0] != 3 {
+		t.Errorf("expected a new grace period with the recovered tiers, got %+v (%v)", window, ok)
+	}
+
+	// Records are dropped once the grace period can no longer apply
+	if err := tracker.Update(next.AddDate(0, 0, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracker.doc.Records) != 0 {
+		t.Errorf("expected the record to be removed, got %+v", tracker.doc.Records)
+	}
+}
+
+func TestAlreadyLapsed(t *testing.T) {
+	tracker, _ := newTestTracker(t, Policy{CancelledDays: 30})
+
+	// First seen after cancelling, with Patreon still listing the tier, and without ever being charged
+	now := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	former := subscriber(providers.StatusFormer, time.Time{}, 1)
+	if err := tracker.Update(now, snapshot(former)); err != nil {
+		t.Fatal(err)
+	}
+
+	window, ok := tracker.Window(now.AddDate(0, 0, 1), former)
+	if !ok || !window.Until.Equal(now.AddDate(0, 0, 30)) {
+		t.Errorf("expected a grace period from when the lapse was seen, got %+v (%v)", window, ok)
+	}
+
+	// A subscriber seen lapsed without any tiers has nothing to keep
+	tracker, _ = newTestTracker(t, Policy{CancelledDays: 30})
+	if err := tracker.Update(now, snapshot(subscriber(providers.StatusFormer, now))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := tracker.Window(now, subscriber(providers.StatusFormer, now)); ok {
+		t.Error("expected no grace period without any known tiers")
+	}
+}
@@ -0,0 +1,162 @@
+// Package grants stores entitlements granted to users by staff, independently of any subscription.
+package grants
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+const documentName = "grants"
+
+type Grant struct {
+	UserId    uint64     `json:"user_id,string"` // The Discord user the grant is for
+	Type      string     `json:"type"`
+	MaxGuilds int        `json:"max_guilds"`
+	Reason    string     `json:"reason"`
+	GrantedBy uint64     `json:"granted_by,string"`
+	GrantedAt time.Time  `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil if the grant does not expire
+	RevokedBy uint64     `json:"revoked_by,string,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the grant has neither expired nor been revoked at the given time.
+func (g Grant) Active(now time.Time) bool {
+	if g.RevokedAt != nil {
+		return false
+	}
+
+	return g.ExpiresAt == nil || now.Before(*g.ExpiresAt)
+}
+
+type document struct {
+	Grants []Grant `json:"grants"`
+}
+
+// Manager keeps at most one active grant per user. Grants that have been replaced, revoked or have expired are kept,
+// so that there is a record of who was given what.
+type Manager struct {
+	store *store.Store
+	mu    sync.RWMutex
+	doc   document
+}
+
+// NewManager loads the existing grants from the store.
+func NewManager(store *store.Store) (*Manager, error) {
+	m := &Manager{
+		store: store,
+	}
+
+	if err := store.Load(documentName, &m.doc); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Grant stores a new grant, revoking the user's existing active grant if they have one. The replaced grant is
+// returned, if there was one.
+func (m *Manager) Grant(grant Grant) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grants := m.copyGrants()
+	replaced := revoke(grants, grant.GrantedAt, grant.UserId, grant.GrantedBy)
+	grants = append(grants, grant)
+
+	if err := m.save(grants); err != nil {
+		return nil, err
+	}
+
+	return replaced, nil
+}
+
+// Revoke revokes the user's active grant, returning false if they did not have one.
+func (m *Manager) Revoke(now time.Time, userId, revokedBy uint64) (Grant, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grants := m.copyGrants()
+	revoked := revoke(grants, now, userId, revokedBy)
+	if revoked == nil {
+		return Grant{}, false, nil
+	}
+
+	if err := m.save(grants); err != nil {
+		return Grant{}, false, err
+	}
+
+	return *revoked, true, nil
+}
+
+// Active returns the user's active grant, if they have one.
+func (m *Manager) Active(now time.Time, userId uint64) (Grant, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, grant := range m.doc.Grants {
+		if grant.UserId == userId && grant.Active(now) {
+			return grant, true
+		}
+	}
+
+	return Grant{}, false
+}
+
+// List returns the active grants, or all grants if includeInactive is set, most recent first.
+func (m *Manager) List(now time.Time, includeInactive bool) []Grant {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var grants []Grant
+	for _, grant := range m.doc.Grants {
+		if includeInactive || grant.Active(now) {
+			grants = append(grants, grant)
+		}
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].GrantedAt.After(grants[j].GrantedAt)
+	})
+
+	return grants
+}
+
+// revoke marks the user's active grant in grants as revoked, and returns a copy of it.
+func revoke(grants []Grant, now time.Time, userId, revokedBy uint64) *Grant {
+	for i, grant := range grants {
+		if grant.UserId != userId || !grant.Active(now) {
+			continue
+		}
+
+		grants[i].RevokedAt = &now
+		grants[i].RevokedBy = revokedBy
+
+		revoked := grants[i]
+		return &revoked
+	}
+
+	return nil
+}
+
+// copyGrants returns a copy of the grants to modify, so that a failed save leaves the grants as they were. The caller
+// must hold the write lock.
+func (m *Manager) copyGrants() []Grant {
+	grants := make([]Grant, len(m.doc.Grants), len(m.doc.Grants)+1)
+	copy(grants, m.doc.Grants)
+	return grants
+}
+
+// save persists the grants, and replaces the current grants with them if successful. The caller must hold the write
+// lock.
+func (m *Manager) save(grants []Grant) error {
+	doc := document{Grants: grants}
+	if err := m.store.Save(documentName, doc); err != nil {
+		return err
+	}
+
+	m.doc = doc
+	return nil
+}
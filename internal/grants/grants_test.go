@@ -0,0 +1,115 @@
+package grants
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGrants(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiry := now.Add(24 * time.Hour)
+
+	replaced, err := m.Grant(Grant{UserId: 1, Type: "premium", MaxGuilds: 1, Reason: "contest", GrantedBy: 9, GrantedAt: now, ExpiresAt: &expiry})
+	if err != nil || replaced != nil {
+		t.Fatalf("expected a new grant, got %+v, %v", replaced, err)
+	}
+
+	if _, ok := m.Active(now.Add(time.Hour), 1); !ok {
+		t.Error("expected grant to be active before it expires")
+	}
+
+	if _, ok := m.Active(expiry, 1); ok {
+		t.Error("expected grant to have expired")
+	}
+
+	// Replacing the grant revokes the old one
+	later := now.Add(time.Hour)
+	replaced, err = m.Grant(Grant{UserId: 1, Type: "whitelabel", MaxGuilds: 2, Reason: "partner", GrantedBy: 9, GrantedAt: later})
+	if err != nil || replaced == nil || replaced.Reason != "contest" || replaced.RevokedAt == nil {
+		t.Fatalf("expected the contest grant to be replaced, got %+v, %v", replaced, err)
+	}
+
+	if grant, ok := m.Active(later, 1); !ok || grant.Type != "whitelabel" {
+		t.Errorf("expected the partner grant to be active, got %+v", grant)
+	}
+
+	if got := m.List(later, false); len(got) != 1 {
+		t.Errorf("expected 1 active grant, got %+v", got)
+	}
+
+	// Reload from disk
+	m, err = NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.List(later, true); len(got) != 2 || got[0].Reason != "partner" {
+		t.Errorf("expected both grants, most recent first, got %+v", got)
+	}
+
+	revoked, ok, err := m.Revoke(later, 1, 8)
+	if err != nil || !ok || revoked.RevokedBy != 8 {
+		t.Fatalf("expected the grant to be revoked, got %+v, %v, %v", revoked, ok, err)
+	}
+
+	if _, ok, _ := m.Revoke(later, 1, 8); ok {
+		t.Error("expected nothing to revoke")
+	}
+
+	if _, ok := m.Active(later, 1); ok {
+		t.Error("expected no active grant after revoking")
+	}
+}
+
+func TestFailedSave(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := m.Grant(Grant{UserId: 1, Type: "premium", MaxGuilds: 1, GrantedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The document cannot be replaced by a directory that isn't empty
+	if err := os.RemoveAll(filepath.Join(dir, documentName+".json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, documentName+".json", "blocked"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the replaced grant nor the new one is changed in memory if the save fails
+	if _, err := m.Grant(Grant{UserId: 1, Type: "whitelabel", MaxGuilds: 1, GrantedAt: now.Add(time.Hour)}); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if _, _, err := m.Revoke(now.Add(time.Hour), 1, 9); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if grant, ok := m.Active(now.Add(time.Hour), 1); !ok || grant.Type != "premium" || len(m.List(now, true)) != 1 {
+		t.Errorf("expected the grants to be unchanged, got %+v", m.List(now, true))
+	}
+}
@@ -0,0 +1,154 @@
+package history
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+)
+
+var (
+	chartBackground = color.RGBA{R: 0x2b, G: 0x2d, B: 0x31, A: 0xff}
+	chartGrid       = color.RGBA{R: 0x4e, G: 0x50, B: 0x58, A: 0xff}
+	chartLine       = color.RGBA{R: 0x42, G: 0x87, B: 0xf5, A: 0xff}
+)
+
+const chartPadding = 16
+
+// RenderChart draws the values as a line chart, scaled to fill the image, and encodes it as a PNG. The chart has no
+// text, so axis labels should be given alongside it.
+func RenderChart(values []float64, width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: chartBackground}, image.Point{}, draw.Src)
+
+	plotWidth, plotHeight := width-chartPadding*2, height-chartPadding*2
+
+	// Horizontal grid lines at each quarter
+	for i := 0; i <= 4; i++ {
+		y := chartPadding + plotHeight*i/4
+		drawLine(img, chartPadding, y, chartPadding+plotWidth, y, chartGrid, 1)
+	}
+
+	if len(values) > 0 {
+		min, max := bounds(values)
+		if min == max {
+			// Centre a flat line
+			min, max = min-1, max+1
+		}
+
+		point := func(i int) (int, int) {
+			x := chartPadding
+			if len(values) > 1 {
+				x += plotWidth * i / (len(values) - 1)
+			}
+
+			y := chartPadding + int(math.Round(float64(plotHeight)*(max-values[i])/(max-min)))
+			return x, y
+		}
+
+		x0, y0 := point(0)
+		fillSquare(img, x0, y0, 3, chartLine)
+		for i := 1; i < len(values); i++ {
+			x1, y1 := point(i)
+			drawLine(img, x0, y0, x1, y1, chartLine, 2)
+			fillSquare(img, x1, y1, 3, chartLine)
+			x0, y0 = x1, y1
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Sparkline renders the values as a line of block characters, e.g. ▁▂▄█.
+func Sparkline(values []float64) string {
+	const blocks = "▁▂▃▄▅▆▇█"
+	runes := []rune(blocks)
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	min, max := bounds(values)
+
+	var b strings.Builder
+	for _, value := range values {
+		i := 0
+		if max > min {
+			i = int(math.Round((value - min) / (max - min) * float64(len(runes)-1)))
+		}
+
+		b.WriteRune(runes[i])
+	}
+
+	return b.String()
+}
+
+func bounds(values []float64) (min, max float64) {
+	min, max = values[0], values[0]
+	for _, value := range values[1:] {
+		min = math.Min(min, value)
+		max = math.Max(max, value)
+	}
+
+	return min, max
+}
+
+// drawLine draws a line using Bresenham's algorithm, with each point drawn as a square of the given thickness
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color, thickness int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	err := dx + dy
+
+	for {
+		fillSquare(img, x0, y0, thickness, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func fillSquare(img *image.RGBA, x, y, size int, c color.Color) {
+	offset := size / 2
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			img.Set(x-offset+i, y-offset+j, c)
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,194 @@
+// Package history records daily rollups of campaign metrics, so that trends can be seen after the snapshots they
+// were computed from have been replaced.
+package history
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	documentName = "history"
+	dateFormat   = "2006-01-02"
+	// Roughly 3 years of daily rollups
+	maxRollups = 366 * 3
+)
+
+// Rollup holds the metrics for a single day. Gauges (the counts of subscribers by status and tier, and revenue) reflect
+// the last snapshot recorded on that day, while the remaining counters accumulate over the day.
+type Rollup struct {
+	Date         string         `json:"date"` // YYYY-MM-DD, in UTC
+	Total        int            `json:"total"`
+	Active       int            `json:"active"`
+	Declined     int            `json:"declined"`
+	Former       int            `json:"former"`
+	ActiveByTier map[uint64]int `json:"active_by_tier"`
+	RevenueCents int            `json:"revenue_cents"` // The sum of active subscribers' monthly amounts, in the configured currency
+	NewPledges   int            `json:"new_pledges"`   // Subscribers whose subscription started on this day
+	Churned      int            `json:"churned"`       // Active subscribers that stopped being active, or were removed
+	Declines     int            `json:"declines"`      // Charges that were declined
+}
+
+// Time returns the start of the rollup's day.
+func (r Rollup) Time() time.Time {
+	t, _ := time.Parse(dateFormat, r.Date)
+	return t
+}
+
+type subscriberState struct {
+	Status           string    `json:"status"`
+	LastChargeStatus string    `json:"last_charge_status"`
+	LastChargeDate   time.Time `json:"last_charge_date"`
+}
+
+type document struct {
+	Rollups []Rollup `json:"rollups"`
+	// The state of each subscriber as of the last snapshot, keyed by Subscriber.Key, used to detect changes between
+	// snapshots
+	Previous map[string]subscriberState `json:"previous"`
+}
+
+type Recorder struct {
+	store    *store.Store
+	currency string // Revenue only includes amounts in this currency
+	mu       sync.RWMutex
+	doc      document
+}
+
+// NewRecorder loads any previously recorded history from the store. Revenue is recorded in the given currency.
+func NewRecorder(store *store.Store, currency string) (*Recorder, error) {
+	r := &Recorder{
+		store:    store,
+		currency: currency,
+	}
+
+	if err := store.Load(documentName, &r.doc); err != nil {
+		return nil, err
+	}
+
+	r.migrate()
+
+	return r, nil
+}
+
+// migrate converts state recorded before there was more than one provider, which used Patreon's IDs and statuses.
+func (r *Recorder) migrate() {
+	if r.doc.Previous == nil {
+		return
+	}
+
+	previous := make(map[string]subscriberState, len(r.doc.Previous))
+	for key, state := range r.doc.Previous {
+		state.Status = strings.TrimSuffix(state.Status, "_patron")
+		previous[providers.MigrateKey(key)] = state
+	}
+
+	r.doc.Previous = previous
+}
+
+// Record folds a snapshot into the rollup for the current day, and persists the history.
+func (r *Recorder) Record(now time.Time, subscribers []providers.Subscriber) error {
+	date := now.UTC().Format(dateFormat)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rollup *Rollup
+	if n := len(r.doc.Rollups); n > 0 && r.doc.Rollups[n-1].Date == date {
+		rollup = &r.doc.Rollups[n-1]
+	} else {
+		r.doc.Rollups = append(r.doc.Rollups, Rollup{Date: date})
+		if len(r.doc.Rollups) > maxRollups {
+			r.doc.Rollups = r.doc.Rollups[len(r.doc.Rollups)-maxRollups:]
+		}
+
+		rollup = &r.doc.Rollups[len(r.doc.Rollups)-1]
+	}
+
+	rollup.Total = len(subscribers)
+	rollup.Active, rollup.Declined, rollup.Former = 0, 0, 0
+	rollup.ActiveByTier = make(map[uint64]int)
+	rollup.RevenueCents = 0
+	rollup.NewPledges = 0
+
+	current := make(map[string]subscriberState, len(subscribers))
+	for _, subscriber := range subscribers {
+		state := subscriberState{
+			Status:           subscriber.Status,
+			LastChargeStatus: subscriber.LastChargeStatus,
+			LastChargeDate:   subscriber.LastChargeDate,
+		}
+		current[subscriber.Key()] = state
+
+		switch subscriber.Status {
+		case providers.StatusActive:
+			rollup.Active++
+			rollup.RevenueCents += subscriber.MonthlyAmountCentsIn(r.currency)
+			for _, tier := range subscriber.Tiers {
+				rollup.ActiveByTier[tier]++
+			}
+		case providers.StatusDeclined:
+			rollup.Declined++
+		case providers.StatusFormer:
+			rollup.Former++
+		}
+
+		if !subscriber.StartedAt.IsZero() && subscriber.StartedAt.UTC().Format(dateFormat) == date {
+			rollup.NewPledges++
+		}
+
+		// Nothing can be compared on the very first snapshot
+		if r.doc.Previous == nil {
+			continue
+		}
+
+		previous, existed := r.doc.Previous[subscriber.Key()]
+		if existed && previous.Status == providers.StatusActive && state.Status != providers.StatusActive {
+			rollup.Churned++
+		}
+
+		if state.LastChargeStatus == providers.ChargeStatusDeclined &&
+			(!existed || previous.LastChargeStatus != state.LastChargeStatus || !previous.LastChargeDate.Equal(state.LastChargeDate)) {
+			rollup.Declines++
+		}
+	}
+
+	for key, previous := range r.doc.Previous {
+		if _, ok := current[key]; !ok && previous.Status == providers.StatusActive {
+			rollup.Churned++
+		}
+	}
+
+	r.doc.Previous = current
+
+	return r.store.Save(documentName, r.doc)
+}
+
+// Rollups returns the rollups for the days between from and to inclusive, in date order. Days on which nothing was
+// recorded are omitted.
+func (r *Recorder) Rollups(from, to time.Time) []Rollup {
+	fromDate, toDate := from.UTC().Format(dateFormat), to.UTC().Format(dateFormat)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Rollups are always appended in date order, and dates sort lexicographically
+	start := sort.Search(len(r.doc.Rollups), func(i int) bool {
+		return r.doc.Rollups[i].Date >= fromDate
+	})
+
+	var rollups []Rollup
+	for _, rollup := range r.doc.Rollups[start:] {
+		if rollup.Date > toDate {
+			break
+		}
+
+		rollups = append(rollups, rollup)
+	}
+
+	return rollups
+}
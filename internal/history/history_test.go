@@ -0,0 +1,174 @@
+package history
+
+import (
+	"bytes"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func subscriber(id, status, chargeStatus string, chargeDate time.Time) providers.Subscriber {
+	return providers.Subscriber{
+		Provider:         "patreon",
+		Id:               id,
+		Status:           status,
+		LastChargeStatus: chargeStatus,
+		LastChargeDate:   chargeDate,
+		StartedAt:        time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+		AmountCents:      500,
+		Tiers:            []uint64{10},
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRecorder(s, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day1 := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	charged := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	snapshots := []struct {
+		at          time.Time
+		subscribers []providers.Subscriber
+	}{
+		{
+			at: day1,
+			subscribers: []providers.Subscriber{
+				subscriber("1", providers.StatusActive, providers.ChargeStatusPaid, charged),
+				subscriber("2", providers.StatusActive, providers.ChargeStatusPaid, charged),
+				subscriber("3", providers.StatusActive, providers.ChargeStatusPaid, charged),
+			},
+		},
+		{
+			// Patron 2 is declined, and patron 3 is removed entirely
+			at: day1.Add(time.Hour),
+			subscribers: []providers.Subscriber{
+				subscriber("1", providers.StatusActive, providers.ChargeStatusPaid, charged),
+				subscriber("2", providers.StatusDeclined, providers.ChargeStatusDeclined, charged),
+			},
+		},
+		{
+			// Unchanged snapshots must not be counted again
+			at: day1.Add(time.Hour * 2),
+			subscribers: []providers.Subscriber{
+				subscriber("1", providers.StatusActive, providers.ChargeStatusPaid, charged),
+				subscriber("2", providers.StatusDeclined, providers.ChargeStatusDeclined, charged),
+			},
+		},
+		{
+			// The following day, patron 2's retried charge is declined again
+			at: day1.AddDate(0, 0, 1),
+			subscribers: []providers.Subscriber{
+				subscriber("1", providers.StatusActive, providers.ChargeStatusPaid, charged),
+				subscriber("2", providers.StatusDeclined, providers.ChargeStatusDeclined, charged.AddDate(0, 0, 1)),
+			},
+		},
+	}
+
+	for _, snapshot := range snapshots {
+		if err := r.Record(snapshot.at, snapshot.subscribers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reload from disk, to check the history was persisted
+	r, err = NewRecorder(s, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := r.Rollups(day1.AddDate(0, 0, -7), day1.AddDate(0, 0, 7))
+	if len(rollups) != 2 {
+		t.Fatalf("expected 2 rollups, got %d", len(rollups))
+	}
+
+	first := rollups[0]
+	if first.Date != "2023-01-01" || first.Total != 2 || first.Active != 1 || first.Declined != 1 {
+		t.Errorf("unexpected gauges for first day: %+v", first)
+	}
+
+	if first.Churned != 2 || first.Declines != 1 || first.NewPledges != 2 {
+		t.Errorf("unexpected counters for first day: %+v", first)
+	}
+
+	if first.RevenueCents != 500 || first.ActiveByTier[10] != 1 {
+		t.Errorf("unexpected revenue or tiers for first day: %+v", first)
+	}
+
+	second := rollups[1]
+	if second.Date != "2023-01-02" || second.Churned != 0 || second.Declines != 1 || second.NewPledges != 0 {
+		t.Errorf("unexpected second day: %+v", second)
+	}
+
+	if rollups := r.Rollups(day1.AddDate(0, 0, 1), day1.AddDate(0, 0, 1)); len(rollups) != 1 || rollups[0].Date != "2023-01-02" {
+		t.Errorf("expected only the second day, got %+v", rollups)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if got := Sparkline([]float64{0, 7, 14}); got != "▁▅█" {
+		t.Errorf("unexpected sparkline %q", got)
+	}
+
+	if got := Sparkline([]float64{5, 5}); got != "▁▁" {
+		t.Errorf("unexpected sparkline for flat values %q", got)
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	encoded, err := RenderChart([]float64{1, 3, 2, 5}, 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 200 || bounds.Dy() != 100 {
+		t.Errorf("unexpected image size %v", bounds)
+	}
+}
+
+func TestMigrateLegacyState(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// State recorded before there was more than one provider
+	charged := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	legacy := map[string]any{
+		"previous": map[string]any{
+			"1": map[string]any{"status": "active_patron", "last_charge_status": "Paid", "last_charge_date": charged},
+		},
+	}
+
+	if err := s.Save(documentName, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRecorder(s, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := r.Record(day, []providers.Subscriber{subscriber("1", providers.StatusActive, providers.ChargeStatusPaid, charged)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rollups := r.Rollups(day, day); len(rollups) != 1 || rollups[0].Churned != 0 {
+		t.Errorf("expected the legacy state to carry over without churn, got %+v", rollups)
+	}
+}
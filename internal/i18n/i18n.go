@@ -0,0 +1,145 @@
+// Package i18n provides the message catalogue for user-facing text. Each locale is a flat JSON file of message keys
+// to fmt format strings, named after the Discord locale code it provides (e.g. en-US.json, de.json).
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FallbackLocale is used for any message that is missing from the preferred locale
+const FallbackLocale = "en-US"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+type Catalogue struct {
+	messages map[string]map[string]string // locale -> key -> message
+}
+
+// Load reads the locale files embedded in the binary.
+func Load() (*Catalogue, error) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read locales directory")
+	}
+
+	catalogue := &Catalogue{
+		messages: make(map[string]map[string]string),
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read locale file %s", entry.Name())
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse locale file %s", entry.Name())
+		}
+
+		catalogue.messages[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+
+	if _, ok := catalogue.messages[FallbackLocale]; !ok {
+		return nil, fmt.Errorf("fallback locale %s is missing", FallbackLocale)
+	}
+
+	return catalogue, nil
+}
+
+// Locales returns the codes of all loaded locales, in sorted order.
+func (c *Catalogue) Locales() []string {
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+
+	sort.Strings(locales)
+	return locales
+}
+
+// Localizations returns the message for key in every locale other than the fallback locale, in the format used by
+// the name_localizations and description_localizations fields of application commands. Returns nil if there are
+// no translations.
+func (c *Catalogue) Localizations(key string) map[string]string {
+	var localizations map[string]string
+	for locale, messages := range c.messages {
+		if locale == FallbackLocale {
+			continue
+		}
+
+		if message, ok := messages[key]; ok {
+			if localizations == nil {
+				localizations = make(map[string]string)
+			}
+
+			localizations[locale] = message
+		}
+	}
+
+	return localizations
+}
+
+// Localizer returns a Localizer for the first of the preferred locales that is available, ignoring empty strings.
+// A locale such as es-419 will also match a catalogue locale sharing its language, such as es-ES.
+func (c *Catalogue) Localizer(preferred ...string) Localizer {
+	for _, locale := range preferred {
+		if _, ok := c.messages[locale]; ok {
+			return Localizer{catalogue: c, locale: locale}
+		}
+	}
+
+	for _, locale := range preferred {
+		if locale == "" {
+			continue
+		}
+
+		language := strings.SplitN(locale, "-", 2)[0]
+		for _, available := range c.Locales() {
+			if strings.SplitN(available, "-", 2)[0] == language {
+				return Localizer{catalogue: c, locale: available}
+			}
+		}
+	}
+
+	return Localizer{catalogue: c, locale: FallbackLocale}
+}
+
+// Localizer translates messages into a single locale.
+type Localizer struct {
+	catalogue *Catalogue
+	locale    string
+}
+
+func (l Localizer) Locale() string {
+	return l.locale
+}
+
+// T returns the message for key, formatted with args. If the key is missing from the locale, the fallback locale is
+// used, and if it is missing from both, the key itself is returned.
+func (l Localizer) T(key string, args ...any) string {
+	message, ok := l.catalogue.messages[l.locale][key]
+	if !ok {
+		message, ok = l.catalogue.messages[FallbackLocale][key]
+		if !ok {
+			return key
+		}
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf(message, args...)
+}
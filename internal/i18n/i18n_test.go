@@ -0,0 +1,69 @@
+package i18n
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var verbPattern = regexp.MustCompile(`%(\[\d+])?[a-z]`)
+
+// TestLocalesConsistent checks that no locale has keys that the fallback locale lacks, and that translations use
+// the same format verbs as the fallback message.
+func TestLocalesConsistent(t *testing.T) {
+	catalogue, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load catalogue: %v", err)
+	}
+
+	fallback := catalogue.messages[FallbackLocale]
+	for locale, messages := range catalogue.messages {
+		for key, message := range messages {
+			fallbackMessage, ok := fallback[key]
+			if !ok {
+				t.Errorf("%s: key %s is missing from %s", locale, key, FallbackLocale)
+				continue
+			}
+
+			want := strings.Join(verbPattern.FindAllString(fallbackMessage, -1), ",")
+			got := strings.Join(verbPattern.FindAllString(message, -1), ",")
+			if want != got {
+				t.Errorf("%s: key %s has format verbs %q, expected %q", locale, key, got, want)
+			}
+		}
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	catalogue, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load catalogue: %v", err)
+	}
+
+	tests := []struct {
+		preferred []string
+		want      string
+	}{
+		{preferred: []string{"de"}, want: "de"},
+		{preferred: []string{"", "fr"}, want: "fr"},
+		{preferred: []string{"es-419"}, want: "es-ES"},
+		{preferred: []string{"ja", "de"}, want: "de"},
+		{preferred: []string{"ja"}, want: FallbackLocale},
+		{preferred: nil, want: FallbackLocale},
+	}
+
+	for _, tc := range tests {
+		if got := catalogue.Localizer(tc.preferred...).Locale(); got != tc.want {
+			t.Errorf("Localizer(%v): expected %s, got %s", tc.preferred, tc.want, got)
+		}
+	}
+
+	localizer := catalogue.Localizer("de")
+	if got := localizer.T("lookup.unknown_tier", 5); got != "Unbekannt (ID: 5)" {
+		t.Errorf("unexpected translation %q", got)
+	}
+
+	if got := localizer.T("missing.key"); got != "missing.key" {
+		t.Errorf("expected missing key to be returned as-is, got %q", got)
+	}
+}
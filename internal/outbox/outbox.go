@@ -0,0 +1,672 @@
+// Package outbox delivers events to the configured webhook endpoints. Each event is queued as a delivery to every
+// endpoint subscribed to its type, and the queue is persisted so that deliveries survive restarts. Failed deliveries
+// are retried with exponential backoff, and kept for admins to inspect and retry once they run out of attempts.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const document = "outbox"
+
+// Headers sent with each delivery
+const (
+	HeaderSignature = "X-Subscriptions-Signature"
+	HeaderDelivery  = "X-Subscriptions-Delivery"
+	HeaderEvent     = "X-Subscriptions-Event"
+)
+
+// Values of Delivery.Status
+const (
+	StatusPending = "pending"
+	StatusFailed  = "failed" // Every attempt has failed
+)
+
+// maxFailed is how many failed deliveries are kept. The oldest are dropped first.
+const maxFailed = 1000
+
+// pollInterval is how often due deliveries are checked for, in addition to whenever an event is queued
+const pollInterval = time.Second * 5
+
+// saveInterval is how often changes to the queue are saved while Run is running. Changes are saved in batches so that
+// neither queueing events nor sending deliveries waits on the disk.
+const saveInterval = time.Second
+
+type Delivery struct {
+	Id            string          `json:"id"`
+	Endpoint      string          `json:"endpoint"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+}
+
+// EndpointStats summarises the deliveries to an endpoint.
+type EndpointStats struct {
+	Delivered       int        `json:"delivered"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+	LastFailedAt    *time.Time `json:"last_failed_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// Payload is the body of a delivery: the event, and the ID of the delivery, which is the same for every attempt so
+// that receivers can ignore duplicates.
+type Payload struct {
+	Id string `json:"id"`
+	changes.Event
+}
+
+type outboxDocument struct {
+	Deliveries []Delivery               `json:"deliveries"`
+	Endpoints  map[string]EndpointStats `json:"endpoints"`
+}
+
+type Outbox struct {
+	endpoints      []config.WebhookEndpoint
+	maxAttempts    int
+	maxPending     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	httpClient     *http.Client
+	store          *store.Store
+	logger         *zap.Logger
+	notify         map[string]chan struct{} // Endpoint name -> signalled when a delivery is queued for the endpoint
+	now            func() time.Time
+
+	saveMu sync.Mutex // Held while saving, so that saves are written in order
+
+	mu    sync.Mutex
+	doc   outboxDocument
+	dirty bool // Whether doc has changed since it was last saved
+}
+
+var _ changes.Sink = (*Outbox)(nil)
+
+// New validates the configured endpoints, and loads the queued deliveries.
+func New(conf config.Config, store *store.Store, logger *zap.Logger) (*Outbox, error) {
+	names := make(map[string]bool)
+	for _, endpoint := range conf.Webhooks.Endpoints {
+		if endpoint.Name == "" || names[endpoint.Name] {
+			return nil, fmt.Errorf("webhook endpoint names must be set and unique, got %q", endpoint.Name)
+		}
+
+		names[endpoint.Name] = true
+
+		if parsed, err := url.Parse(endpoint.Url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("webhook endpoint %s has an invalid URL", endpoint.Name)
+		}
+
+		if endpoint.Secret == "" {
+			return nil, fmt.Errorf("webhook endpoint %s has no secret", endpoint.Name)
+		}
+
+		for _, eventType := range endpoint.Events {
+			switch eventType {
+			case changes.TypeCreated, changes.TypeUpdated, changes.TypeDeleted, changes.TypeEntitlementChanged:
+			default:
+				return nil, fmt.Errorf("webhook endpoint %s has unknown event type %q", endpoint.Name, eventType)
+			}
+		}
+	}
+
+	o := &Outbox{
+		endpoints:      conf.Webhooks.Endpoints,
+		maxAttempts:    conf.Webhooks.MaxAttempts,
+		maxPending:     conf.Webhooks.MaxPending,
+		initialBackoff: conf.Webhooks.InitialBackoff.Duration(),
+		maxBackoff:     conf.Webhooks.MaxBackoff.Duration(),
+		httpClient:     &http.Client{Timeout: conf.Webhooks.Timeout.Duration()},
+		store:          store,
+		logger:         logger,
+		notify:         make(map[string]chan struct{}),
+		now:            time.Now,
+		doc: outboxDocument{
+			Endpoints: make(map[string]EndpointStats),
+		},
+	}
+
+	for _, endpoint := range conf.Webhooks.Endpoints {
+		o.notify[endpoint.Name] = make(chan struct{}, 1)
+	}
+
+	if err := store.Load(document, &o.doc); err != nil {
+		return nil, err
+	}
+
+	if o.doc.Endpoints == nil {
+		o.doc.Endpoints = make(map[string]EndpointStats)
+	}
+
+	return o, nil
+}
+
+// Endpoints returns the configured endpoints.
+func (o *Outbox) Endpoints() []config.WebhookEndpoint {
+	return o.endpoints
+}
+
+// Publish queues a delivery of each event to every endpoint subscribed to its type. Publish does not block on the
+// deliveries themselves, which are sent by Run, nor on saving the queue, which Run does in the background.
+func (o *Outbox) Publish(_ changes.Snapshot, events []changes.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	now := o.now()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queued := make(map[string]bool)
+	for _, event := range events {
+		for _, endpoint := range o.endpoints {
+			if !subscribed(endpoint, event.Type) {
+				continue
+			}
+
+			id, err := newId()
+			if err != nil {
+				o.logger.Error("Failed to generate delivery ID", zap.Error(err))
+				continue
+			}
+
+			payload, err := json.Marshal(Payload{Id: id, Event: event})
+			if err != nil {
+				o.logger.Error("Failed to encode event", zap.Error(err))
+				continue
+			}
+
+			o.doc.Deliveries = append(o.doc.Deliveries, Delivery{
+				Id:            id,
+				Endpoint:      endpoint.Name,
+				EventType:     event.Type,
+				Payload:       payload,
+				Status:        StatusPending,
+				CreatedAt:     now,
+				NextAttemptAt: now,
+			})
+
+			queued[endpoint.Name] = true
+		}
+	}
+
+	if len(queued) == 0 {
+		return
+	}
+
+	for name := range queued {
+		o.trimPending(name, now)
+		o.signal(name)
+	}
+
+	o.trimFailed()
+	o.dirty = true
+}
+
+// Run sends due deliveries, with a worker for each endpoint so that a slow or unavailable endpoint does not hold up
+// the others, and saves the queue periodically, until the context is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	o.failRemoved(o.now())
+
+	var wg sync.WaitGroup
+	for _, endpoint := range o.endpoints {
+		wg.Add(1)
+		go func(endpoint config.WebhookEndpoint) {
+			defer wg.Done()
+			o.runEndpoint(ctx, endpoint)
+		}(endpoint)
+	}
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			o.flush()
+			return
+		case <-ticker.C:
+			o.flush()
+		}
+	}
+}
+
+func (o *Outbox) runEndpoint(ctx context.Context, endpoint config.WebhookEndpoint) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.deliverDue(ctx, endpoint)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.notify[endpoint.Name]:
+		}
+	}
+}
+
+// DeliverDue makes an attempt at each pending delivery that is due, to every endpoint at once, and saves the queue
+// once they are done. As deliveries are retried independently, receivers may see events out of order, and should use
+// each event's time to order them.
+func (o *Outbox) DeliverDue(ctx context.Context) {
+	o.failRemoved(o.now())
+
+	var wg sync.WaitGroup
+	for _, endpoint := range o.endpoints {
+		wg.Add(1)
+		go func(endpoint config.WebhookEndpoint) {
+			defer wg.Done()
+			o.deliverDue(ctx, endpoint)
+		}(endpoint)
+	}
+
+	wg.Wait()
+	o.flush()
+}
+
+// deliverDue makes an attempt at each pending delivery to the endpoint that is due, oldest first. The rest are left
+// until the next round after an attempt fails, as the endpoint is likely to be unavailable.
+func (o *Outbox) deliverDue(ctx context.Context, endpoint config.WebhookEndpoint) {
+	for _, delivery := range o.due(endpoint.Name, o.now()) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := o.send(ctx, endpoint, delivery, o.now())
+		if err != nil {
+			o.logger.Warn(
+				"Failed to deliver webhook",
+				zap.String("endpoint", endpoint.Name),
+				zap.String("delivery_id", delivery.Id),
+				zap.Int("attempt", delivery.Attempts+1),
+				zap.Error(err),
+			)
+		}
+
+		o.finish(delivery, o.now(), err, false)
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// failRemoved marks the pending deliveries to endpoints that have been removed from the config since they were queued
+// as failed.
+func (o *Outbox) failRemoved(now time.Time) {
+	o.mu.Lock()
+	var removed []Delivery
+	for _, delivery := range o.doc.Deliveries {
+		if _, ok := o.endpoint(delivery.Endpoint); !ok && delivery.Status == StatusPending {
+			removed = append(removed, delivery)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, delivery := range removed {
+		o.finish(delivery, now, errors.New("endpoint is no longer configured"), true)
+	}
+}
+
+func (o *Outbox) due(endpoint string, now time.Time) []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []Delivery
+	for _, delivery := range o.doc.Deliveries {
+		if delivery.Endpoint == endpoint && delivery.Status == StatusPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	return due
+}
+
+func (o *Outbox) send(ctx context.Context, endpoint config.WebhookEndpoint, delivery Delivery, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(delivery.Payload, endpoint.Secret, now))
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+
+	res, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// finish records the outcome of an attempt made at the given time. Successful deliveries are removed from the queue,
+// while failed deliveries are retried after a backoff, or marked as failed once they have no attempts left or are not
+// to be retried.
+func (o *Outbox) finish(attempted Delivery, now time.Time, err error, final bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.index(attempted.Id)
+	if i == -1 {
+		return
+	}
+
+	delivery := &o.doc.Deliveries[i]
+	stats := o.doc.Endpoints[delivery.Endpoint]
+
+	if err == nil {
+		stats.Delivered++
+		stats.LastDeliveredAt = &now
+		o.doc.Deliveries = append(o.doc.Deliveries[:i], o.doc.Deliveries[i+1:]...)
+	} else {
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.LastError = err.Error()
+
+		if final || delivery.Attempts >= o.maxAttempts {
+			delivery.Status = StatusFailed
+			o.logger.Error(
+				"Webhook delivery failed",
+				zap.String("endpoint", delivery.Endpoint),
+				zap.String("delivery_id", delivery.Id),
+				zap.Int("attempts", delivery.Attempts),
+				zap.Error(err),
+			)
+		} else {
+			delivery.NextAttemptAt = now.Add(o.Backoff(delivery.Attempts))
+		}
+
+		stats.LastFailedAt = &now
+		stats.LastError = err.Error()
+		o.trimFailed()
+	}
+
+	o.doc.Endpoints[attempted.Endpoint] = stats
+	o.dirty = true
+}
+
+// Backoff returns how long to wait before the next attempt, after the given number of failed attempts.
+func (o *Outbox) Backoff(attempts int) time.Duration {
+	backoff := o.initialBackoff
+	for i := 1; i < attempts && backoff < o.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > o.maxBackoff {
+		return o.maxBackoff
+	}
+
+	return backoff
+}
+
+// Deliveries returns the queued deliveries with the given status, or every delivery if status is empty, oldest first.
+func (o *Outbox) Deliveries(status string) []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	deliveries := make([]Delivery, 0)
+	for _, delivery := range o.doc.Deliveries {
+		if status == "" || delivery.Status == status {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+
+	return deliveries
+}
+
+// Stats returns a summary of the deliveries to each endpoint, along with how many are pending and failed.
+func (o *Outbox) Stats(endpoint string) (stats EndpointStats, pending, failed int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, delivery := range o.doc.Deliveries {
+		if delivery.Endpoint != endpoint {
+			continue
+		}
+
+		switch delivery.Status {
+		case StatusPending:
+			pending++
+		case StatusFailed:
+			failed++
+		}
+	}
+
+	return o.doc.Endpoints[endpoint], pending, failed
+}
+
+// Retry requeues a failed delivery, with a fresh set of attempts. Returns false if there is no failed delivery with
+// the ID.
+func (o *Outbox) Retry(id string, now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.index(id)
+	if i == -1 || o.doc.Deliveries[i].Status != StatusFailed {
+		return false
+	}
+
+	delivery := &o.doc.Deliveries[i]
+	delivery.Status = StatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+
+	o.signal(delivery.Endpoint)
+	o.dirty = true
+
+	return true
+}
+
+// signal wakes the worker for the endpoint, if it is waiting.
+func (o *Outbox) signal(endpoint string) {
+	select {
+	case o.notify[endpoint] <- struct{}{}:
+	default:
+	}
+}
+
+// index returns the index of the delivery with the ID, or -1. The caller must hold the lock.
+func (o *Outbox) index(id string) int {
+	for i, delivery := range o.doc.Deliveries {
+		if delivery.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// trimPending marks the oldest pending deliveries to the endpoint beyond maxPending as failed, so that the queue does
+// not grow without bound while an endpoint is unavailable. The caller must hold the lock.
+func (o *Outbox) trimPending(endpoint string, now time.Time) {
+	var pending int
+	for _, delivery := range o.doc.Deliveries {
+		if delivery.Endpoint == endpoint && delivery.Status == StatusPending {
+			pending++
+		}
+	}
+
+	if pending <= o.maxPending {
+		return
+	}
+
+	o.logger.Warn("Too many pending webhook deliveries, marking the oldest as failed",
+		zap.String("endpoint", endpoint),
+		zap.Int("dropped", pending-o.maxPending),
+	)
+
+	// Deliveries are appended as they are queued, so the oldest come first
+	for i := range o.doc.Deliveries {
+		delivery := &o.doc.Deliveries[i]
+		if pending <= o.maxPending {
+			break
+		}
+
+		if delivery.Endpoint != endpoint || delivery.Status != StatusPending {
+			continue
+		}
+
+		delivery.Status = StatusFailed
+		delivery.LastError = "dropped as too many deliveries were pending"
+		pending--
+	}
+
+	stats := o.doc.Endpoints[endpoint]
+	stats.LastFailedAt = &now
+	stats.LastError = "dropped deliveries as too many were pending"
+	o.doc.Endpoints[endpoint] = stats
+}
+
+// trimFailed drops the oldest failed deliveries beyond maxFailed. The caller must hold the lock.
+func (o *Outbox) trimFailed() {
+	var failed int
+	for _, delivery := range o.doc.Deliveries {
+		if delivery.Status == StatusFailed {
+			failed++
+		}
+	}
+
+	if failed <= maxFailed {
+		return
+	}
+
+	kept := o.doc.Deliveries[:0]
+	for _, delivery := range o.doc.Deliveries {
+		if delivery.Status == StatusFailed && failed > maxFailed {
+			failed--
+			continue
+		}
+
+		kept = append(kept, delivery)
+	}
+
+	o.doc.Deliveries = kept
+}
+
+func (o *Outbox) endpoint(name string) (config.WebhookEndpoint, bool) {
+	for _, endpoint := range o.endpoints {
+		if endpoint.Name == name {
+			return endpoint, true
+		}
+	}
+
+	return config.WebhookEndpoint{}, false
+}
+
+// save persists the document, if it has changed since it was last saved. The lock is only held while the document is
+// copied, so that writing it does not block queueing or delivering.
+func (o *Outbox) save() error {
+	o.saveMu.Lock()
+	defer o.saveMu.Unlock()
+
+	o.mu.Lock()
+	if !o.dirty {
+		o.mu.Unlock()
+		return nil
+	}
+
+	doc := outboxDocument{
+		Deliveries: append([]Delivery(nil), o.doc.Deliveries...),
+		Endpoints:  make(map[string]EndpointStats, len(o.doc.Endpoints)),
+	}
+
+	for name, stats := range o.doc.Endpoints {
+		doc.Endpoints[name] = stats
+	}
+
+	o.dirty = false
+	o.mu.Unlock()
+
+	if err := o.store.Save(document, doc); err != nil {
+		o.mu.Lock()
+		o.dirty = true
+		o.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// flush saves the document, logging any error, as the document is saved again at the next interval if it fails.
+func (o *Outbox) flush() {
+	if err := o.save(); err != nil {
+		o.logger.Error("Failed to save outbox", zap.Error(err))
+	}
+}
+
+// Sign returns the value of the signature header for a payload: the time it was signed, and an HMAC-SHA256 of the
+// time and the payload, in the form t=<unix time>,v1=<hex signature>. Receivers should recompute the signature from
+// the raw body, and reject signatures more than a few minutes old.
+func Sign(payload []byte, secret string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(endpoint config.WebhookEndpoint, eventType string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+
+	for _, subscribedType := range endpoint.Events {
+		if subscribedType == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
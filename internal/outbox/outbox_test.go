@@ -0,0 +1,263 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	t.Helper()
+
+	r := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		w.WriteHeader(r.status)
+	}))
+
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func testConfig(endpoints ...config.WebhookEndpoint) config.Config {
+	var conf config.Config
+	conf.Webhooks.Endpoints = endpoints
+	conf.Webhooks.MaxAttempts = 3
+	conf.Webhooks.InitialBackoff = config.Duration(time.Second)
+	conf.Webhooks.MaxBackoff = config.Duration(time.Second * 3)
+	conf.Webhooks.Timeout = config.Duration(time.Second * 5)
+	conf.Webhooks.MaxPending = 100
+	return conf
+}
+
+// newTestOutbox returns an outbox which reads the time from now, so that tests can control it
+func newTestOutbox(t *testing.T, s *store.Store, conf config.Config, now *time.Time) *Outbox {
+	t.Helper()
+
+	o, err := New(conf, s, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.now = func() time.Time {
+		return *now
+	}
+
+	return o
+}
+
+func created(id string) changes.Event {
+	return changes.Event{
+		Type:       changes.TypeCreated,
+		Time:       time.Now(),
+		Subscriber: &providers.Subscriber{Provider: "patreon", Id: id, Status: providers.StatusActive},
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	r, server := newReceiver(t)
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	o := newTestOutbox(t, s, testConfig(
+		config.WebhookEndpoint{Name: "all", Url: server.URL, Secret: "secret"},
+		config.WebhookEndpoint{Name: "entitlements", Url: server.URL, Secret: "other", Events: []string{changes.TypeEntitlementChanged}},
+	), &now)
+
+	o.Publish(changes.Snapshot{}, []changes.Event{created("1")})
+
+	// Only the endpoint subscribed to every event receives it
+	if pending := o.Deliveries(StatusPending); len(pending) != 1 || pending[0].Endpoint != "all" {
+		t.Fatalf("unexpected pending deliveries %+v", pending)
+	}
+
+	o.DeliverDue(context.Background())
+
+	if len(r.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(r.requests))
+	}
+
+	req, body := r.requests[0], r.bodies[0]
+	if got, want := req.Header.Get(HeaderSignature), Sign(body, "secret", now); got != want {
+		t.Errorf("expected signature %q, got %q", want, got)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Id == "" || payload.Id != req.Header.Get(HeaderDelivery) || payload.Type != changes.TypeCreated || payload.Subscriber.Key() != "patreon:1" {
+		t.Errorf("unexpected payload %s", body)
+	}
+
+	if req.Header.Get(HeaderEvent) != changes.TypeCreated {
+		t.Errorf("unexpected event header %q", req.Header.Get(HeaderEvent))
+	}
+
+	// Delivered events are removed from the queue
+	if deliveries := o.Deliveries(""); len(deliveries) != 0 {
+		t.Errorf("expected the queue to be empty, got %+v", deliveries)
+	}
+
+	if stats, pending, failed := o.Stats("all"); stats.Delivered != 1 || stats.LastDeliveredAt == nil || pending != 0 || failed != 0 {
+		t.Errorf("unexpected stats %+v, %d pending, %d failed", stats, pending, failed)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	r, server := newReceiver(t)
+	r.status = http.StatusInternalServerError
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testConfig(config.WebhookEndpoint{Name: "flaky", Url: server.URL, Secret: "secret"})
+	now := time.Now()
+	o := newTestOutbox(t, s, conf, &now)
+	o.Publish(changes.Snapshot{}, []changes.Event{created("1")})
+	o.DeliverDue(context.Background())
+
+	pending := o.Deliveries(StatusPending)
+	if len(pending) != 1 || pending[0].Attempts != 1 || !pending[0].NextAttemptAt.Equal(now.Add(time.Second)) || pending[0].LastError == "" {
+		t.Fatalf("expected the delivery to be retried after a second, got %+v", pending)
+	}
+
+	// Nothing is sent until the backoff has passed
+	now = now.Add(time.Millisecond * 500)
+	o.DeliverDue(context.Background())
+	if len(r.requests) != 1 {
+		t.Fatalf("expected no attempt during the backoff, got %d requests", len(r.requests))
+	}
+
+	// The queue is persisted
+	o = newTestOutbox(t, s, conf, &now)
+
+	now = now.Add(time.Millisecond * 500)
+	o.DeliverDue(context.Background())
+	if pending := o.Deliveries(StatusPending); len(pending) != 1 || !pending[0].NextAttemptAt.Equal(now.Add(time.Second*2)) {
+		t.Fatalf("expected the backoff to double, got %+v", pending)
+	}
+
+	now = now.Add(time.Second * 2)
+	o.DeliverDue(context.Background())
+
+	failed := o.Deliveries(StatusFailed)
+	if len(failed) != 1 || failed[0].Attempts != 3 {
+		t.Fatalf("expected the delivery to fail after 3 attempts, got %+v", failed)
+	}
+
+	if _, pending, failedCount := o.Stats("flaky"); pending != 0 || failedCount != 1 {
+		t.Errorf("expected 1 failed delivery, got %d pending and %d failed", pending, failedCount)
+	}
+
+	// Failed deliveries can be retried by an admin
+	r.mu.Lock()
+	r.status = http.StatusNoContent
+	r.mu.Unlock()
+
+	if !o.Retry(failed[0].Id, now) {
+		t.Fatal("expected the delivery to be retried")
+	}
+
+	o.DeliverDue(context.Background())
+	if deliveries := o.Deliveries(""); len(deliveries) != 0 {
+		t.Errorf("expected the retried delivery to succeed, got %+v", deliveries)
+	}
+
+	if o.Retry("unknown", now) {
+		t.Error("expected an unknown delivery not to be retried")
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testConfig(config.WebhookEndpoint{Name: "down", Url: "https://example.com", Secret: "secret"})
+	conf.Webhooks.MaxPending = 2
+
+	now := time.Now()
+	o := newTestOutbox(t, s, conf, &now)
+
+	for _, id := range []string{"1", "2", "3"} {
+		o.Publish(changes.Snapshot{}, []changes.Event{created(id)})
+		now = now.Add(time.Second)
+	}
+
+	// The oldest delivery is marked as failed to make room for the newest
+	pending, failed := o.Deliveries(StatusPending), o.Deliveries(StatusFailed)
+	if len(pending) != 2 || len(failed) != 1 || !failed[0].CreatedAt.Before(pending[0].CreatedAt) {
+		t.Errorf("expected the oldest delivery to be dropped, got %+v pending and %+v failed", pending, failed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := &Outbox{initialBackoff: time.Second * 30, maxBackoff: time.Minute * 5}
+
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second * 30,
+		2: time.Minute,
+		4: time.Minute * 4,
+		5: time.Minute * 5,
+		9: time.Minute * 5,
+	} {
+		if got := o.Backoff(attempts); got != want {
+			t.Errorf("after %d attempts: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestInvalidEndpoints(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, endpoint := range map[string]config.WebhookEndpoint{
+		"missing name":  {Url: "https://example.com", Secret: "secret"},
+		"invalid url":   {Name: "a", Url: "example.com", Secret: "secret"},
+		"no secret":     {Name: "a", Url: "https://example.com"},
+		"unknown event": {Name: "a", Url: "https://example.com", Secret: "secret", Events: []string{"unknown"}},
+	} {
+		if _, err := New(testConfig(endpoint), s, zap.NewNop()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	duplicate := config.WebhookEndpoint{Name: "a", Url: "https://example.com", Secret: "secret"}
+	if _, err := New(testConfig(duplicate, duplicate), s, zap.NewNop()); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+}
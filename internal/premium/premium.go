@@ -0,0 +1,193 @@
+// Package premium stores which guilds each patron has assigned their premium to.
+package premium
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const documentName = "premium_assignments"
+
+var (
+	ErrAlreadyAssigned = errors.New("guild is already assigned")
+	ErrLimitReached    = errors.New("guild limit reached")
+)
+
+type Assignment struct {
+	UserId     uint64    `json:"user_id,string"` // The Discord user whose premium is assigned
+	GuildId    uint64    `json:"guild_id,string"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+type document struct {
+	Assignments []Assignment `json:"assignments"`
+}
+
+type Manager struct {
+	store *store.Store
+	mu    sync.RWMutex
+	doc   document
+}
+
+// NewManager loads the existing assignments from the store.
+func NewManager(store *store.Store) (*Manager, error) {
+	m := &Manager{
+		store: store,
+	}
+
+	if err := store.Load(documentName, &m.doc); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Assign assigns the user's premium to the guild, provided they have fewer than limit guilds assigned already.
+func (m *Manager) Assign(now time.Time, userId, guildId uint64, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, assignment := range m.doc.Assignments {
+		if assignment.UserId != userId {
+			continue
+		}
+
+		if assignment.GuildId == guildId {
+			return ErrAlreadyAssigned
+		}
+
+		count++
+	}
+
+	if count >= limit {
+		return ErrLimitReached
+	}
+
+	// Write to a copy, so that a failed save leaves the assignments as they were
+	assignments := make([]Assignment, len(m.doc.Assignments), len(m.doc.Assignments)+1)
+	copy(assignments, m.doc.Assignments)
+	assignments = append(assignments, Assignment{
+		UserId:     userId,
+		GuildId:    guildId,
+		AssignedAt: now,
+	})
+
+	return m.save(assignments)
+}
+
+// Unassign removes the user's assignment to the guild, returning false if there was none.
+func (m *Manager) Unassign(userId, guildId uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, assignment := range m.doc.Assignments {
+		if assignment.UserId == userId && assignment.GuildId == guildId {
+			// Write to a copy, so that a failed save leaves the assignments as they were
+			assignments := make([]Assignment, 0, len(m.doc.Assignments)-1)
+			assignments = append(assignments, m.doc.Assignments[:i]...)
+			assignments = append(assignments, m.doc.Assignments[i+1:]...)
+
+			if err := m.save(assignments); err != nil {
+				return false, err
+			}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ForUser returns the guilds the user has assigned their premium to, oldest first.
+func (m *Manager) ForUser(userId uint64) []Assignment {
+	return m.filter(func(a Assignment) bool { return a.UserId == userId })
+}
+
+// ForGuild returns the users who have assigned their premium to the guild, oldest first.
+func (m *Manager) ForGuild(guildId uint64) []Assignment {
+	return m.filter(func(a Assignment) bool { return a.GuildId == guildId })
+}
+
+// All returns every assignment, oldest first.
+func (m *Manager) All() []Assignment {
+	return m.filter(func(Assignment) bool { return true })
+}
+
+// Release removes assignments beyond each user's current limit, keeping their oldest assignments. A limit of 0
+// releases all of the user's assignments. The released assignments are returned.
+func (m *Manager) Release(limit func(userId uint64) int) ([]Assignment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept, released []Assignment
+	counts := make(map[uint64]int)
+	limits := make(map[uint64]int)
+
+	for _, assignment := range m.sorted() {
+		userLimit, ok := limits[assignment.UserId]
+		if !ok {
+			userLimit = limit(assignment.UserId)
+			limits[assignment.UserId] = userLimit
+		}
+
+		if counts[assignment.UserId] >= userLimit {
+			released = append(released, assignment)
+			continue
+		}
+
+		counts[assignment.UserId]++
+		kept = append(kept, assignment)
+	}
+
+	if len(released) == 0 {
+		return nil, nil
+	}
+
+	if err := m.save(kept); err != nil {
+		return nil, err
+	}
+
+	return released, nil
+}
+
+func (m *Manager) filter(f func(Assignment) bool) []Assignment {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var assignments []Assignment
+	for _, assignment := range m.sorted() {
+		if f(assignment) {
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	return assignments
+}
+
+// sorted returns a copy of the assignments, oldest first. The caller must hold the lock.
+func (m *Manager) sorted() []Assignment {
+	assignments := make([]Assignment, len(m.doc.Assignments))
+	copy(assignments, m.doc.Assignments)
+
+	sort.SliceStable(assignments, func(i, j int) bool {
+		return assignments[i].AssignedAt.Before(assignments[j].AssignedAt)
+	})
+
+	return assignments
+}
+
+// save persists the assignments, and replaces the current assignments with them if successful. The caller must hold
+// the write lock.
+func (m *Manager) save(assignments []Assignment) error {
+	doc := document{Assignments: assignments}
+	if err := m.store.Save(documentName, doc); err != nil {
+		return err
+	}
+
+	m.doc = doc
+	return nil
+}
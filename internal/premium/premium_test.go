@@ -0,0 +1,154 @@
+package premium
+
+import (
+	"errors"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T) (*Manager, *store.Store) {
+	t.Helper()
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, s
+}
+
+func TestAssign(t *testing.T) {
+	m, s := newTestManager(t)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := m.Assign(now, 1, 100, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Assign(now, 1, 100, 2); !errors.Is(err, ErrAlreadyAssigned) {
+		t.Errorf("expected ErrAlreadyAssigned, got %v", err)
+	}
+
+	if err := m.Assign(now.Add(time.Minute), 1, 200, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Assign(now, 1, 300, 2); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("expected ErrLimitReached, got %v", err)
+	}
+
+	// Another user may assign the same guild
+	if err := m.Assign(now, 2, 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.ForGuild(100); len(got) != 2 {
+		t.Errorf("expected 2 assignments to guild 100, got %+v", got)
+	}
+
+	removed, err := m.Unassign(1, 100)
+	if err != nil || !removed {
+		t.Fatalf("expected assignment to be removed, got %v, %v", removed, err)
+	}
+
+	if removed, _ := m.Unassign(1, 100); removed {
+		t.Error("expected second unassign to do nothing")
+	}
+
+	// Reload from disk
+	m, err = NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.ForUser(1); len(got) != 1 || got[0].GuildId != 200 {
+		t.Errorf("unexpected assignments for user 1: %+v", got)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	m, _ := newTestManager(t)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, guildId := range []uint64{100, 200, 300} {
+		if err := m.Assign(now.Add(time.Duration(i)*time.Minute), 1, guildId, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Assign(now, 2, 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// User 1 is downgraded to a single guild, and user 2 lapses entirely
+	released, err := m.Release(func(userId uint64) int {
+		if userId == 1 {
+			return 1
+		}
+
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(released) != 3 {
+		t.Errorf("expected 3 released assignments, got %+v", released)
+	}
+
+	if all := m.All(); len(all) != 1 || all[0].UserId != 1 || all[0].GuildId != 100 {
+		t.Errorf("expected only user 1's oldest assignment to be kept, got %+v", all)
+	}
+}
+
+func TestFailedSave(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.Assign(now, 1, 100, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The document cannot be replaced by a directory that isn't empty
+	if err := os.RemoveAll(filepath.Join(dir, documentName+".json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, documentName+".json", "blocked"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the changes are kept in memory if the save fails
+	if err := m.Assign(now, 1, 200, 2); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if _, err := m.Unassign(1, 100); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if _, err := m.Release(func(uint64) int { return 0 }); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if assignments := m.All(); len(assignments) != 1 || assignments[0].GuildId != 100 {
+		t.Errorf("expected the assignments to be unchanged, got %+v", assignments)
+	}
+}
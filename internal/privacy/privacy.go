@@ -0,0 +1,19 @@
+// Package privacy contains helpers for redacting personal data before it is displayed.
+package privacy
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaskEmail hides all but the first character of the local part of an email address, e.g. j***@gmail.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+
+	// Keep the whole first character, which may be more than one byte
+	_, size := utf8.DecodeRuneInString(email)
+	return email[:size] + "***" + email[at:]
+}
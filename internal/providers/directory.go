@@ -0,0 +1,151 @@
+package providers
+
+import (
+	"sort"
+	"sync"
+)
+
+// Directory merges the updates from every provider, and looks up subscribers across all of them.
+type Directory struct {
+	mu          sync.RWMutex
+	byProvider  map[string]map[string]Subscriber // Provider -> ID -> Subscriber
+	byEmail     map[string]Subscriber
+	byDiscordId map[uint64]Subscriber
+	all         []Subscriber
+	required    []string        // The providers that must send a full update before the directory is loaded
+	loaded      map[string]bool // The providers that have sent a full update
+}
+
+// NewDirectory creates an empty directory, which is loaded once each of the required providers has sent a full
+// update, or once any provider has if none are required.
+func NewDirectory(required ...string) *Directory {
+	return &Directory{
+		byProvider: make(map[string]map[string]Subscriber),
+		required:   required,
+		loaded:     make(map[string]bool),
+	}
+}
+
+// Apply merges the update into the directory.
+func (d *Directory) Apply(update Update) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscribers := d.byProvider[update.Provider]
+	if subscribers == nil || update.Full {
+		subscribers = make(map[string]Subscriber, len(update.Subscribers))
+		d.byProvider[update.Provider] = subscribers
+	}
+
+	for _, subscriber := range update.Subscribers {
+		subscriber.Provider = update.Provider
+		subscribers[subscriber.Id] = subscriber
+	}
+
+	if update.Full {
+		d.loaded[update.Provider] = true
+	}
+
+	d.rebuild()
+}
+
+// rebuild recomputes the indexes. The caller must hold the write lock.
+func (d *Directory) rebuild() {
+	d.all = d.all[:0]
+	for _, subscribers := range d.byProvider {
+		for _, subscriber := range subscribers {
+			d.all = append(d.all, subscriber)
+		}
+	}
+
+	// Sort so that the indexes, and the order of All, are stable
+	sort.Slice(d.all, func(i, j int) bool {
+		return d.all[i].Key() < d.all[j].Key()
+	})
+
+	d.byEmail = make(map[string]Subscriber, len(d.all))
+	d.byDiscordId = make(map[uint64]Subscriber)
+	for _, subscriber := range d.all {
+		// An email address or Discord account may belong to more than one subscription, in which case prefer an
+		// active one
+		if subscriber.Email != "" {
+			if existing, ok := d.byEmail[subscriber.Email]; !ok || !existing.Active() {
+				d.byEmail[subscriber.Email] = subscriber
+			}
+		}
+
+		if subscriber.DiscordId != nil {
+			if existing, ok := d.byDiscordId[*subscriber.DiscordId]; !ok || !existing.Active() {
+				d.byDiscordId[*subscriber.DiscordId] = subscriber
+			}
+		}
+	}
+}
+
+// Loaded reports whether the required providers have sent a full update yet. Until then, lookups cannot be trusted
+// to find existing subscribers, and anything derived from the full set of subscribers would see most of them missing.
+func (d *Directory) Loaded() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(d.required) == 0 {
+		return len(d.loaded) > 0
+	}
+
+	for _, provider := range d.required {
+		if !d.loaded[provider] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ProviderLoaded reports whether the provider has sent a full update yet.
+func (d *Directory) ProviderLoaded(provider string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.loaded[provider]
+}
+
+func (d *Directory) ByEmail(email string) (Subscriber, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	subscriber, ok := d.byEmail[email]
+	return subscriber, ok
+}
+
+func (d *Directory) ByDiscordId(discordId uint64) (Subscriber, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	subscriber, ok := d.byDiscordId[discordId]
+	return subscriber, ok
+}
+
+// ForDiscordId returns every subscription linked to the Discord account, from every provider, ordered by key.
+func (d *Directory) ForDiscordId(discordId uint64) []Subscriber {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var subscribers []Subscriber
+	for _, subscriber := range d.all {
+		if subscriber.DiscordId != nil && *subscriber.DiscordId == discordId {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+
+	return subscribers
+}
+
+// All returns every subscriber from every provider, ordered by key.
+func (d *Directory) All() []Subscriber {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	all := make([]Subscriber, len(d.all))
+	copy(all, d.all)
+	return all
+}
@@ -0,0 +1,120 @@
+package providers
+
+import "testing"
+
+func subscriber(provider, id, email string, discordId uint64, status string) Subscriber {
+	return Subscriber{
+		Provider:  provider,
+		Id:        id,
+		Email:     email,
+		DiscordId: &discordId,
+		Status:    status,
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	d := NewDirectory()
+	if d.Loaded() {
+		t.Fatal("expected an empty directory not to be loaded")
+	}
+
+	// Partial updates alone do not mark the directory as loaded
+	d.Apply(Update{Provider: "a", Subscribers: []Subscriber{subscriber("a", "1", "one@example.com", 1, StatusFormer)}})
+	if d.Loaded() {
+		t.Fatal("expected a partial update not to load the directory")
+	}
+
+	d.Apply(Update{Provider: "b", Full: true, Subscribers: []Subscriber{
+		subscriber("b", "1", "one@example.com", 1, StatusActive),
+		subscriber("b", "2", "two@example.com", 2, StatusDeclined),
+	}})
+	if !d.Loaded() {
+		t.Fatal("expected a full update to load the directory")
+	}
+
+	// The active subscription is preferred when an email address or Discord account has more than one
+	if got, ok := d.ByEmail("one@example.com"); !ok || got.Key() != "b:1" {
+		t.Errorf("unexpected subscriber by email %+v", got)
+	}
+
+	if got, ok := d.ByDiscordId(1); !ok || got.Key() != "b:1" {
+		t.Errorf("unexpected subscriber by Discord ID %+v", got)
+	}
+
+	if all := d.All(); len(all) != 3 || all[0].Key() != "a:1" || all[2].Key() != "b:2" {
+		t.Errorf("unexpected subscribers %+v", all)
+	}
+
+	if linked := d.ForDiscordId(1); len(linked) != 2 || linked[0].Key() != "a:1" || linked[1].Key() != "b:1" {
+		t.Errorf("unexpected subscriptions for Discord ID %+v", linked)
+	}
+
+	// A full update replaces only that provider's subscribers
+	d.Apply(Update{Provider: "b", Full: true, Subscribers: []Subscriber{subscriber("b", "2", "two@example.com", 2, StatusActive)}})
+	if got, ok := d.ByEmail("one@example.com"); !ok || got.Key() != "a:1" {
+		t.Errorf("expected the remaining subscriber to be found, got %+v", got)
+	}
+
+	// A partial update upserts
+	d.Apply(Update{Provider: "a", Subscribers: []Subscriber{subscriber("a", "1", "one@example.com", 1, StatusActive)}})
+	if all := d.All(); len(all) != 2 || !all[0].Active() || !all[1].Active() {
+		t.Errorf("unexpected subscribers %+v", all)
+	}
+}
+
+func TestMigrateKey(t *testing.T) {
+	if got := MigrateKey("123"); got != "patreon:123" {
+		t.Errorf("expected a legacy key to be migrated, got %q", got)
+	}
+
+	if got := MigrateKey("stripe:cus_1"); got != "stripe:cus_1" {
+		t.Errorf("expected a current key to be unchanged, got %q", got)
+	}
+}
+
+func TestAmountCentsIn(t *testing.T) {
+	for currency, expected := range map[string]int{"": 500, "USD": 500, "usd": 500, "EUR": 0} {
+		subscriber := Subscriber{AmountCents: 500, Currency: currency}
+		if got := subscriber.AmountCentsIn("USD"); got != expected {
+			t.Errorf("%q: expected %d cents, got %d", currency, expected, got)
+		}
+	}
+}
+
+func TestMonthlyAmountCentsIn(t *testing.T) {
+	for _, test := range []struct {
+		interval string
+		count    int
+		expected int
+	}{
+		{"", 0, 1200},
+		{IntervalMonth, 1, 1200},
+		{IntervalMonth, 12, 100},
+		{IntervalYear, 1, 100},
+		{IntervalYear, 2, 50},
+		{IntervalWeek, 1, 5200},
+	} {
+		subscriber := Subscriber{AmountCents: 1200, Currency: "USD", Interval: test.interval, IntervalCount: test.count}
+		if got := subscriber.MonthlyAmountCentsIn("USD"); got != test.expected {
+			t.Errorf("%d %s: expected %d cents, got %d", test.count, test.interval, test.expected, got)
+		}
+	}
+}
+
+func TestDirectoryRequired(t *testing.T) {
+	d := NewDirectory("a", "b")
+
+	d.Apply(Update{Provider: "b", Full: true})
+	if d.Loaded() {
+		t.Fatal("expected the directory not to be loaded until every required provider has sent a full update")
+	}
+
+	if !d.ProviderLoaded("b") || d.ProviderLoaded("a") {
+		t.Fatal("expected only the provider that has sent a full update to be loaded")
+	}
+
+	d.Apply(Update{Provider: "a", Full: true})
+	if !d.Loaded() {
+		t.Fatal("expected the directory to be loaded")
+	}
+}
@@ -0,0 +1,160 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultBaseUrl = "https://discord.com/api/v10"
+
+const (
+	entitlementPageSize = 100
+	maxRateLimitRetries = 3
+)
+
+// Values of Sku.Type
+const (
+	SkuTypeDurable           = 2
+	SkuTypeConsumable        = 3
+	SkuTypeSubscription      = 5
+	SkuTypeSubscriptionGroup = 6
+)
+
+type Sku struct {
+	Id            uint64 `json:"id,string"`
+	Type          int    `json:"type"`
+	ApplicationId uint64 `json:"application_id,string"`
+	Name          string `json:"name"`
+	Slug          string `json:"slug"`
+}
+
+type Entitlement struct {
+	Id            uint64     `json:"id,string"`
+	SkuId         uint64     `json:"sku_id,string"`
+	ApplicationId uint64     `json:"application_id,string"`
+	UserId        uint64     `json:"user_id,string,omitempty"`  // 0 for entitlements granted to a guild
+	GuildId       uint64     `json:"guild_id,string,omitempty"` // 0 for entitlements granted to a user
+	Type          int        `json:"type"`
+	Deleted       bool       `json:"deleted"`
+	Consumed      bool       `json:"consumed"`
+	StartsAt      *time.Time `json:"starts_at"` // nil for test entitlements
+	EndsAt        *time.Time `json:"ends_at"`   // nil for test entitlements and one-time purchases
+}
+
+// Client calls the Discord REST API on behalf of a single application.
+type Client struct {
+	httpClient    *http.Client
+	baseUrl       string
+	token         string
+	applicationId uint64
+}
+
+func NewClient(httpClient *http.Client, baseUrl, token string, applicationId uint64) *Client {
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	return &Client{
+		httpClient:    httpClient,
+		baseUrl:       strings.TrimSuffix(baseUrl, "/"),
+		token:         token,
+		applicationId: applicationId,
+	}
+}
+
+func (c *Client) ApplicationId() uint64 {
+	return c.applicationId
+}
+
+// ListSkus returns every SKU of the application.
+func (c *Client) ListSkus(ctx context.Context) ([]Sku, error) {
+	var skus []Sku
+	if err := c.get(ctx, fmt.Sprintf("/applications/%d/skus", c.applicationId), &skus); err != nil {
+		return nil, errors.Wrap(err, "failed to list SKUs")
+	}
+
+	return skus, nil
+}
+
+// ListEntitlements returns every entitlement of the application, including those that have ended.
+func (c *Client) ListEntitlements(ctx context.Context) ([]Entitlement, error) {
+	var entitlements []Entitlement
+
+	var after uint64
+	for {
+		path := fmt.Sprintf("/applications/%d/entitlements?limit=%d", c.applicationId, entitlementPageSize)
+		if after != 0 {
+			path += "&after=" + strconv.FormatUint(after, 10)
+		}
+
+		var page []Entitlement
+		if err := c.get(ctx, path, &page); err != nil {
+			return nil, errors.Wrap(err, "failed to list entitlements")
+		}
+
+		entitlements = append(entitlements, page...)
+		if len(page) < entitlementPageSize {
+			return entitlements, nil
+		}
+
+		// Pages are ordered by ID when paginating with after
+		after = page[len(page)-1].Id
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string, response any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseUrl+path, nil)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", "Bot "+c.token)
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitRetries {
+			if err := waitForRateLimit(ctx, res.Header); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, string(body))
+		}
+
+		return json.Unmarshal(body, response)
+	}
+}
+
+func waitForRateLimit(ctx context.Context, header http.Header) error {
+	retryAfter, err := strconv.ParseFloat(header.Get("Retry-After"), 64)
+	if err != nil || retryAfter <= 0 {
+		retryAfter = 1
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(retryAfter * float64(time.Second))):
+		return nil
+	}
+}
@@ -0,0 +1,204 @@
+// Package discord provides subscribers from the entitlements of subscriptions sold through Discord, using the REST API
+// of each configured application.
+package discord
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const Name = "discord"
+
+// retryDelay is how long to wait before fetching again after a fetch fails, which is usually sooner than the refresh
+// interval
+const retryDelay = time.Second * 30
+
+type Provider struct {
+	clients  []*Client
+	tiers    map[uint64]string
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu   sync.RWMutex
+	skus map[uint64]Sku // The SKUs of every application, as of the last fetch
+}
+
+var _ providers.Provider = (*Provider)(nil)
+
+// NewProvider creates a provider which fetches from every configured application with both an ID and a bot token.
+func NewProvider(conf config.Config, logger *zap.Logger) (*Provider, error) {
+	p := &Provider{
+		tiers:    conf.Tiers,
+		interval: conf.DiscordSubscriptions.RefreshInterval.Duration(),
+		logger:   logger,
+		skus:     make(map[uint64]Sku),
+	}
+
+	// The same application may be listed multiple times with different public keys
+	seen := make(map[uint64]bool)
+	for _, app := range conf.Applications() {
+		if app.ApplicationId == 0 || app.BotToken == "" || seen[app.ApplicationId] {
+			continue
+		}
+
+		seen[app.ApplicationId] = true
+		p.clients = append(p.clients, NewClient(http.DefaultClient, conf.DiscordSubscriptions.BaseUrl, app.BotToken, app.ApplicationId))
+	}
+
+	if len(p.clients) == 0 {
+		return nil, errors.New("an application with an ID and bot token is required to fetch Discord subscriptions")
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// Fetch returns a subscriber for each entitlement of every application, refreshing the known SKUs first.
+func (p *Provider) Fetch(ctx context.Context) ([]providers.Subscriber, error) {
+	skus := make(map[uint64]Sku)
+	var entitlements []Entitlement
+	for _, client := range p.clients {
+		applicationSkus, err := client.ListSkus(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sku := range applicationSkus {
+			skus[sku.Id] = sku
+		}
+
+		applicationEntitlements, err := client.ListEntitlements(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		entitlements = append(entitlements, applicationEntitlements...)
+	}
+
+	p.mu.Lock()
+	p.skus = skus
+	p.mu.Unlock()
+
+	now := time.Now()
+	subscribers := make([]providers.Subscriber, 0, len(entitlements))
+	for _, entitlement := range entitlements {
+		if subscriber, ok := p.Subscriber(now, entitlement); ok {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+
+	return subscribers, nil
+}
+
+// Watch sends a full update every refresh interval until the context is cancelled, retrying sooner if a fetch fails.
+// Changes in between are received as entitlement events, which the server applies directly.
+func (p *Provider) Watch(ctx context.Context, updates chan<- providers.Update) {
+	for {
+		delay := p.interval
+		if !p.fetch(ctx, updates) {
+			delay = retryDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// fetch sends a full update, returning false if the entitlements could not be fetched.
+func (p *Provider) fetch(ctx context.Context, updates chan<- providers.Update) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	subscribers, err := p.Fetch(ctx)
+	if err != nil {
+		p.logger.Error("Failed to fetch entitlements, retrying", zap.Duration("retry_in", retryDelay), zap.Error(err))
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+	case updates <- providers.Update{Provider: Name, Subscribers: subscribers, Full: true}:
+	}
+
+	return true
+}
+
+// Skus returns the SKUs of every application as of the last fetch, ordered by ID.
+func (p *Provider) Skus() []Sku {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	skus := make([]Sku, 0, len(p.skus))
+	for _, sku := range p.skus {
+		skus = append(skus, sku)
+	}
+
+	sort.Slice(skus, func(i, j int) bool {
+		return skus[i].Id < skus[j].Id
+	})
+
+	return skus
+}
+
+// Subscriber converts an entitlement to the provider-neutral model. It returns false for entitlements that do not
+// represent a subscription of a user, such as consumable purchases and entitlements granted to a guild.
+func (p *Provider) Subscriber(now time.Time, entitlement Entitlement) (providers.Subscriber, bool) {
+	p.mu.RLock()
+	sku := p.skus[entitlement.SkuId]
+	p.mu.RUnlock()
+
+	if entitlement.UserId == 0 || entitlement.Consumed || sku.Type == SkuTypeConsumable {
+		return providers.Subscriber{}, false
+	}
+
+	userId := entitlement.UserId
+	subscriber := providers.Subscriber{
+		Provider:  Name,
+		Id:        strconv.FormatUint(entitlement.Id, 10),
+		DiscordId: &userId,
+		Status:    status(now, entitlement),
+	}
+
+	if entitlement.StartsAt != nil {
+		subscriber.StartedAt = *entitlement.StartsAt
+	}
+
+	// Subscriptions renew at the end of the current period
+	if entitlement.EndsAt != nil && subscriber.Active() {
+		subscriber.NextChargeDate = *entitlement.EndsAt
+	}
+
+	if subscriber.Active() {
+		if _, ok := p.tiers[entitlement.SkuId]; ok {
+			subscriber.Tiers = []uint64{entitlement.SkuId}
+		} else {
+			p.logger.Warn("unknown SKU", zap.Uint64("sku_id", entitlement.SkuId), zap.String("name", sku.Name))
+		}
+	}
+
+	return subscriber, true
+}
+
+func status(now time.Time, entitlement Entitlement) string {
+	if entitlement.Deleted ||
+		(entitlement.StartsAt != nil && entitlement.StartsAt.After(now)) ||
+		(entitlement.EndsAt != nil && !entitlement.EndsAt.After(now)) {
+		return providers.StatusFormer
+	}
+
+	return providers.StatusActive
+}
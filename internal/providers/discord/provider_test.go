@@ -0,0 +1,173 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	applicationId = 100
+	premiumSku    = 200
+	consumableSku = 201
+)
+
+func newTestServer(t *testing.T, entitlements []map[string]any) *httptest.Server {
+	t.Helper()
+
+	rateLimited := false
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/applications/%d/skus", applicationId), func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bot token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"id": strconv.Itoa(premiumSku), "type": SkuTypeSubscription, "application_id": strconv.Itoa(applicationId), "name": "Premium"},
+			{"id": strconv.Itoa(consumableSku), "type": SkuTypeConsumable, "application_id": strconv.Itoa(applicationId), "name": "Boost"},
+		})
+	})
+
+	mux.HandleFunc(fmt.Sprintf("/applications/%d/entitlements", applicationId), func(w http.ResponseWriter, r *http.Request) {
+		// Rate limits are retried after the delay Discord asks for
+		if !rateLimited {
+			rateLimited = true
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+
+		page := []map[string]any{}
+		for _, entitlement := range entitlements {
+			id, _ := strconv.Atoi(entitlement["id"].(string))
+			if id > after && len(page) < limit {
+				page = append(page, entitlement)
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(page)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func entitlement(id, userId, skuId int, startsAt, endsAt *time.Time) map[string]any {
+	return map[string]any{
+		"id":             strconv.Itoa(id),
+		"sku_id":         strconv.Itoa(skuId),
+		"application_id": strconv.Itoa(applicationId),
+		"user_id":        strconv.Itoa(userId),
+		"type":           8,
+		"deleted":        false,
+		"starts_at":      startsAt,
+		"ends_at":        endsAt,
+	}
+}
+
+func newTestProvider(t *testing.T, baseUrl string) *Provider {
+	t.Helper()
+
+	var conf config.Config
+	conf.Discord.Applications = []config.Application{{ApplicationId: applicationId, PublicKey: "key", BotToken: "token"}}
+	conf.DiscordSubscriptions.BaseUrl = baseUrl
+	conf.Tiers = map[uint64]string{premiumSku: "Premium"}
+
+	p, err := NewProvider(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestFetch(t *testing.T) {
+	now := time.Now()
+	started := now.AddDate(0, -1, 0).UTC().Truncate(time.Second)
+	renews := now.AddDate(0, 0, 7).UTC().Truncate(time.Second)
+	ended := now.AddDate(0, 0, -1).UTC().Truncate(time.Second)
+
+	// More than a page, so that pagination is exercised
+	var entitlements []map[string]any
+	for i := 1; i <= entitlementPageSize+1; i++ {
+		entitlements = append(entitlements, entitlement(i, 1000+i, premiumSku, &started, &ended))
+	}
+
+	entitlements[0] = entitlement(1, 1001, premiumSku, &started, &renews)
+	consumable := entitlement(500, 1001, consumableSku, &started, nil)
+	consumable["consumed"] = true
+	entitlements = append(entitlements, consumable)
+
+	p := newTestProvider(t, newTestServer(t, entitlements).URL)
+
+	subscribers, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumable purchase is not a subscription
+	if len(subscribers) != entitlementPageSize+1 {
+		t.Fatalf("expected %d subscribers, got %d", entitlementPageSize+1, len(subscribers))
+	}
+
+	active := subscribers[0]
+	if active.Key() != "discord:1" || active.DiscordId == nil || *active.DiscordId != 1001 || !active.Active() {
+		t.Errorf("unexpected subscriber %+v", active)
+	}
+
+	if !active.StartedAt.Equal(started) || !active.NextChargeDate.Equal(renews) || len(active.Tiers) != 1 || active.Tiers[0] != premiumSku {
+		t.Errorf("unexpected subscription %+v", active)
+	}
+
+	if lapsed := subscribers[1]; lapsed.Status != providers.StatusFormer || len(lapsed.Tiers) != 0 || !lapsed.NextChargeDate.IsZero() {
+		t.Errorf("expected an ended entitlement to be a former subscriber, got %+v", lapsed)
+	}
+
+	if skus := p.Skus(); len(skus) != 2 || skus[0].Id != premiumSku || skus[0].Name != "Premium" {
+		t.Errorf("unexpected SKUs %+v", skus)
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	p := newTestProvider(t, "")
+	now := time.Now()
+
+	if _, ok := p.Subscriber(now, Entitlement{Id: 1, SkuId: premiumSku, GuildId: 5}); ok {
+		t.Error("expected an entitlement granted to a guild to be ignored")
+	}
+
+	// Test entitlements have no start or end
+	if got, ok := p.Subscriber(now, Entitlement{Id: 2, SkuId: premiumSku, UserId: 5}); !ok || !got.Active() || len(got.Tiers) != 1 {
+		t.Errorf("expected a test entitlement to be active, got %+v", got)
+	}
+
+	if got, ok := p.Subscriber(now, Entitlement{Id: 3, SkuId: premiumSku, UserId: 5, Deleted: true}); !ok || got.Status != providers.StatusFormer {
+		t.Errorf("expected a deleted entitlement to be former, got %+v", got)
+	}
+
+	if got, ok := p.Subscriber(now, Entitlement{Id: 4, SkuId: 999, UserId: 5}); !ok || !got.Active() || len(got.Tiers) != 0 {
+		t.Errorf("expected an unknown SKU to grant no tiers, got %+v", got)
+	}
+}
+
+func TestNewProviderRequiresBotToken(t *testing.T) {
+	var conf config.Config
+	conf.Discord.Applications = []config.Application{{ApplicationId: applicationId, PublicKey: "key"}}
+
+	if _, err := NewProvider(conf, zap.NewNop()); err == nil {
+		t.Error("expected an error without a bot token")
+	}
+}
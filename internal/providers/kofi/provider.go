@@ -0,0 +1,287 @@
+// Package kofi provides subscribers from donations and memberships paid through Ko-fi. Ko-fi sends a webhook for
+// each payment, but nothing when a membership is cancelled, so a membership is considered active until a month after
+// its last payment.
+package kofi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Name = "kofi"
+
+// Values of Payment.Type
+const (
+	TypeDonation     = "Donation"
+	TypeSubscription = "Subscription"
+	TypeCommission   = "Commission"
+	TypeShopOrder    = "Shop Order"
+)
+
+// renewalLeeway is how long after a membership is due to renew that it is still considered active, as Ko-fi may not
+// charge on exactly the same day each month.
+const renewalLeeway = time.Hour * 24 * 3
+
+// refreshInterval is how often the subscribers are sent again, so that memberships which were not renewed lapse.
+const refreshInterval = time.Hour
+
+// messageRetention is how long the IDs of received payments are kept to recognise Ko-fi retrying them, which it does
+// until it receives a 200 response.
+const messageRetention = time.Hour * 24 * 7
+
+const (
+	recordsDocument  = "kofi_subscribers"
+	messagesDocument = "kofi_messages"
+)
+
+var ErrInvalidToken = errors.New("invalid verification token")
+
+// Payment is the JSON payload Ko-fi sends in the data field of each webhook request.
+type Payment struct {
+	VerificationToken          string    `json:"verification_token"`
+	MessageId                  string    `json:"message_id"`
+	Timestamp                  time.Time `json:"timestamp"`
+	Type                       string    `json:"type"`
+	FromName                   string    `json:"from_name"`
+	Amount                     string    `json:"amount"` // A decimal, e.g. 3.00
+	Email                      string    `json:"email"`
+	Currency                   string    `json:"currency"`
+	IsSubscriptionPayment      bool      `json:"is_subscription_payment"`
+	IsFirstSubscriptionPayment bool      `json:"is_first_subscription_payment"`
+	TransactionId              string    `json:"kofi_transaction_id"`
+	TierName                   string    `json:"tier_name"`      // Empty for payments that are not for a membership tier
+	DiscordUserId              string    `json:"discord_userid"` // Empty if the supporter has not linked Discord
+}
+
+type Provider struct {
+	token   string
+	tiers   map[string]uint64
+	records *providers.Records
+	store   *store.Store
+	logger  *zap.Logger
+
+	mu       sync.Mutex           // Held while a payment is applied, so that payments for a supporter are compared in turn
+	messages map[string]time.Time // Message ID -> when it was received
+}
+
+var _ providers.Provider = (*Provider)(nil)
+
+func NewProvider(conf config.Config, store *store.Store, logger *zap.Logger) (*Provider, error) {
+	if conf.Kofi.VerificationToken == "" {
+		return nil, errors.New("a verification token is required to receive Ko-fi payments")
+	}
+
+	records, err := providers.LoadRecords(store, recordsDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		token:    conf.Kofi.VerificationToken,
+		tiers:    conf.Kofi.Tiers,
+		records:  records,
+		store:    store,
+		logger:   logger,
+		messages: make(map[string]time.Time),
+	}
+
+	if err := store.Load(messagesDocument, &p.messages); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// Fetch returns every supporter recorded from webhooks, with memberships that have not been renewed as former.
+func (p *Provider) Fetch(_ context.Context) ([]providers.Subscriber, error) {
+	now := time.Now()
+
+	records := p.records.All()
+	subscribers := make([]providers.Subscriber, len(records))
+	for i, record := range records {
+		subscribers[i] = current(now, record)
+	}
+
+	return subscribers, nil
+}
+
+// Watch sends a full update every hour until the context is cancelled, so that memberships lapse. Payments in between
+// are received as webhooks, which the server applies directly.
+func (p *Provider) Watch(ctx context.Context, updates chan<- providers.Update) {
+	for {
+		subscribers, _ := p.Fetch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case updates <- providers.Update{Provider: Name, Subscribers: subscribers, Full: true}:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+// HandleWebhook verifies and records the data field of a webhook request, returning the subscribers it changed.
+// Commissions and shop orders change nothing, and neither do payments that were already received or that are older
+// than the supporter's last payment. ErrInvalidToken is returned if the verification token does not match.
+func (p *Provider) HandleWebhook(now time.Time, data string) ([]providers.Subscriber, error) {
+	var payment Payment
+	if err := json.Unmarshal([]byte(data), &payment); err != nil {
+		return nil, errors.Wrap(err, "failed to decode payment")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(payment.VerificationToken), []byte(p.token)) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	if payment.Type != TypeDonation && payment.Type != TypeSubscription {
+		p.logger.Debug("Ignoring Ko-fi payment", zap.String("type", payment.Type), zap.String("message_id", payment.MessageId))
+		return nil, nil
+	}
+
+	if payment.Email == "" {
+		p.logger.Warn("Ignoring Ko-fi payment without an email address", zap.String("message_id", payment.MessageId))
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseFloat(payment.Amount, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid amount %q", payment.Amount)
+	}
+
+	timestamp := payment.Timestamp.UTC()
+	if timestamp.IsZero() {
+		timestamp = now.UTC()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.messages[payment.MessageId]; ok && payment.MessageId != "" {
+		p.logger.Debug("Ignoring repeated Ko-fi payment", zap.String("message_id", payment.MessageId))
+		return nil, nil
+	}
+
+	// An older payment would move the next charge date back, and could lapse a membership that has since been renewed
+	if existing, ok := p.records.Get(SupporterId(payment.Email)); ok && timestamp.Before(existing.LastChargeDate) {
+		p.logger.Debug("Ignoring out of order Ko-fi payment", zap.String("message_id", payment.MessageId))
+		return nil, p.recordMessage(now, payment.MessageId)
+	}
+
+	var tiers []uint64
+	if payment.IsSubscriptionPayment && payment.TierName != "" {
+		if tier, ok := p.tiers[payment.TierName]; ok {
+			tiers = []uint64{tier}
+		} else {
+			p.logger.Warn("unknown Ko-fi tier", zap.String("tier_name", payment.TierName))
+		}
+	}
+
+	record, err := p.records.Update(SupporterId(payment.Email), func(subscriber *providers.Subscriber) {
+		subscriber.Provider = Name
+		subscriber.Email = payment.Email
+		subscriber.LastChargeStatus = providers.ChargeStatusPaid
+		subscriber.LastChargeDate = timestamp
+
+		if discordId, err := strconv.ParseUint(payment.DiscordUserId, 10, 64); err == nil {
+			subscriber.DiscordId = &discordId
+		}
+
+		if !payment.IsSubscriptionPayment {
+			return
+		}
+
+		// A membership that lapsed and was taken out again starts over
+		if payment.IsFirstSubscriptionPayment || subscriber.StartedAt.IsZero() || !current(timestamp, *subscriber).Active() {
+			subscriber.StartedAt = timestamp
+		}
+
+		subscriber.NextChargeDate = timestamp.AddDate(0, 1, 0)
+		subscriber.AmountCents = int(math.Round(amount * 100))
+		subscriber.Currency = strings.ToUpper(payment.Currency)
+		subscriber.Tiers = tiers
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// If this fails, the payment is applied again when Ko-fi retries it, which is harmless
+	if err := p.recordMessage(now, payment.MessageId); err != nil {
+		return nil, err
+	}
+
+	p.logger.Info(
+		"Received Ko-fi payment",
+		zap.String("type", payment.Type),
+		zap.String("message_id", payment.MessageId),
+		zap.String("supporter_id", record.Id),
+	)
+
+	return []providers.Subscriber{current(now, record)}, nil
+}
+
+// recordMessage saves the ID of a received payment, forgetting those received longer ago than messageRetention. It
+// must be called with mu held.
+func (p *Provider) recordMessage(now time.Time, messageId string) error {
+	if messageId == "" {
+		return nil
+	}
+
+	// Write to a copy, so that a failed save leaves the messages as they were
+	messages := make(map[string]time.Time, len(p.messages)+1)
+	for id, receivedAt := range p.messages {
+		if now.Sub(receivedAt) < messageRetention {
+			messages[id] = receivedAt
+		}
+	}
+
+	messages[messageId] = now.UTC()
+	if err := p.store.Save(messagesDocument, messages); err != nil {
+		return err
+	}
+
+	p.messages = messages
+	return nil
+}
+
+// SupporterId returns the ID supporters are recorded under. Ko-fi has no stable ID for a supporter, so it is derived
+// from their email address; hashing it keeps the address out of keys, which are shown in places emails are masked.
+func SupporterId(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:8])
+}
+
+// current returns the record with its status as of now. Supporters are active while a membership payment is due to
+// renew, and otherwise former, including those who have only ever donated.
+func current(now time.Time, record providers.Subscriber) providers.Subscriber {
+	if !record.NextChargeDate.IsZero() && now.Before(record.NextChargeDate.Add(renewalLeeway)) {
+		record.Status = providers.StatusActive
+		return record
+	}
+
+	record.Status = providers.StatusFormer
+	record.NextChargeDate = time.Time{}
+	record.Tiers = nil
+	return record
+}
@@ -0,0 +1,172 @@
+package kofi
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+const (
+	testToken   = "token"
+	supportTier = 2000
+)
+
+func newTestProvider(t *testing.T, s *store.Store) *Provider {
+	t.Helper()
+
+	var conf config.Config
+	conf.Kofi.VerificationToken = testToken
+	conf.Kofi.Tiers = map[string]uint64{"Supporter": supportTier}
+
+	p, err := NewProvider(conf, s, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func payment(t *testing.T, p Payment) string {
+	t.Helper()
+
+	if p.VerificationToken == "" {
+		p.VerificationToken = testToken
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestHandleWebhook(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, s)
+	paid := time.Now().UTC().Truncate(time.Second)
+
+	if _, err := p.HandleWebhook(paid, payment(t, Payment{VerificationToken: "wrong", Type: TypeDonation, Email: "a@example.com", Amount: "3.00"})); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an invalid token, got %v", err)
+	}
+
+	// Donations are recorded, but grant nothing
+	subscribers, err := p.HandleWebhook(paid, payment(t, Payment{Type: TypeDonation, Timestamp: paid, Email: "a@example.com", Amount: "3.00"}))
+	if err != nil || len(subscribers) != 1 {
+		t.Fatalf("unexpected subscribers %+v, %v", subscribers, err)
+	}
+
+	if got := subscribers[0]; got.Key() != "kofi:"+SupporterId("a@example.com") || got.Status != providers.StatusFormer || got.LastChargeStatus != providers.ChargeStatusPaid || len(got.Tiers) != 0 {
+		t.Errorf("unexpected donor %+v", got)
+	}
+
+	// The same supporter is found regardless of the case of their email address
+	subscribers, err = p.HandleWebhook(paid, payment(t, Payment{
+		Type:                       TypeSubscription,
+		Timestamp:                  paid,
+		Email:                      "A@example.com",
+		Amount:                     "5.00",
+		IsSubscriptionPayment:      true,
+		IsFirstSubscriptionPayment: true,
+		TierName:                   "Supporter",
+		DiscordUserId:              "42",
+	}))
+	if err != nil || len(subscribers) != 1 {
+		t.Fatalf("unexpected subscribers %+v, %v", subscribers, err)
+	}
+
+	got := subscribers[0]
+	if got.Id != SupporterId("a@example.com") || !got.StartedAt.Equal(paid) || got.AmountCents != 500 || got.DiscordId == nil || *got.DiscordId != 42 {
+		t.Errorf("unexpected member %+v", got)
+	}
+
+	if current := current(paid, got); !current.Active() || len(current.Tiers) != 1 || current.Tiers[0] != supportTier {
+		t.Errorf("expected an active member, got %+v", current)
+	}
+
+	// Memberships stay active for a few days after they are due to renew, and then lapse
+	if !current(paid.AddDate(0, 1, 1), got).Active() {
+		t.Error("expected the membership to be active shortly after it was due to renew")
+	}
+
+	if lapsed := current(paid.AddDate(0, 2, 0), got); lapsed.Status != providers.StatusFormer || len(lapsed.Tiers) != 0 || !lapsed.NextChargeDate.IsZero() {
+		t.Errorf("expected the membership to have lapsed, got %+v", lapsed)
+	}
+
+	// Shop orders are ignored
+	if subscribers, err := p.HandleWebhook(paid, payment(t, Payment{Type: TypeShopOrder, Email: "b@example.com", Amount: "10.00"})); err != nil || len(subscribers) != 0 {
+		t.Errorf("expected the shop order to be ignored, got %+v, %v", subscribers, err)
+	}
+
+	// Supporters are persisted, as they cannot be fetched again
+	all, err := newTestProvider(t, s).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 1 || all[0].Email != "A@example.com" || !all[0].Active() {
+		t.Errorf("unexpected persisted supporters %+v", all)
+	}
+}
+
+func TestHandleWebhookOutOfOrder(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, s)
+	renewed := time.Now().UTC().Truncate(time.Second)
+	previous := renewed.AddDate(0, -1, 0)
+
+	membership := func(messageId string, timestamp time.Time) string {
+		return payment(t, Payment{
+			MessageId:             messageId,
+			Type:                  TypeSubscription,
+			Timestamp:             timestamp,
+			Email:                 "a@example.com",
+			Amount:                "5.00",
+			IsSubscriptionPayment: true,
+			TierName:              "Supporter",
+		})
+	}
+
+	if _, err := p.HandleWebhook(renewed, membership("renewed", renewed)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A retry of the previous month's payment arriving late must not move the next charge date back
+	if subscribers, err := p.HandleWebhook(renewed, membership("previous", previous)); err != nil || len(subscribers) != 0 {
+		t.Errorf("expected the older payment to be ignored, got %+v, %v", subscribers, err)
+	}
+
+	if got, _ := p.records.Get(SupporterId("a@example.com")); !got.LastChargeDate.Equal(renewed) || !got.NextChargeDate.Equal(renewed.AddDate(0, 1, 0)) {
+		t.Errorf("expected the renewal to be kept, got %+v", got)
+	}
+
+	// Payments that were already received are ignored, even after a restart
+	p = newTestProvider(t, s)
+	if subscribers, err := p.HandleWebhook(renewed, membership("renewed", renewed)); err != nil || len(subscribers) != 0 {
+		t.Errorf("expected the repeated payment to be ignored, got %+v, %v", subscribers, err)
+	}
+
+	// Message IDs are forgotten after a while
+	later := renewed.Add(messageRetention)
+	if _, err := p.HandleWebhook(later, membership("later", later)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := p.messages["renewed"]; ok {
+		t.Error("expected the old message ID to have been forgotten")
+	}
+}
@@ -0,0 +1,170 @@
+// Package patreon provides subscribers from a Patreon campaign, using the client in pkg/patreon.
+package patreon
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const Name = "patreon"
+
+const (
+	fetchInterval         = time.Minute
+	credentialRetryDelay  = time.Second * 10
+	tokenRefreshThreshold = time.Hour * 24 * 3
+)
+
+type Provider struct {
+	client *patreon.Client
+	logger *zap.Logger
+}
+
+var _ providers.Provider = (*Provider)(nil)
+
+func NewProvider(client *patreon.Client, logger *zap.Logger) *Provider {
+	return &Provider{
+		client: client,
+		logger: logger,
+	}
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// Fetch returns every member of the campaign, refreshing the tokens first if they are close to expiring.
+func (p *Provider) Fetch(ctx context.Context) ([]providers.Subscriber, error) {
+	if p.client.Tokens.ExpiresAt.Before(time.Now()) {
+		return nil, errors.Errorf("refresh token has already expired (expired at %s)", p.client.Tokens.ExpiresAt)
+	}
+
+	if time.Until(p.client.Tokens.ExpiresAt) < tokenRefreshThreshold {
+		p.logger.Info(
+			"Token expires in less than 3 days, refreshing",
+			zap.Time("expires_at", p.client.Tokens.ExpiresAt),
+		)
+
+		refreshCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+		tokens, err := p.client.DoRefresh(refreshCtx)
+		cancel()
+
+		if err != nil { // We can still continue if this fails
+			p.logger.Error("Failed to refresh token", zap.Error(err))
+		} else {
+			p.logger.Info("Tokens refreshed successfully", zap.Time("expires_at", tokens.ExpiresAt))
+		}
+	}
+
+	pledges, err := p.client.FetchPledges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := make([]providers.Subscriber, 0, len(pledges))
+	for _, patron := range pledges {
+		subscribers = append(subscribers, Subscriber(patron))
+	}
+
+	return subscribers, nil
+}
+
+// Watch obtains credentials, and then sends a full update every minute until the context is cancelled.
+func (p *Provider) Watch(ctx context.Context, updates chan<- providers.Update) {
+	if !p.grantCredentials(ctx) {
+		return
+	}
+
+	for {
+		p.fetch(ctx, updates)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(fetchInterval):
+		}
+	}
+}
+
+// grantCredentials retries until credentials are granted, returning false if the context is cancelled first.
+func (p *Provider) grantCredentials(ctx context.Context) bool {
+	for {
+		grantCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		_, err := p.client.GrantCredentials(grantCtx)
+		cancel()
+
+		if err == nil {
+			p.logger.Info("Granted credentials successfully")
+			return true
+		}
+
+		p.logger.Error("Failed to grant credentials, retrying in 10s", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			p.logger.Info("Received shutdown signal before credentials were granted, exiting")
+			return false
+		case <-time.After(credentialRetryDelay):
+		}
+	}
+}
+
+func (p *Provider) fetch(ctx context.Context, updates chan<- providers.Update) {
+	// The tokens cannot be refreshed once they have expired, so new credentials are granted instead
+	if p.client.Tokens.ExpiresAt.Before(time.Now()) {
+		p.logger.Warn("Refresh token has already expired, granting new credentials", zap.Time("expires_at", p.client.Tokens.ExpiresAt))
+		if !p.grantCredentials(ctx) {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	subscribers, err := p.Fetch(ctx)
+	if err != nil {
+		p.logger.Error("Failed to fetch pledges", zap.Error(err))
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case updates <- providers.Update{Provider: Name, Subscribers: subscribers, Full: true}:
+	}
+}
+
+// Subscriber converts a patron to the provider-neutral model.
+func Subscriber(patron patreon.Patron) providers.Subscriber {
+	return providers.Subscriber{
+		Provider:         Name,
+		Id:               strconv.FormatUint(patron.Id, 10),
+		Email:            patron.Email,
+		DiscordId:        patron.DiscordId,
+		Status:           status(patron.PatronStatus),
+		LastChargeStatus: patron.LastChargeStatus,
+		LastChargeDate:   patron.LastChargeDate,
+		StartedAt:        patron.PledgeRelationshipStart,
+		NextChargeDate:   patron.NextChargeDate,
+		AmountCents:      patron.CurrentlyEntitledAmountCents,
+		Interval:         providers.IntervalMonth,
+		IntervalCount:    patron.PledgeCadence,
+		Tiers:            patron.Tiers,
+	}
+}
+
+func status(patronStatus string) string {
+	switch patronStatus {
+	case patreon.StatusActive:
+		return providers.StatusActive
+	case patreon.StatusDeclined:
+		return providers.StatusDeclined
+	case patreon.StatusFormer:
+		return providers.StatusFormer
+	default:
+		return ""
+	}
+}
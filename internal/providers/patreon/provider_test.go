@@ -0,0 +1,88 @@
+package patreon
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon/patreontest"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestSubscriber(t *testing.T) {
+	discordId := uint64(12345)
+	started := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got := Subscriber(patreon.Patron{
+		Attributes: patreon.Attributes{
+			Email:                        "patron@example.com",
+			PatronStatus:                 patreon.StatusDeclined,
+			LastChargeStatus:             patreon.ChargeStatusDeclined,
+			PledgeRelationshipStart:      started,
+			CurrentlyEntitledAmountCents: 500,
+		},
+		Id:        42,
+		Tiers:     []uint64{1},
+		DiscordId: &discordId,
+	})
+
+	if got.Key() != "patreon:42" || got.Email != "patron@example.com" || got.DiscordId == nil || *got.DiscordId != discordId {
+		t.Errorf("unexpected identity %+v", got)
+	}
+
+	if got.Status != providers.StatusDeclined || got.LastChargeStatus != providers.ChargeStatusDeclined {
+		t.Errorf("unexpected statuses %q and %q", got.Status, got.LastChargeStatus)
+	}
+
+	if !got.StartedAt.Equal(started) || got.AmountCents != 500 || len(got.Tiers) != 1 {
+		t.Errorf("unexpected subscription %+v", got)
+	}
+
+	// Templates written for the Patreon model keep working
+	if got.PatronStatus() != patreon.StatusDeclined || !got.PledgeRelationshipStart().Equal(started) {
+		t.Errorf("unexpected compatibility values %q and %s", got.PatronStatus(), got.PledgeRelationshipStart())
+	}
+
+	if never := Subscriber(patreon.Patron{Id: 1}); never.Status != "" || never.PatronStatus() != "" {
+		t.Errorf("expected no status for a patron who has never paid, got %q", never.Status)
+	}
+}
+
+func TestFetchWithExpiredTokens(t *testing.T) {
+	server := patreontest.NewServer(1234)
+	defer server.Close()
+
+	server.AddMembers(patreontest.Member{UserId: 1, Email: "patron@example.com", PatronStatus: patreon.StatusActive})
+
+	var conf config.Config
+	conf.Patreon.ClientId = patreontest.ClientId
+	conf.Patreon.ClientSecret = patreontest.ClientSecret
+	conf.Patreon.CampaignId = 1234
+	conf.Patreon.RequestsPerMinute = 6000
+
+	client := patreon.NewClient(conf, zap.NewNop(), patreon.WithBaseUrl(server.URL), patreon.WithHttpClient(server.Client()))
+	if _, err := client.GrantCredentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Tokens.ExpiresAt = time.Now().Add(-time.Hour)
+
+	// New credentials are granted, rather than serving the last subscribers fetched indefinitely
+	updates := make(chan providers.Update, 1)
+	NewProvider(client, zap.NewNop()).fetch(context.Background(), updates)
+
+	if got := server.RequestCount(patreontest.EndpointToken); got != 2 {
+		t.Errorf("expected credentials to be granted again, got %d token requests", got)
+	}
+
+	select {
+	case update := <-updates:
+		if len(update.Subscribers) != 1 || update.Subscribers[0].Email != "patron@example.com" {
+			t.Errorf("unexpected update %+v", update)
+		}
+	default:
+		t.Error("expected an update")
+	}
+}
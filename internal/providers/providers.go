@@ -0,0 +1,151 @@
+// Package providers defines the provider-neutral model of a subscriber, and the interface implemented by each source
+// of subscriptions, such as Patreon. Everything downstream of a provider, from lookups to entitlements, works on
+// Subscribers rather than on any one provider's types.
+package providers
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of Subscriber.Status
+const (
+	StatusActive   = "active"
+	StatusDeclined = "declined"
+	StatusFormer   = "former"
+)
+
+// Common values of Subscriber.LastChargeStatus
+const (
+	ChargeStatusPaid     = "Paid"
+	ChargeStatusDeclined = "Declined"
+)
+
+// Values of Subscriber.Interval, which are the same as Stripe's
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// legacyProvider is the provider that subscribers were recorded from before there was more than one
+const legacyProvider = "patreon"
+
+type Subscriber struct {
+	Provider         string    `json:"provider"`                     // The name of the provider the subscription is with
+	Id               string    `json:"id"`                           // The subscriber's ID with the provider
+	Email            string    `json:"email,omitempty"`              // May be empty if the provider does not share it
+	DiscordId        *uint64   `json:"discord_id,omitempty"`         // nil if the subscriber has not linked a Discord account
+	Status           string    `json:"status,omitempty"`             // One of the Status* constants, or empty if the subscriber has never paid
+	LastChargeStatus string    `json:"last_charge_status,omitempty"` // e.g. ChargeStatusPaid, or empty if the subscriber has never been charged
+	LastChargeDate   time.Time `json:"last_charge_date"`
+	StartedAt        time.Time `json:"started_at"`
+	NextChargeDate   time.Time `json:"next_charge_date"`
+	AmountCents      int       `json:"amount_cents"`             // The amount the subscriber pays per billing period, in Currency
+	Currency         string    `json:"currency,omitempty"`       // ISO 4217 code, e.g. USD, or empty if the provider does not say
+	Interval         string    `json:"interval,omitempty"`       // One of the Interval* constants, or empty if monthly
+	IntervalCount    int       `json:"interval_count,omitempty"` // The number of intervals in each billing period, or 0 for 1
+	Tiers            []uint64  `json:"tiers,omitempty"`          // The configured tiers the subscriber is currently entitled to
+}
+
+// Key identifies the subscriber across all providers.
+func (s Subscriber) Key() string {
+	return s.Provider + ":" + s.Id
+}
+
+func (s Subscriber) Active() bool {
+	return s.Status == StatusActive
+}
+
+// PatronId returns the subscriber's numeric Patreon ID, or 0 if they are subscribed with another provider. The API and
+// exports report it as the patron ID, as they did before there was more than one provider.
+func (s Subscriber) PatronId() uint64 {
+	if s.Provider != legacyProvider {
+		return 0
+	}
+
+	id, _ := strconv.ParseUint(s.Id, 10, 64)
+	return id
+}
+
+// PatronStatus returns the status in the form Patreon uses, e.g. active_patron. The API, exports and embed templates
+// report statuses in this form, as they did before there was more than one provider.
+func (s Subscriber) PatronStatus() string {
+	if s.Status == "" {
+		return ""
+	}
+
+	return s.Status + "_patron"
+}
+
+// PledgeRelationshipStart returns StartedAt.
+//
+// Deprecated: Use StartedAt. This is kept for embed templates written before there was more than one provider.
+func (s Subscriber) PledgeRelationshipStart() time.Time {
+	return s.StartedAt
+}
+
+// AmountCentsIn returns AmountCents if the subscriber pays in the currency, or otherwise 0, as amounts in different
+// currencies cannot be added together. Subscribers without a Currency are assumed to pay in the currency.
+func (s Subscriber) AmountCentsIn(currency string) int {
+	if s.Currency != "" && !strings.EqualFold(s.Currency, currency) {
+		return 0
+	}
+
+	return s.AmountCents
+}
+
+// MonthlyAmountCentsIn returns AmountCentsIn(currency) spread evenly over each month of the billing period, so that
+// subscribers billed annually (or weekly) can be added to those billed monthly.
+func (s Subscriber) MonthlyAmountCentsIn(currency string) int {
+	amount := float64(s.AmountCentsIn(currency))
+
+	count := s.IntervalCount
+	if count <= 0 {
+		count = 1
+	}
+
+	switch s.Interval {
+	case IntervalYear:
+		amount /= 12
+	case IntervalWeek:
+		amount *= 52.0 / 12
+	case IntervalDay:
+		amount *= 365.0 / 12
+	}
+
+	return int(math.Round(amount / float64(count)))
+}
+
+// MigrateKey converts a key recorded before there was more than one provider, which was the bare Patreon ID, to the
+// form returned by Subscriber.Key. Other keys are returned unchanged.
+func MigrateKey(key string) string {
+	if strings.Contains(key, ":") {
+		return key
+	}
+
+	return legacyProvider + ":" + key
+}
+
+// Update is a set of subscribers sent by a provider.
+type Update struct {
+	Provider    string
+	Subscribers []Subscriber
+	// If set, Subscribers is every subscriber the provider has, and replaces those from previous updates. Otherwise,
+	// each subscriber is added, or replaces the subscriber with the same ID.
+	Full bool
+}
+
+type Provider interface {
+	// Name identifies the provider, and is used as Subscriber.Provider
+	Name() string
+	// Fetch returns every subscriber the provider currently has
+	Fetch(ctx context.Context) ([]Subscriber, error)
+	// Watch sends updates whenever the provider's subscribers may have changed, and blocks until the context is
+	// cancelled
+	Watch(ctx context.Context, updates chan<- Update)
+}
@@ -0,0 +1,86 @@
+package providers
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"sort"
+	"sync"
+)
+
+// Records persists the subscribers of a provider that only learns of changes through webhooks, and so has no API to
+// fetch its subscribers from again after a restart.
+type Records struct {
+	store    *store.Store
+	document string
+
+	mu          sync.Mutex
+	subscribers map[string]Subscriber // ID -> Subscriber
+}
+
+// LoadRecords loads the subscribers from the named document of the store.
+func LoadRecords(store *store.Store, document string) (*Records, error) {
+	r := &Records{
+		store:       store,
+		document:    document,
+		subscribers: make(map[string]Subscriber),
+	}
+
+	if err := store.Load(document, &r.subscribers); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Update calls f with the subscriber with the given ID, or a zero Subscriber with only the ID set if there is none
+// yet, and saves the result.
+func (r *Records) Update(id string, f func(subscriber *Subscriber)) (Subscriber, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscriber, ok := r.subscribers[id]
+	if !ok {
+		subscriber = Subscriber{Id: id}
+	}
+
+	f(&subscriber)
+
+	// Write to a copy, so that a failed save leaves the records as they were
+	subscribers := make(map[string]Subscriber, len(r.subscribers)+1)
+	for existingId, existing := range r.subscribers {
+		subscribers[existingId] = existing
+	}
+
+	subscribers[id] = subscriber
+	if err := r.store.Save(r.document, subscribers); err != nil {
+		return Subscriber{}, err
+	}
+
+	r.subscribers = subscribers
+	return subscriber, nil
+}
+
+// Get returns the subscriber with the given ID.
+func (r *Records) Get(id string) (Subscriber, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscriber, ok := r.subscribers[id]
+	return subscriber, ok
+}
+
+// All returns every subscriber, ordered by ID.
+func (r *Records) All() []Subscriber {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscribers := make([]Subscriber, 0, len(r.subscribers))
+	for _, subscriber := range r.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].Id < subscribers[j].Id
+	})
+
+	return subscribers
+}
@@ -0,0 +1,72 @@
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultBaseUrl = "https://api.stripe.com"
+
+type Customer struct {
+	Id       string            `json:"id"`
+	Email    string            `json:"email"`
+	Metadata map[string]string `json:"metadata"`
+	Deleted  bool              `json:"deleted"`
+}
+
+// Client calls the Stripe API with a secret or restricted API key. Only read access to customers is needed.
+type Client struct {
+	httpClient *http.Client
+	baseUrl    string
+	apiKey     string
+}
+
+func NewClient(httpClient *http.Client, baseUrl, apiKey string) *Client {
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	return &Client{
+		httpClient: httpClient,
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		apiKey:     apiKey,
+	}
+}
+
+func (c *Client) GetCustomer(ctx context.Context, id string) (Customer, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseUrl+"/v1/customers/"+url.PathEscape(id), nil)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Customer{}, errors.Wrap(err, "failed to get customer")
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Customer{}, errors.Wrap(err, "failed to read customer")
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return Customer{}, fmt.Errorf("failed to get customer: status %d: %s", res.StatusCode, string(body))
+	}
+
+	var customer Customer
+	if err := json.Unmarshal(body, &customer); err != nil {
+		return Customer{}, errors.Wrap(err, "failed to decode customer")
+	}
+
+	return customer, nil
+}
@@ -0,0 +1,286 @@
+// Package stripe provides subscribers from subscriptions paid directly through Stripe. Stripe is not polled: each
+// subscription is recorded as its webhook events are received, and the records are persisted so that they survive
+// restarts.
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Name = "stripe"
+
+// DiscordIdMetadataKey is the metadata key, on either the subscription or the customer, holding the Discord ID of
+// the subscriber.
+const DiscordIdMetadataKey = "discord_id"
+
+const (
+	recordsDocument = "stripe_subscribers"
+	eventsDocument  = "stripe_events"
+)
+
+// eventTimes are the creation times of the latest events applied to a subscription, as Unix timestamps. Stripe does
+// not deliver events in order, so older events than these are ignored rather than overwriting newer state.
+// Subscription and invoice events change different fields, so each is ordered separately.
+type eventTimes struct {
+	Subscription int64 `json:"subscription,omitempty"`
+	Invoice      int64 `json:"invoice,omitempty"`
+}
+
+type Provider struct {
+	secret  string
+	prices  map[string]uint64
+	client  *Client // nil if no API key is configured
+	records *providers.Records
+	store   *store.Store
+	logger  *zap.Logger
+
+	mu     sync.Mutex // Held while an event is applied, so that events for a subscription are compared in turn
+	events map[string]eventTimes
+}
+
+var _ providers.Provider = (*Provider)(nil)
+
+func NewProvider(conf config.Config, store *store.Store, logger *zap.Logger) (*Provider, error) {
+	if conf.Stripe.WebhookSecret == "" {
+		return nil, errors.New("a webhook secret is required to receive Stripe subscriptions")
+	}
+
+	records, err := providers.LoadRecords(store, recordsDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		secret:  conf.Stripe.WebhookSecret,
+		prices:  conf.Stripe.Prices,
+		records: records,
+		store:   store,
+		logger:  logger,
+		events:  make(map[string]eventTimes),
+	}
+
+	if err := store.Load(eventsDocument, &p.events); err != nil {
+		return nil, err
+	}
+
+	if conf.Stripe.ApiKey != "" {
+		p.client = NewClient(http.DefaultClient, conf.Stripe.BaseUrl, conf.Stripe.ApiKey)
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// Fetch returns every subscription recorded from webhook events.
+func (p *Provider) Fetch(_ context.Context) ([]providers.Subscriber, error) {
+	return p.records.All(), nil
+}
+
+// Watch sends the recorded subscriptions as a full update, and then blocks until the context is cancelled. Changes
+// are received as webhook events, which the server applies directly.
+func (p *Provider) Watch(ctx context.Context, updates chan<- providers.Update) {
+	subscribers, _ := p.Fetch(ctx)
+
+	select {
+	case <-ctx.Done():
+		return
+	case updates <- providers.Update{Provider: Name, Subscribers: subscribers, Full: true}:
+	}
+
+	<-ctx.Done()
+}
+
+// HandleWebhook verifies and records a webhook event, returning the subscribers it changed. Events other than
+// subscription and invoice events change nothing. ErrInvalidSignature is returned if the signature does not match.
+func (p *Provider) HandleWebhook(ctx context.Context, now time.Time, payload []byte, signature string) ([]providers.Subscriber, error) {
+	if err := VerifySignature(payload, signature, p.secret, now); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, errors.Wrap(err, "failed to decode event")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var subscriber providers.Subscriber
+	var err error
+	switch event.Type {
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionDeleted:
+		var subscription Subscription
+		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return nil, errors.Wrap(err, "failed to decode subscription")
+		}
+
+		times := p.events[subscription.Id]
+		if event.Created < times.Subscription {
+			p.logger.Debug("Ignoring out of order Stripe event", zap.String("type", event.Type), zap.String("event_id", event.Id))
+			return nil, nil
+		}
+
+		subscriber, err = p.recordSubscription(ctx, subscription)
+		times.Subscription = event.Created
+		p.events[subscription.Id] = times
+	case EventInvoicePaid, EventInvoicePaymentFailed:
+		var invoice Invoice
+		if err := json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return nil, errors.Wrap(err, "failed to decode invoice")
+		}
+
+		// One-off invoices have nothing to do with a subscription
+		if invoice.SubscriptionId() == "" {
+			return nil, nil
+		}
+
+		times := p.events[invoice.SubscriptionId()]
+		if event.Created < times.Invoice {
+			p.logger.Debug("Ignoring out of order Stripe event", zap.String("type", event.Type), zap.String("event_id", event.Id))
+			return nil, nil
+		}
+
+		subscriber, err = p.recordInvoice(invoice, event.Type == EventInvoicePaid, time.Unix(event.Created, 0))
+		times.Invoice = event.Created
+		p.events[invoice.SubscriptionId()] = times
+	default:
+		p.logger.Debug("Ignoring Stripe event", zap.String("type", event.Type))
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// If this fails, the event is applied again when Stripe retries it, which is harmless
+	if err := p.store.Save(eventsDocument, p.events); err != nil {
+		return nil, err
+	}
+
+	p.logger.Info(
+		"Received Stripe event",
+		zap.String("type", event.Type),
+		zap.String("event_id", event.Id),
+		zap.String("subscription_id", subscriber.Id),
+		zap.String("status", subscriber.Status),
+	)
+
+	return []providers.Subscriber{subscriber}, nil
+}
+
+func (p *Provider) recordSubscription(ctx context.Context, subscription Subscription) (providers.Subscriber, error) {
+	var customer Customer
+	if existing, ok := p.records.Get(subscription.Id); (!ok || existing.Email == "") && p.client != nil && subscription.Customer != "" {
+		var err error
+		customer, err = p.client.GetCustomer(ctx, subscription.Customer)
+		if err != nil {
+			// The email address may still be learnt from an invoice, so this is not worth Stripe retrying the event
+			p.logger.Warn("Failed to look up Stripe customer", zap.String("customer_id", subscription.Customer), zap.Error(err))
+		}
+	}
+
+	status := subscriptionStatus(subscription.Status)
+
+	var tiers []uint64
+	var amount int
+	var interval string
+	var intervalCount int
+	for _, item := range subscription.Items.Data {
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+
+		amount += item.Price.UnitAmount * quantity
+
+		// Stripe requires every item in a subscription to be billed at the same interval
+		interval, intervalCount = item.Price.Recurring.Interval, item.Price.Recurring.IntervalCount
+
+		if status != providers.StatusActive {
+			continue
+		}
+
+		if tier, ok := p.prices[item.Price.Id]; ok {
+			tiers = append(tiers, tier)
+		} else {
+			p.logger.Warn("unknown Stripe price", zap.String("price_id", item.Price.Id), zap.String("subscription_id", subscription.Id))
+		}
+	}
+
+	return p.records.Update(subscription.Id, func(subscriber *providers.Subscriber) {
+		subscriber.Provider = Name
+		subscriber.Status = status
+		subscriber.AmountCents = amount
+		subscriber.Currency = strings.ToUpper(subscription.Currency)
+		subscriber.Interval = interval
+		subscriber.IntervalCount = intervalCount
+		subscriber.Tiers = tiers
+
+		if subscription.StartDate != 0 {
+			subscriber.StartedAt = time.Unix(subscription.StartDate, 0).UTC()
+		}
+
+		// Subscriptions that have ended will not be charged again
+		subscriber.NextChargeDate = time.Time{}
+		if periodEnd := subscription.PeriodEnd(); periodEnd != 0 && (status == providers.StatusActive || status == providers.StatusDeclined) {
+			subscriber.NextChargeDate = time.Unix(periodEnd, 0).UTC()
+		}
+
+		if customer.Email != "" && !customer.Deleted {
+			subscriber.Email = customer.Email
+		}
+
+		// Metadata on the subscription takes precedence over the customer's
+		for _, metadata := range []map[string]string{customer.Metadata, subscription.Metadata} {
+			if discordId, err := strconv.ParseUint(metadata[DiscordIdMetadataKey], 10, 64); err == nil {
+				subscriber.DiscordId = &discordId
+			}
+		}
+	})
+}
+
+func (p *Provider) recordInvoice(invoice Invoice, paid bool, created time.Time) (providers.Subscriber, error) {
+	return p.records.Update(invoice.SubscriptionId(), func(subscriber *providers.Subscriber) {
+		subscriber.Provider = Name
+		subscriber.LastChargeDate = created.UTC()
+
+		if paid {
+			subscriber.LastChargeStatus = providers.ChargeStatusPaid
+		} else {
+			subscriber.LastChargeStatus = providers.ChargeStatusDeclined
+		}
+
+		if subscriber.Email == "" {
+			subscriber.Email = invoice.CustomerEmail
+		}
+	})
+}
+
+// subscriptionStatus maps the status of a Stripe subscription to a Status* constant.
+func subscriptionStatus(status string) string {
+	switch status {
+	case "active", "trialing":
+		return providers.StatusActive
+	case "past_due", "unpaid":
+		return providers.StatusDeclined
+	case "incomplete":
+		// The first payment has not been made yet
+		return ""
+	default: // canceled, incomplete_expired, paused
+		return providers.StatusFormer
+	}
+}
@@ -0,0 +1,266 @@
+package stripe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret  = "whsec_test"
+	premiumTier = 1000
+)
+
+func signature(payload []byte, secret string, timestamp time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s", timestamp.Unix(), payload)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sign(payload []byte, secret string, timestamp time.Time) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), signature(payload, secret, timestamp))
+}
+
+func event(t *testing.T, eventType string, object map[string]any) []byte {
+	t.Helper()
+	return eventAt(t, eventType, object, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func eventAt(t *testing.T, eventType string, object map[string]any, created time.Time) []byte {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]any{
+		"id":      "evt_" + eventType,
+		"type":    eventType,
+		"created": created.Unix(),
+		"data":    map[string]any{"object": object},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
+func subscription(status string, metadata map[string]string) map[string]any {
+	return map[string]any{
+		"id":                 "sub_1",
+		"customer":           "cus_1",
+		"status":             status,
+		"currency":           "usd",
+		"start_date":         time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		"current_period_end": time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		"metadata":           metadata,
+		"items": map[string]any{
+			"data": []map[string]any{{"quantity": 1, "price": map[string]any{"id": "price_premium", "unit_amount": 500}}},
+		},
+	}
+}
+
+func newTestProvider(t *testing.T, s *store.Store, baseUrl string) *Provider {
+	t.Helper()
+
+	var conf config.Config
+	conf.Stripe.WebhookSecret = testSecret
+	conf.Stripe.Prices = map[string]uint64{"price_premium": premiumTier}
+	if baseUrl != "" {
+		conf.Stripe.ApiKey = "sk_test"
+		conf.Stripe.BaseUrl = baseUrl
+	}
+
+	p, err := NewProvider(conf, s, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+
+	if err := VerifySignature(payload, sign(payload, testSecret, now), testSecret, now); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+
+	// While the secret is being rolled, either signature is accepted
+	rolled := sign(payload, "whsec_old", now) + ",v1=" + signature(payload, testSecret, now)
+	if err := VerifySignature(payload, rolled, testSecret, now); err != nil {
+		t.Errorf("expected a rolled signature to be valid, got %v", err)
+	}
+
+	for name, header := range map[string]string{
+		"wrong secret": sign(payload, "whsec_other", now),
+		"stale":        sign(payload, testSecret, now.Add(-SignatureTolerance-time.Minute)),
+		"missing":      "",
+	} {
+		if err := VerifySignature(payload, header, testSecret, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected an invalid signature, got %v", name, err)
+		}
+	}
+
+	if err := VerifySignature([]byte(`{"id":"evt_2"}`), sign(payload, testSecret, now), testSecret, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a modified payload to be rejected, got %v", err)
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/customers/cus_1" || r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(Customer{Id: "cus_1", Email: "customer@example.com", Metadata: map[string]string{DiscordIdMetadataKey: "42"}})
+	}))
+	t.Cleanup(api.Close)
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, s, api.URL)
+	now := time.Now()
+
+	handle := func(payload []byte) providers.Subscriber {
+		t.Helper()
+
+		subscribers, err := p.HandleWebhook(context.Background(), now, payload, sign(payload, testSecret, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(subscribers) != 1 {
+			t.Fatalf("expected 1 subscriber, got %+v", subscribers)
+		}
+
+		return subscribers[0]
+	}
+
+	// The customer is looked up for their email address and Discord ID
+	got := handle(event(t, EventSubscriptionCreated, subscription("active", nil)))
+	if got.Key() != "stripe:sub_1" || got.Email != "customer@example.com" || got.DiscordId == nil || *got.DiscordId != 42 {
+		t.Errorf("unexpected subscriber %+v", got)
+	}
+
+	if !got.Active() || len(got.Tiers) != 1 || got.Tiers[0] != premiumTier || got.AmountCents != 500 || got.Currency != "USD" || got.NextChargeDate.IsZero() {
+		t.Errorf("unexpected subscription %+v", got)
+	}
+
+	got = handle(event(t, EventInvoicePaymentFailed, map[string]any{"id": "in_1", "customer": "cus_1", "subscription": "sub_1"}))
+	if got.LastChargeStatus != providers.ChargeStatusDeclined || got.Email != "customer@example.com" {
+		t.Errorf("expected the failed payment to be recorded, got %+v", got)
+	}
+
+	// Metadata on the subscription overrides the customer's
+	got = handle(event(t, EventSubscriptionUpdated, subscription("past_due", map[string]string{DiscordIdMetadataKey: "43"})))
+	if got.Status != providers.StatusDeclined || len(got.Tiers) != 0 || *got.DiscordId != 43 || got.LastChargeStatus != providers.ChargeStatusDeclined {
+		t.Errorf("expected a declined subscriber, got %+v", got)
+	}
+
+	got = handle(event(t, EventSubscriptionDeleted, subscription("canceled", nil)))
+	if got.Status != providers.StatusFormer || !got.NextChargeDate.IsZero() {
+		t.Errorf("expected a former subscriber, got %+v", got)
+	}
+
+	// Other events change nothing
+	payload := event(t, "customer.created", map[string]any{"id": "cus_2"})
+	if subscribers, err := p.HandleWebhook(context.Background(), now, payload, sign(payload, testSecret, now)); err != nil || len(subscribers) != 0 {
+		t.Errorf("expected the event to be ignored, got %+v, %v", subscribers, err)
+	}
+
+	// Subscriptions are persisted, as they cannot be fetched again
+	subscribers, err := newTestProvider(t, s, "").Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subscribers) != 1 || subscribers[0].Key() != "stripe:sub_1" || subscribers[0].Status != providers.StatusFormer {
+		t.Errorf("unexpected persisted subscribers %+v", subscribers)
+	}
+}
+
+func TestInvoiceWithoutSubscription(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, s, "")
+	now := time.Now()
+
+	payload := event(t, EventInvoicePaymentFailed, map[string]any{"id": "in_1", "customer": "cus_1", "customer_email": "one-off@example.com"})
+	if subscribers, err := p.HandleWebhook(context.Background(), now, payload, sign(payload, testSecret, now)); err != nil || len(subscribers) != 0 {
+		t.Errorf("expected a one-off invoice to be ignored, got %+v, %v", subscribers, err)
+	}
+
+	// Newer API versions refer to the subscription through the invoice's parent
+	payload = event(t, EventInvoicePaid, map[string]any{
+		"id":             "in_2",
+		"customer_email": "new@example.com",
+		"parent":         map[string]any{"subscription_details": map[string]any{"subscription": "sub_2"}},
+	})
+
+	subscribers, err := p.HandleWebhook(context.Background(), now, payload, sign(payload, testSecret, now))
+	if err != nil || len(subscribers) != 1 || subscribers[0].Id != "sub_2" || subscribers[0].Email != "new@example.com" || subscribers[0].LastChargeStatus != providers.ChargeStatusPaid {
+		t.Errorf("unexpected subscribers %+v, %v", subscribers, err)
+	}
+}
+
+func TestOutOfOrderEvents(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProvider(t, s, "")
+	now := time.Now()
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+
+	handle := func(p *Provider, payload []byte) []providers.Subscriber {
+		t.Helper()
+
+		subscribers, err := p.HandleWebhook(context.Background(), now, payload, sign(payload, testSecret, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return subscribers
+	}
+
+	if got := handle(p, eventAt(t, EventSubscriptionDeleted, subscription("canceled", nil), later)); len(got) != 1 || got[0].Status != providers.StatusFormer {
+		t.Fatalf("expected a former subscriber, got %+v", got)
+	}
+
+	// The update was created before the cancellation, so it must not revive the subscription
+	if got := handle(p, eventAt(t, EventSubscriptionUpdated, subscription("active", nil), earlier)); len(got) != 0 {
+		t.Errorf("expected the older event to be ignored, got %+v", got)
+	}
+
+	// Invoices are ordered separately from subscription changes
+	invoice := map[string]any{"id": "in_1", "customer": "cus_1", "subscription": "sub_1"}
+	if got := handle(p, eventAt(t, EventInvoicePaid, invoice, earlier)); len(got) != 1 || got[0].Status != providers.StatusFormer || got[0].LastChargeStatus != providers.ChargeStatusPaid {
+		t.Errorf("expected the invoice to be applied, got %+v", got)
+	}
+
+	// The last applied events are persisted
+	p = newTestProvider(t, s, "")
+	if got := handle(p, eventAt(t, EventSubscriptionUpdated, subscription("active", nil), earlier)); len(got) != 0 {
+		t.Errorf("expected the older event to be ignored after a restart, got %+v", got)
+	}
+}
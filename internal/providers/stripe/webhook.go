@@ -0,0 +1,156 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureTolerance is how far the timestamp of a signature may be from the current time, which is the same
+// tolerance Stripe's own libraries use.
+const SignatureTolerance = time.Minute * 5
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// VerifySignature checks the Stripe-Signature header of a webhook request against the endpoint's signing secret. The
+// header carries a timestamp and one or more v1 signatures, each an HMAC-SHA256 of the timestamp and the payload;
+// there is more than one while the secret is being rolled.
+func VerifySignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp int64
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.Wrap(ErrInvalidSignature, "invalid timestamp")
+			}
+
+			timestamp = parsed
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				continue
+			}
+
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.Wrap(ErrInvalidSignature, "missing timestamp or signature")
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > SignatureTolerance || age < -SignatureTolerance {
+		return errors.Wrap(ErrInvalidSignature, "timestamp outside of tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+const (
+	EventSubscriptionCreated  = "customer.subscription.created"
+	EventSubscriptionUpdated  = "customer.subscription.updated"
+	EventSubscriptionDeleted  = "customer.subscription.deleted"
+	EventInvoicePaid          = "invoice.paid"
+	EventInvoicePaymentFailed = "invoice.payment_failed"
+)
+
+type Event struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// Subscription is the subset of Stripe's subscription object that is used. Customer is an ID, as objects in webhook
+// events are never expanded.
+type Subscription struct {
+	Id               string            `json:"id"`
+	Customer         string            `json:"customer"`
+	Status           string            `json:"status"`
+	StartDate        int64             `json:"start_date"`
+	Currency         string            `json:"currency"`           // Lowercase ISO 4217 code
+	CurrentPeriodEnd int64             `json:"current_period_end"` // Moved to each item in newer API versions
+	Metadata         map[string]string `json:"metadata"`
+	Items            struct {
+		Data []SubscriptionItem `json:"data"`
+	} `json:"items"`
+}
+
+type SubscriptionItem struct {
+	Quantity         int   `json:"quantity"`
+	CurrentPeriodEnd int64 `json:"current_period_end"`
+	Price            struct {
+		Id         string `json:"id"`
+		UnitAmount int    `json:"unit_amount"`
+		Recurring  struct {
+			Interval      string `json:"interval"` // day, week, month or year
+			IntervalCount int    `json:"interval_count"`
+		} `json:"recurring"`
+	} `json:"price"`
+}
+
+// PeriodEnd returns the end of the subscription's current period, whichever API version the event was sent with.
+func (s Subscription) PeriodEnd() int64 {
+	periodEnd := s.CurrentPeriodEnd
+	for _, item := range s.Items.Data {
+		if item.CurrentPeriodEnd > periodEnd {
+			periodEnd = item.CurrentPeriodEnd
+		}
+	}
+
+	return periodEnd
+}
+
+// Invoice is the subset of Stripe's invoice object that is used.
+type Invoice struct {
+	Id            string `json:"id"`
+	Customer      string `json:"customer"`
+	CustomerEmail string `json:"customer_email"`
+	Subscription  string `json:"subscription"` // Moved to Parent in newer API versions
+	Parent        *struct {
+		SubscriptionDetails *struct {
+			Subscription string `json:"subscription"`
+		} `json:"subscription_details"`
+	} `json:"parent"`
+}
+
+// SubscriptionId returns the ID of the subscription the invoice is for, whichever API version the event was sent
+// with, or an empty string if it is not for a subscription.
+func (i Invoice) SubscriptionId() string {
+	if i.Subscription != "" {
+		return i.Subscription
+	}
+
+	if i.Parent != nil && i.Parent.SubscriptionDetails != nil {
+		return i.Parent.SubscriptionDetails.Subscription
+	}
+
+	return ""
+}
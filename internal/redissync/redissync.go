@@ -0,0 +1,188 @@
+// Package redissync shares the entitlement of each Discord user, and events for changes to subscribers, with other
+// services through Redis, so that they can check entitlements without calling the app.
+//
+// After every update, each user's entitlement is written as JSON to <prefix>entitlement:<discord_id>, and the time of
+// the update to <prefix>updated_at, both with a TTL. Users who are no longer entitled to anything have their key
+// deleted. Events are then published as JSON on the configured channel.
+package redissync
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPendingEvents bounds the events held while Redis is unreachable. The oldest are dropped first, as the keys,
+// which are always rewritten in full, matter more than any one event.
+const maxPendingEvents = 10000
+
+const (
+	writeTimeout = time.Second * 10
+	retryDelay   = time.Second * 5
+)
+
+type Publisher struct {
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	channel string
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	snapshot *changes.Snapshot // The latest snapshot, if it has not been written yet
+	events   []changes.Event   // Events that have not been published yet
+	notify   chan struct{}
+
+	written map[uint64]bool // The users with an entitlement key, as of the last write. Only used by Run.
+}
+
+var _ changes.Sink = (*Publisher)(nil)
+
+func NewPublisher(conf config.Config, logger *zap.Logger) *Publisher {
+	return &Publisher{
+		client: redis.NewClient(&redis.Options{
+			Addr:     conf.Redis.Addr,
+			Password: conf.Redis.Password,
+			DB:       conf.Redis.Db,
+		}),
+		prefix:  conf.Redis.KeyPrefix,
+		ttl:     conf.Redis.Ttl.Duration(),
+		channel: conf.Redis.Channel,
+		logger:  logger,
+		notify:  make(chan struct{}, 1),
+		written: make(map[uint64]bool),
+	}
+}
+
+// Publish queues the snapshot and events to be written by Run. Only the latest snapshot is kept, as each replaces the
+// one before it.
+func (p *Publisher) Publish(snapshot changes.Snapshot, events []changes.Event) {
+	p.mu.Lock()
+	p.snapshot = &snapshot
+	p.events = appendBounded(p.events, events...)
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run writes each queued snapshot and its events until the context is cancelled, retrying while Redis is unreachable.
+func (p *Publisher) Run(ctx context.Context) {
+	defer p.client.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.notify:
+		}
+
+		for !p.flush(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
+
+// flush writes the queued snapshot and events, returning false if they should be retried.
+func (p *Publisher) flush(ctx context.Context) bool {
+	p.mu.Lock()
+	snapshot, events := p.snapshot, p.events
+	p.snapshot, p.events = nil, nil
+	p.mu.Unlock()
+
+	if snapshot == nil && len(events) == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	if err := p.write(ctx, snapshot, events); err != nil {
+		p.logger.Error("Failed to write to Redis", zap.Error(err), zap.Int("events", len(events)))
+
+		// Requeue, unless a newer snapshot has arrived in the meantime, in which case only the events are kept
+		p.mu.Lock()
+		if p.snapshot == nil {
+			p.snapshot = snapshot
+		}
+
+		p.events = appendBounded(events, p.events...)
+		p.mu.Unlock()
+
+		return false
+	}
+
+	return true
+}
+
+func (p *Publisher) write(ctx context.Context, snapshot *changes.Snapshot, events []changes.Event) error {
+	written := p.written
+
+	_, err := p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if snapshot != nil {
+			written = make(map[uint64]bool, len(snapshot.Entitlements))
+			for discordId, entitlement := range snapshot.Entitlements {
+				encoded, err := json.Marshal(entitlement)
+				if err != nil {
+					return err
+				}
+
+				pipe.Set(ctx, p.EntitlementKey(discordId), encoded, p.ttl)
+				written[discordId] = true
+			}
+
+			for discordId := range p.written {
+				if !written[discordId] {
+					pipe.Del(ctx, p.EntitlementKey(discordId))
+				}
+			}
+
+			pipe.Set(ctx, p.prefix+"updated_at", snapshot.Time.Unix(), p.ttl)
+		}
+
+		// Events are published after the keys are written, so that services reading the keys in response to an event
+		// see the change
+		for _, event := range events {
+			encoded, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			pipe.Publish(ctx, p.channel, encoded)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.written = written
+	return nil
+}
+
+// EntitlementKey returns the key the user's entitlement is written to.
+func (p *Publisher) EntitlementKey(discordId uint64) string {
+	return p.prefix + "entitlement:" + strconv.FormatUint(discordId, 10)
+}
+
+func appendBounded(events []changes.Event, more ...changes.Event) []changes.Event {
+	events = append(events, more...)
+	if len(events) > maxPendingEvents {
+		events = events[len(events)-maxPendingEvents:]
+	}
+
+	return events
+}
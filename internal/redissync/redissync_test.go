@@ -0,0 +1,203 @@
+package redissync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is just enough of a Redis server to record the commands the publisher sends
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	values   map[string]string
+	ttls     map[string]time.Duration
+	messages []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		values:   make(map[string]string),
+		ttls:     make(map[string]time.Duration),
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		reply := "+OK\r\n"
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			f.values[args[1]] = args[2]
+			if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+				ms, _ := strconv.Atoi(args[4])
+				f.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+			} else if len(args) == 5 && strings.ToUpper(args[3]) == "EX" {
+				seconds, _ := strconv.Atoi(args[4])
+				f.ttls[args[1]] = time.Duration(seconds) * time.Second
+			}
+		case "DEL":
+			delete(f.values, args[1])
+			reply = ":1\r\n"
+		case "PUBLISH":
+			f.messages = append(f.messages, args[2])
+			reply = ":0\r\n"
+		}
+		f.mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil { // The length of the bulk string
+			return nil, err
+		}
+
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+
+	return args, nil
+}
+
+// waitFor blocks until the condition holds for the recorded state
+func (f *fakeRedis) waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		f.mu.Lock()
+		ok := condition()
+		f.mu.Unlock()
+
+		if ok {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for Redis commands")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	f := newFakeRedis(t)
+
+	var conf config.Config
+	conf.Redis.Addr = f.listener.Addr().String()
+	conf.Redis.KeyPrefix = "test:"
+	conf.Redis.Ttl = config.Duration(time.Minute)
+	conf.Redis.Channel = "test:events"
+
+	p := NewPublisher(conf, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	premium := entitlements.Entitlement{Type: entitlements.TypePremium, MaxGuilds: 1, Source: "patreon"}
+	p.Publish(changes.Snapshot{
+		Time:         time.Now(),
+		Entitlements: map[uint64]entitlements.Entitlement{1: premium, 2: premium},
+	}, nil)
+
+	f.waitFor(t, func() bool {
+		return f.values["test:entitlement:1"] != "" && f.values["test:entitlement:2"] != "" && f.values["test:updated_at"] != ""
+	})
+
+	var got entitlements.Entitlement
+	if err := json.Unmarshal([]byte(f.values["test:entitlement:1"]), &got); err != nil || got.Type != entitlements.TypePremium || got.MaxGuilds != 1 {
+		t.Errorf("unexpected entitlement %+v, %v", got, err)
+	}
+
+	if ttl := f.ttls["test:entitlement:1"]; ttl != time.Minute {
+		t.Errorf("expected a TTL of a minute, got %s", ttl)
+	}
+
+	// Users who are no longer entitled have their key removed, and the change is published
+	p.Publish(changes.Snapshot{
+		Time:         time.Now(),
+		Entitlements: map[uint64]entitlements.Entitlement{1: premium},
+	}, []changes.Event{{Type: changes.TypeEntitlementChanged, DiscordId: 2}})
+
+	f.waitFor(t, func() bool {
+		_, ok := f.values["test:entitlement:2"]
+		return !ok && len(f.messages) == 1
+	})
+
+	var event changes.Event
+	if err := json.Unmarshal([]byte(f.messages[0]), &event); err != nil || event.Type != changes.TypeEntitlementChanged || event.DiscordId != 2 || event.Entitlement != nil {
+		t.Errorf("unexpected event %s, %v", f.messages[0], err)
+	}
+
+	if key := p.EntitlementKey(123); key != fmt.Sprintf("test:entitlement:%d", 123) {
+		t.Errorf("unexpected key %q", key)
+	}
+}
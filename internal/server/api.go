@@ -0,0 +1,37 @@
+package server
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// AuthenticateApi checks the bearer token against the configured API keys. If no keys are configured, all requests
+// are rejected.
+func (s *Server) AuthenticateApi(ctx *gin.Context) {
+	header := ctx.GetHeader("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if !strings.HasPrefix(header, "Bearer ") || token == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, errorJson("Missing API key"))
+		return
+	}
+
+	if !s.validApiKey(token) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, errorJson("Invalid API key"))
+		return
+	}
+
+	ctx.Next()
+}
+
+// validApiKey reports whether the token is one of the configured API keys, comparing in constant time.
+func (s *Server) validApiKey(token string) bool {
+	for _, key := range s.config.Api.Keys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
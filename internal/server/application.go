@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Application is a Discord application that interactions are accepted from, with its public key already decoded.
+type Application struct {
+	Id            uint64 // 0 if configured via the legacy DISCORD_PUBLIC_KEY
+	PublicKey     ed25519.PublicKey
+	AllowedGuilds []uint64
+}
+
+const applicationKey = "application"
+
+func decodeApplications(conf config.Config) ([]Application, error) {
+	configured := conf.Applications()
+	if len(configured) == 0 {
+		return nil, errors.New("no Discord applications configured")
+	}
+
+	applications := make([]Application, len(configured))
+	for i, app := range configured {
+		publicKey, err := hex.DecodeString(app.PublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode public key for application %d", app.ApplicationId)
+		}
+
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key for application %d has invalid length %d", app.ApplicationId, len(publicKey))
+		}
+
+		applications[i] = Application{
+			Id:            app.ApplicationId,
+			PublicKey:     publicKey,
+			AllowedGuilds: app.AllowedGuilds,
+		}
+	}
+
+	return applications, nil
+}
+
+// applicationFromContext returns the application whose key verified the request. Must only be called on routes
+// behind the Authenticate middleware.
+func applicationFromContext(ctx *gin.Context) Application {
+	return ctx.MustGet(applicationKey).(Application)
+}
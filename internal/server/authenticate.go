@@ -3,10 +3,14 @@ package server
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"io/ioutil"
+	"strconv"
+	"time"
 )
 
 func (s *Server) Authenticate(ctx *gin.Context) {
@@ -31,13 +35,7 @@ func (s *Server) Authenticate(ctx *gin.Context) {
 
 	ctx.Request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
-	// Verify signature
-	pubKey, err := hex.DecodeString(s.config.Discord.PublicKey)
-	if err != nil {
-		_ = ctx.AbortWithError(500, errors.Wrap(err, "Failed to decode public key"))
-		return
-	}
-
+	// Verify signature against each configured key, to support multiple applications and key rotation
 	signatureDecoded, err := hex.DecodeString(signature)
 	if err != nil {
 		ctx.AbortWithStatusJSON(400, errorJson("Failed to decode signature"))
@@ -45,10 +43,67 @@ func (s *Server) Authenticate(ctx *gin.Context) {
 	}
 
 	payload := append([]byte(timestamp), body...)
-	if !ed25519.Verify(pubKey, payload, signatureDecoded) {
+
+	var app *Application
+	for i := range s.applications {
+		if ed25519.Verify(s.applications[i].PublicKey, payload, signatureDecoded) {
+			app = &s.applications[i]
+			break
+		}
+	}
+
+	if app == nil {
 		ctx.AbortWithStatusJSON(401, errorJson("Invalid signature"))
 		return
 	}
 
+	// Only check the timestamp once we know it was signed by Discord, so that it can be trusted
+	unixTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, errorJson("Invalid signature timestamp"))
+		return
+	}
+
+	now := time.Now()
+	maxAge := s.config.Discord.MaxTimestampAge.Duration()
+	if age := now.Sub(time.Unix(unixTimestamp, 0)); age > maxAge || age < -maxAge {
+		ctx.AbortWithStatusJSON(401, errorJson("Signature timestamp outside of accepted window"))
+		return
+	}
+
+	// Interactions have an ID, while webhook events do not
+	var parsed struct {
+		Id    string `json:"id"`
+		Event *struct {
+			Type      string          `json:"type"`
+			Timestamp string          `json:"timestamp"`
+			Data      json.RawMessage `json:"data"`
+		} `json:"event"`
+	}
+
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		ctx.AbortWithStatusJSON(400, errorJson("Failed to parse body"))
+		return
+	}
+
+	// The header is not used directly, as hex decoding ignores case, so the same signature can be written many ways
+	keys := []string{"signature:" + hex.EncodeToString(signatureDecoded)}
+	if parsed.Id != "" {
+		keys = append(keys, "interaction:"+parsed.Id)
+	}
+
+	if event := parsed.Event; event != nil {
+		hash := sha256.New()
+		hash.Write([]byte(event.Type + "\x00" + event.Timestamp + "\x00"))
+		hash.Write(event.Data)
+		keys = append(keys, "event:"+hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	if !s.replayCache.CheckAndAdd(now, keys...) {
+		ctx.AbortWithStatusJSON(401, errorJson("Interaction has already been received"))
+		return
+	}
+
+	ctx.Set(applicationKey, *app)
 	ctx.Next()
 }
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/privacy"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects"
+	"github.com/rxdn/gdl/objects/channel"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/rest/request"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBulkLookupFileSize = 1024 * 1024
+	maxBulkLookupRows     = 1000
+	// Interactions must be responded to within 3 seconds, so downloading the attachment must be quick
+	bulkLookupDownloadTimeout = time.Second * 2
+)
+
+type bulkLookupRow struct {
+	input     string
+	inputType string // "email", "discord_id" or "invalid"
+	patron    *providers.Subscriber
+}
+
+func handleBulkLookup(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	command := data.Data
+	localizer := s.localizer(data)
+
+	var inputs []string
+	if option, ok := findOption(command.Options, "values"); ok {
+		if value, ok := option.Value.(string); ok {
+			inputs = append(inputs, parseBulkValues(value)...)
+		}
+	}
+
+	if option, ok := findOption(command.Options, "file"); ok {
+		attachment, ok := resolveAttachment(command.Resolved, option.Value)
+		if !ok {
+			return ephemeralResponse(localizer.T("bulklookup.file_missing"))
+		}
+
+		if attachment.Size > maxBulkLookupFileSize {
+			return ephemeralResponse(localizer.T("bulklookup.file_too_large", maxBulkLookupFileSize/1024))
+		}
+
+		content, err := s.downloadAttachment(attachment.Url)
+		if err != nil {
+			s.logger.Warn("Failed to download bulk lookup attachment", zap.Error(err))
+			return ephemeralResponse(localizer.T("bulklookup.file_download_failed"))
+		}
+
+		values, err := parseBulkFile(content)
+		if err != nil {
+			return ephemeralResponse(localizer.T("bulklookup.file_invalid"))
+		}
+
+		inputs = append(inputs, values...)
+	}
+
+	if len(inputs) == 0 {
+		return ephemeralResponse(localizer.T("bulklookup.no_input"))
+	}
+
+	if len(inputs) > maxBulkLookupRows {
+		return ephemeralResponse(localizer.T("bulklookup.too_many_rows", maxBulkLookupRows))
+	}
+
+	if !s.directory.Loaded() {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	rows := make([]bulkLookupRow, len(inputs))
+	for i, input := range inputs {
+		rows[i] = s.resolveBulkInput(input)
+	}
+
+	csvData, err := s.bulkLookupCsv(rows)
+	if err != nil {
+		s.logger.Error("Failed to build bulk lookup CSV", zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	var found, active, declined, invalid int
+	for _, row := range rows {
+		if row.inputType == "invalid" {
+			invalid++
+		} else if row.patron != nil {
+			found++
+
+			switch row.patron.Status {
+			case providers.StatusActive:
+				active++
+			case providers.StatusDeclined:
+				declined++
+			}
+		}
+	}
+
+	var flags uint
+	if !s.config.Privacy.PublicLookups {
+		flags = uint(message.FlagEphemeral)
+	}
+
+	res := messageResponse(interaction.ApplicationCommandCallbackData{
+		Embeds: []*embed.Embed{
+			{
+				Title:     localizer.T("bulklookup.title"),
+				Timestamp: ptr(time.Now()),
+				Color:     0x4287f5,
+				Fields: []*embed.EmbedField{
+					{Name: localizer.T("bulklookup.field.rows"), Value: strconv.Itoa(len(rows)), Inline: true},
+					{Name: localizer.T("bulklookup.field.found"), Value: strconv.Itoa(found), Inline: true},
+					{Name: localizer.T("bulklookup.field.not_found"), Value: strconv.Itoa(len(rows) - found - invalid), Inline: true},
+					{Name: localizer.T("bulklookup.field.active"), Value: strconv.Itoa(active), Inline: true},
+					{Name: localizer.T("bulklookup.field.declined"), Value: strconv.Itoa(declined), Inline: true},
+					{Name: localizer.T("bulklookup.field.invalid"), Value: strconv.Itoa(invalid), Inline: true},
+				},
+			},
+		},
+		Flags: flags,
+	})
+
+	res.Files = []request.Attachment{
+		{
+			FileName: "bulk-lookup.csv",
+			File: request.File{
+				ContentType: "text/csv",
+				Reader:      bytes.NewReader(csvData),
+			},
+		},
+	}
+
+	return res
+}
+
+// bulkLookupColumns are the header names of the column to look up in a CSV file, if it has more than one
+var bulkLookupColumns = map[string]bool{
+	"email":         true,
+	"email_address": true,
+	"discord_id":    true,
+}
+
+func newBulkCsvReader(content string) *csv.Reader {
+	r := csv.NewReader(strings.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	// Spreadsheets in some locales export with semicolons, as the comma is the decimal separator
+	firstLine, _, _ := strings.Cut(content, "\n")
+	if strings.Contains(firstLine, ";") && !strings.Contains(firstLine, ",") {
+		r.Comma = ';'
+	}
+
+	return r
+}
+
+// parseBulkValues parses the values option, a comma-separated list
+func parseBulkValues(value string) []string {
+	records, err := newBulkCsvReader(value).ReadAll()
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, record := range records {
+		for _, field := range record {
+			if field = strings.TrimSpace(field); field != "" {
+				values = append(values, field)
+			}
+		}
+	}
+
+	return values
+}
+
+// parseBulkFile parses an attached CSV or text file, with one value per line. If the file has more than one column,
+// the email or Discord ID column is used if the header names one, or otherwise the first. A header row is skipped.
+func parseBulkFile(content string) ([]string, error) {
+	records, err := newBulkCsvReader(content).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse CSV")
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	column, header := 0, false
+	for i, name := range records[0] {
+		if bulkLookupColumns[strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")] {
+			column, header = i, true
+			break
+		}
+	}
+
+	// Otherwise, the first row is a header if it isn't something that can be looked up, e.g. "Email Address"
+	if !header && len(records[0]) > 0 {
+		header = bulkInputType(strings.TrimSpace(records[0][0])) == "invalid"
+	}
+
+	if header {
+		records = records[1:]
+	}
+
+	var values []string
+	for _, record := range records {
+		if column >= len(record) {
+			continue
+		}
+
+		if value := strings.TrimSpace(record[column]); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// resolveAttachment finds the attachment referenced by an attachment option's value (the attachment ID)
+func resolveAttachment(resolved interaction.ResolvedData, value any) (channel.Attachment, bool) {
+	rawId, ok := value.(string)
+	if !ok {
+		return channel.Attachment{}, false
+	}
+
+	id, err := strconv.ParseUint(rawId, 10, 64)
+	if err != nil {
+		return channel.Attachment{}, false
+	}
+
+	attachment, ok := resolved.Attachments[objects.Snowflake(id)]
+	return attachment, ok
+}
+
+func (s *Server) downloadAttachment(url string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkLookupDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("attachment download returned %d status code", res.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxBulkLookupFileSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(content) > maxBulkLookupFileSize {
+		return "", errors.New("attachment exceeds maximum size")
+	}
+
+	return string(content), nil
+}
+
+// bulkInputType returns "email", "discord_id" or "invalid", depending on what the input can be looked up as.
+func bulkInputType(input string) string {
+	if strings.Contains(input, "@") {
+		return "email"
+	} else if _, err := strconv.ParseUint(input, 10, 64); err == nil {
+		return "discord_id"
+	} else {
+		return "invalid"
+	}
+}
+
+// resolveBulkInput looks up a single email or Discord ID.
+func (s *Server) resolveBulkInput(input string) bulkLookupRow {
+	row := bulkLookupRow{
+		input:     input,
+		inputType: bulkInputType(input),
+	}
+
+	switch row.inputType {
+	case "email":
+		if patron, ok := s.directory.ByEmail(input); ok {
+			row.patron = &patron
+		}
+	case "discord_id":
+		discordId, _ := strconv.ParseUint(input, 10, 64)
+		if patron, ok := s.directory.ByDiscordId(discordId); ok {
+			row.patron = &patron
+		}
+	}
+
+	return row
+}
+
+func (s *Server) bulkLookupCsv(rows []bulkLookupRow) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{"input", "input_type", "found", "email", "patron_id", "status", "last_charge_status", "last_charge_date", "tiers", "discord_id", "provider", "subscriber_id"}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		record := []string{row.input, row.inputType, strconv.FormatBool(row.patron != nil), "", "", "", "", "", "", "", "", ""}
+
+		if patron := row.patron; patron != nil {
+			email := patron.Email
+			if !s.config.Privacy.ShowEmails && row.inputType != "email" {
+				email = privacy.MaskEmail(email)
+			}
+
+			var discordId string
+			if patron.DiscordId != nil {
+				discordId = strconv.FormatUint(*patron.DiscordId, 10)
+			}
+
+			record[3] = email
+			if id := patron.PatronId(); id != 0 {
+				record[4] = strconv.FormatUint(id, 10)
+			}
+			record[5] = patron.PatronStatus()
+			record[6] = patron.LastChargeStatus
+			if !patron.LastChargeDate.IsZero() {
+				record[7] = patron.LastChargeDate.Format(time.RFC3339)
+			}
+			record[8] = strings.Join(s.config.TierNames(patron.Tiers), ";")
+			record[9] = discordId
+			record[10] = patron.Provider
+			record[11] = patron.Id
+		}
+
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
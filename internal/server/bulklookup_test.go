@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"encoding/csv"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkLookup(t *testing.T) {
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list.csv":
+			_, _ = w.Write([]byte("Name,Email\nPatron,patron@example.com\n\"Someone, Else\",someone@example.com\n"))
+		case "/list.txt":
+			_, _ = w.Write([]byte("Email Address\npatron@example.com\n12345\n"))
+		case "/invalid.csv":
+			_, _ = w.Write([]byte("email\n\"unterminated\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer fileServer.Close()
+
+	const attachmentId = 900
+
+	bulkLookup := func(url string, options ...servertest.Option) []byte {
+		return servertest.Command{
+			GuildId: allowedGuildId,
+			UserId:  1,
+			Name:    "bulk-lookup",
+			Options: options,
+			Attachments: map[uint64]servertest.Attachment{
+				attachmentId: {Filename: "list.csv", Url: url, Size: 64},
+			},
+		}.Payload()
+	}
+
+	t.Run("file and values", func(t *testing.T) {
+		h := servertest.NewHarness(t, testConfig())
+		h.SetPledges(testPledges())
+
+		recorder := h.Do(bulkLookup(fileServer.URL+"/list.csv",
+			servertest.AttachmentOption("file", attachmentId),
+			servertest.StringOption("values", "12345, 99999,not-an-id"),
+		))
+
+		res, files := servertest.DecodeMultipartResponse(t, recorder)
+		if res.Data.Flags != 64 {
+			t.Errorf("expected ephemeral response, got flags %d", res.Data.Flags)
+		}
+
+		if len(res.Data.Embeds) != 1 {
+			t.Fatalf("expected 1 embed, got %d", len(res.Data.Embeds))
+		}
+
+		summary := make(map[string]string)
+		for _, field := range res.Data.Embeds[0].Fields {
+			summary[field.Name] = field.Value
+		}
+
+		want := map[string]string{"Rows": "5", "Found": "2", "Not Found": "2", "Active": "2", "Declined": "0", "Invalid": "1"}
+		for name, value := range want {
+			if summary[name] != value {
+				t.Errorf("expected %s to be %s, got %q", name, value, summary[name])
+			}
+		}
+
+		records, err := csv.NewReader(strings.NewReader(string(files["bulk-lookup.csv"]))).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to parse CSV: %v", err)
+		}
+
+		// Header, then values in the order given: the values option is read before the file, whose header is skipped
+		if len(records) != 6 {
+			t.Fatalf("expected 6 CSV records, got %d: %v", len(records), records)
+		}
+
+		if got := records[1]; got[0] != "12345" || got[1] != "discord_id" || got[2] != "true" || got[3] != "p***@example.com" {
+			t.Errorf("unexpected record for Discord ID lookup: %v", got)
+		}
+
+		if got := records[3]; got[0] != "not-an-id" || got[1] != "invalid" || got[2] != "false" {
+			t.Errorf("unexpected record for invalid input: %v", got)
+		}
+
+		if got := records[5]; got[0] != "someone@example.com" || got[2] != "false" {
+			t.Errorf("expected the email column to be used, got %v", got)
+		}
+
+		if got := records[4]; got[0] != "patron@example.com" || got[5] != "active_patron" || got[8] != "Premium" {
+			t.Errorf("unexpected record for email lookup: %v", got)
+		}
+	})
+
+	t.Run("text file", func(t *testing.T) {
+		h := servertest.NewHarness(t, testConfig())
+		h.SetPledges(testPledges())
+
+		_, files := servertest.DecodeMultipartResponse(t, h.Do(bulkLookup(fileServer.URL+"/list.txt",
+			servertest.AttachmentOption("file", attachmentId),
+		)))
+
+		records, err := csv.NewReader(strings.NewReader(string(files["bulk-lookup.csv"]))).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to parse CSV: %v", err)
+		}
+
+		// A header without a known column name is skipped, as it cannot be looked up
+		if len(records) != 3 || records[1][0] != "patron@example.com" || records[2][0] != "12345" {
+			t.Errorf("unexpected CSV records %v", records)
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		h := servertest.NewHarness(t, testConfig())
+		h.SetPledges(testPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(bulkLookup(fileServer.URL+"/invalid.csv",
+			servertest.AttachmentOption("file", attachmentId),
+		)))
+		if res.Data.Content != "The attached file could not be read as CSV" {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+
+	t.Run("no input", func(t *testing.T) {
+		h := servertest.NewHarness(t, testConfig())
+		h.SetPledges(testPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(bulkLookup(fileServer.URL+"/list.csv")))
+		if res.Data.Content != "Provide a file or a list of values to look up" {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+
+	t.Run("download failure", func(t *testing.T) {
+		h := servertest.NewHarness(t, testConfig())
+		h.SetPledges(testPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(bulkLookup(fileServer.URL+"/missing.csv",
+			servertest.AttachmentOption("file", attachmentId),
+		)))
+		if res.Data.Content != "The attached file could not be downloaded, please try again" {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+}
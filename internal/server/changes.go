@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"time"
+)
+
+// publishChanges sends a snapshot of the current subscribers and entitlements, and the changes since the last one, to
+// each sink. The caller must hold updateMu, so that snapshots are compared in the order they were taken.
+func (s *Server) publishChanges() {
+	if len(s.sinks) == 0 || !s.complete() {
+		return
+	}
+
+	snapshot := s.snapshot(time.Now())
+	events := s.changes.Detect(snapshot)
+
+	for _, sink := range s.sinks {
+		sink.Publish(snapshot, events)
+	}
+}
+
+// entitlementsChanged publishes changes after entitlements change other than through an update, such as by a grant.
+func (s *Server) entitlementsChanged() {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.publishChanges()
+}
+
+func (s *Server) snapshot(now time.Time) changes.Snapshot {
+	all := s.directory.All()
+
+	subscribers := make(map[string]providers.Subscriber, len(all))
+	byDiscordId := make(map[uint64][]providers.Subscriber)
+	for _, subscriber := range all {
+		subscribers[subscriber.Key()] = subscriber
+
+		if subscriber.DiscordId != nil {
+			byDiscordId[*subscriber.DiscordId] = append(byDiscordId[*subscriber.DiscordId], subscriber)
+		}
+	}
+
+	// Users with a grant are entitled without a subscription
+	for _, grant := range s.grants.List(now, false) {
+		if _, ok := byDiscordId[grant.UserId]; !ok {
+			byDiscordId[grant.UserId] = nil
+		}
+	}
+
+	entitled := make(map[uint64]entitlements.Entitlement)
+	for discordId, subscriptions := range byDiscordId {
+		if best := s.bestEntitlement(s.grantEntitlement(discordId), subscriptions); best != nil {
+			entitled[discordId] = *best
+		}
+	}
+
+	return changes.Snapshot{
+		Time:         now,
+		Subscribers:  subscribers,
+		Entitlements: entitled,
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/commands"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/rest/request"
+)
+
+type commandHandler func(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse
+
+// commandResponse is a channel message response, optionally with files attached. If there are files, the response
+// is sent as multipart/form-data.
+type commandResponse struct {
+	interaction.ResponseChannelMessage
+	Files []request.Attachment `json:"-"`
+}
+
+func messageResponse(data interaction.ApplicationCommandCallbackData) commandResponse {
+	return commandResponse{
+		ResponseChannelMessage: interaction.NewResponseChannelMessage(data),
+	}
+}
+
+// multipartPayload adds the attachment metadata to the response data, as Discord requires it to be present in
+// payload_json alongside the files.
+type multipartPayload struct {
+	interaction.Response
+	Data struct {
+		interaction.ApplicationCommandCallbackData
+		Attachments []request.Attachment `json:"attachments"`
+	} `json:"data"`
+}
+
+func (p multipartPayload) GetAttachments() []request.Attachment {
+	return p.Data.Attachments
+}
+
+func (r commandResponse) multipartPayload() multipartPayload {
+	var payload multipartPayload
+	payload.Response = r.Response
+	payload.Data.ApplicationCommandCallbackData = r.Data
+	payload.Data.Attachments = make([]request.Attachment, len(r.Files))
+	for i, file := range r.Files {
+		file.Id = i
+		payload.Data.Attachments[i] = file
+	}
+
+	return payload
+}
+
+// commandHandlers maps each command in the commands package to its handler
+var commandHandlers = map[string]commandHandler{
+	commands.Lookup.Name:         handleLookup,
+	commands.MySubscription.Name: handleMySubscription,
+	commands.BulkLookup.Name:     handleBulkLookup,
+	commands.Export.Name:         handleExport,
+	commands.Stats.Name:          handleStats,
+	commands.Trend.Name:          handleTrend,
+	commands.Premium.Name:        handlePremium,
+	commands.Grant.Name:          handleGrant,
+	commands.Revoke.Name:         handleRevoke,
+	commands.Grants.Name:         handleGrants,
+}
+
+func validateCommandHandlers() error {
+	for _, cmd := range commands.All() {
+		if _, ok := commandHandlers[cmd.Name]; !ok {
+			return fmt.Errorf("command %s has no handler", cmd.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/declines"
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+func newDeclinesTracker(
+	conf config.Config,
+	store *store.Store,
+	renderer *embeds.Renderer,
+	catalogue *i18n.Catalogue,
+	logger *zap.Logger,
+) (*declines.Tracker, error) {
+	var notifier declines.Notifier
+	if conf.Declines.StaffChannelId != 0 || conf.Declines.SendReminders {
+		token := conf.Declines.BotToken
+		if token == "" {
+			for _, app := range conf.Applications() {
+				if app.BotToken != "" {
+					token = app.BotToken
+					break
+				}
+			}
+		}
+
+		if token == "" {
+			return nil, errors.New("a bot token is required to send declined payment notifications")
+		}
+
+		notifier = declines.NewDiscordNotifier(token)
+	}
+
+	// Staff messages are not sent in response to an interaction, so there is no user locale to use
+	return declines.NewTracker(conf, store, notifier, renderer, catalogue.Localizer(), logger.With(zap.String("component", "declines")))
+}
+
+// ProcessDeclines runs the declined payment workflow against the current snapshot. It does nothing if the workflow
+// is disabled.
+func (s *Server) ProcessDeclines(ctx context.Context) {
+	if s.declines == nil {
+		return
+	}
+
+	if !s.complete() {
+		return
+	}
+
+	if err := s.declines.Process(ctx, time.Now(), s.directory.All()); err != nil {
+		s.logger.Error("Failed to process declined payments", zap.Error(err))
+	}
+}
+
+// HandleDeclines lists the declined payment cases, optionally filtered by ?state=open or ?state=closed.
+func (s *Server) HandleDeclines(ctx *gin.Context) {
+	if s.declines == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("The declined payment workflow is not enabled"))
+		return
+	}
+
+	state := ctx.Query("state")
+	if state != "" && state != "open" && state != "closed" {
+		ctx.JSON(http.StatusBadRequest, errorJson("state must be open or closed"))
+		return
+	}
+
+	cases := make([]declines.Case, 0)
+	for _, c := range s.declines.Cases() {
+		if state == "" || (state == "open") == c.Open() {
+			cases = append(cases, c)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"cases": cases,
+	})
+}
@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+)
+
+func TestDeclinesApi(t *testing.T) {
+	conf := testConfig()
+	conf.Api.Keys = []string{testApiKey}
+
+	t.Run("disabled", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		if recorder := apiRequest(t, h, "/api/v1/declines", testApiKey); recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", recorder.Code)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		conf := conf
+		conf.Declines.Enabled = true
+
+		h := servertest.NewHarness(t, conf)
+
+		// The first snapshot is recorded without notifications, but cases are still opened
+		h.SetPledges(exportPledges())
+		h.Server.ProcessDeclines(context.Background())
+
+		var body struct {
+			Cases []struct {
+				PatronId   uint64 `json:"patron_id"`
+				Email      string `json:"email"`
+				Reason     string `json:"reason"`
+				Outcome    string `json:"outcome"`
+				Backfilled bool   `json:"backfilled"`
+			} `json:"cases"`
+		}
+
+		recorder := apiRequest(t, h, "/api/v1/declines?state=open", testApiKey)
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Cases) != 1 || body.Cases[0].PatronId != 2 || body.Cases[0].Reason != "status_declined" || !body.Cases[0].Backfilled {
+			t.Errorf("unexpected cases %+v", body.Cases)
+		}
+
+		if recorder := apiRequest(t, h, "/api/v1/declines?state=closed", testApiKey); recorder.Body.String() != `{"cases":[]}` {
+			t.Errorf("expected no closed cases, got %s", recorder.Body.String())
+		}
+	})
+
+	t.Run("notifications require a bot token", func(t *testing.T) {
+		conf := conf
+		conf.Declines.Enabled = true
+		conf.Declines.StaffChannelId = 1
+		conf.DataDir = t.TempDir()
+
+		signer, err := servertest.NewSigner()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf.Discord.PublicKey = signer.PublicKeyHex()
+
+		if _, err := server.NewServer(conf, zap.NewNop()); err == nil {
+			t.Error("expected an error when no bot token is configured")
+		}
+	})
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/rest/request"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportFormatCsv    = "csv"
+	exportFormatNdjson = "ndjson"
+)
+
+type exportColumn struct {
+	name string
+	csv  func(s *Server, patron providers.Subscriber) string
+	json func(s *Server, patron providers.Subscriber) any
+}
+
+var exportColumns = []exportColumn{
+	{
+		// The Patreon ID, which is empty for subscribers with other providers
+		name: "id",
+		csv: func(_ *Server, p providers.Subscriber) string {
+			if id := p.PatronId(); id != 0 {
+				return strconv.FormatUint(id, 10)
+			}
+
+			return ""
+		},
+		json: func(_ *Server, p providers.Subscriber) any {
+			if id := p.PatronId(); id != 0 {
+				return id
+			}
+
+			return nil
+		},
+	},
+	{
+		name: "email",
+		csv:  func(_ *Server, p providers.Subscriber) string { return p.Email },
+		json: func(_ *Server, p providers.Subscriber) any { return p.Email },
+	},
+	{
+		// Discord IDs are strings in JSON, as they exceed the precision of a double
+		name: "discord_id",
+		csv: func(_ *Server, p providers.Subscriber) string {
+			if p.DiscordId == nil {
+				return ""
+			}
+
+			return strconv.FormatUint(*p.DiscordId, 10)
+		},
+		json: func(_ *Server, p providers.Subscriber) any {
+			if p.DiscordId == nil {
+				return nil
+			}
+
+			return strconv.FormatUint(*p.DiscordId, 10)
+		},
+	},
+	{
+		name: "status",
+		csv:  func(_ *Server, p providers.Subscriber) string { return p.PatronStatus() },
+		json: func(_ *Server, p providers.Subscriber) any { return p.PatronStatus() },
+	},
+	{
+		name: "last_charge_status",
+		csv:  func(_ *Server, p providers.Subscriber) string { return p.LastChargeStatus },
+		json: func(_ *Server, p providers.Subscriber) any { return p.LastChargeStatus },
+	},
+	timeColumn("last_charge_date", func(p providers.Subscriber) time.Time { return p.LastChargeDate }),
+	timeColumn("pledge_start", func(p providers.Subscriber) time.Time { return p.StartedAt }),
+	timeColumn("next_charge_date", func(p providers.Subscriber) time.Time { return p.NextChargeDate }),
+	{
+		name: "tiers",
+		csv: func(_ *Server, p providers.Subscriber) string {
+			ids := make([]string, len(p.Tiers))
+			for i, tier := range p.Tiers {
+				ids[i] = strconv.FormatUint(tier, 10)
+			}
+
+			return strings.Join(ids, ";")
+		},
+		json: func(_ *Server, p providers.Subscriber) any {
+			if p.Tiers == nil {
+				return []uint64{}
+			}
+
+			return p.Tiers
+		},
+	},
+	{
+		name: "tier_names",
+		csv: func(s *Server, p providers.Subscriber) string {
+			return strings.Join(s.config.TierNames(p.Tiers), ";")
+		},
+		json: func(s *Server, p providers.Subscriber) any {
+			return s.config.TierNames(p.Tiers)
+		},
+	},
+	{
+		name: "provider",
+		csv:  func(_ *Server, p providers.Subscriber) string { return p.Provider },
+		json: func(_ *Server, p providers.Subscriber) any { return p.Provider },
+	},
+	{
+		// The ID with the provider, which is set for every provider
+		name: "subscriber_id",
+		csv:  func(_ *Server, p providers.Subscriber) string { return p.Id },
+		json: func(_ *Server, p providers.Subscriber) any { return p.Id },
+	},
+}
+
+func timeColumn(name string, f func(providers.Subscriber) time.Time) exportColumn {
+	return exportColumn{
+		name: name,
+		csv: func(_ *Server, p providers.Subscriber) string {
+			if t := f(p); !t.IsZero() {
+				return t.Format(time.RFC3339)
+			}
+
+			return ""
+		},
+		json: func(_ *Server, p providers.Subscriber) any {
+			if t := f(p); !t.IsZero() {
+				return t
+			}
+
+			return nil
+		},
+	}
+}
+
+// exportOptions selects the format, columns and rows of an export. Empty filters match all patrons.
+type exportOptions struct {
+	Format         string
+	Columns        []exportColumn
+	Tiers          []uint64
+	Statuses       []string
+	ChargeStatuses []string
+}
+
+// parseExportOptions validates the raw, comma-separated option values shared by the command and HTTP endpoint
+func parseExportOptions(format, columns, tiers, statuses, chargeStatuses string) (exportOptions, error) {
+	var opts exportOptions
+
+	switch strings.ToLower(format) {
+	case "", exportFormatCsv:
+		opts.Format = exportFormatCsv
+	case exportFormatNdjson, "json":
+		opts.Format = exportFormatNdjson
+	default:
+		return opts, fmt.Errorf("unknown format %q", format)
+	}
+
+	if names := splitList(columns); len(names) > 0 {
+		for _, name := range names {
+			column, ok := findExportColumn(name)
+			if !ok {
+				return opts, fmt.Errorf("unknown column %q", name)
+			}
+
+			opts.Columns = append(opts.Columns, column)
+		}
+	} else {
+		opts.Columns = exportColumns
+	}
+
+	for _, raw := range splitList(tiers) {
+		tier, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid tier ID %q", raw)
+		}
+
+		opts.Tiers = append(opts.Tiers, tier)
+	}
+
+	// Statuses are exported in the form Patreon uses, e.g. active_patron, but may be given in either form
+	for _, status := range splitList(statuses) {
+		opts.Statuses = append(opts.Statuses, strings.TrimSuffix(status, "_patron"))
+	}
+
+	opts.ChargeStatuses = splitList(chargeStatuses)
+
+	return opts, nil
+}
+
+func findExportColumn(name string) (exportColumn, bool) {
+	for _, column := range exportColumns {
+		if column.name == name {
+			return column, true
+		}
+	}
+
+	return exportColumn{}, false
+}
+
+func (o exportOptions) matches(patron providers.Subscriber) bool {
+	if len(o.Statuses) > 0 && !containsFold(o.Statuses, patron.Status) {
+		return false
+	}
+
+	if len(o.ChargeStatuses) > 0 && !containsFold(o.ChargeStatuses, patron.LastChargeStatus) {
+		return false
+	}
+
+	if len(o.Tiers) > 0 {
+		for _, tier := range patron.Tiers {
+			if contains(o.Tiers, tier) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (o exportOptions) fileName() string {
+	return "patrons." + o.Format
+}
+
+func (o exportOptions) contentType() string {
+	if o.Format == exportFormatNdjson {
+		return "application/x-ndjson"
+	}
+
+	return "text/csv"
+}
+
+// exportPatrons returns the subscribers matching the filters, ordered by provider and ID. The second return value is
+// false if the initial subscriber data has not been loaded yet.
+func (s *Server) exportPatrons(opts exportOptions) ([]providers.Subscriber, bool) {
+	if !s.directory.Loaded() {
+		return nil, false
+	}
+
+	var patrons []providers.Subscriber
+	for _, patron := range s.directory.All() {
+		if opts.matches(patron) {
+			patrons = append(patrons, patron)
+		}
+	}
+
+	return patrons, true
+}
+
+func (s *Server) writeExport(w io.Writer, opts exportOptions, patrons []providers.Subscriber) error {
+	if opts.Format == exportFormatNdjson {
+		encoder := json.NewEncoder(w)
+		for _, patron := range patrons {
+			// Use an ordered encoding so that keys appear in the order the columns were requested in
+			row := make(orderedObject, len(opts.Columns))
+			for i, column := range opts.Columns {
+				row[i] = orderedField{Key: column.name, Value: column.json(s, patron)}
+			}
+
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(opts.Columns))
+	for i, column := range opts.Columns {
+		header[i] = column.name
+	}
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, patron := range patrons {
+		record := make([]string, len(opts.Columns))
+		for i, column := range opts.Columns {
+			record[i] = column.csv(s, patron)
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type orderedField struct {
+	Key   string
+	Value any
+}
+
+type orderedObject []orderedField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+
+	for i, field := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+func handleExport(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	command := data.Data
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	opts, err := parseExportOptions(
+		stringOption(command.Options, "format"),
+		stringOption(command.Options, "columns"),
+		stringOption(command.Options, "tiers"),
+		stringOption(command.Options, "status"),
+		stringOption(command.Options, "charge_status"),
+	)
+	if err != nil {
+		return ephemeralResponse(localizer.T("export.invalid_options", err.Error()))
+	}
+
+	patrons, ok := s.exportPatrons(opts)
+	if !ok {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	var buf bytes.Buffer
+	if err := s.writeExport(&buf, opts, patrons); err != nil {
+		s.logger.Error("Failed to write export", zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	res := ephemeralResponse(localizer.T("export.success", len(patrons)))
+	res.Files = []request.Attachment{
+		{
+			FileName: opts.fileName(),
+			File: request.File{
+				ContentType: opts.contentType(),
+				Reader:      &buf,
+			},
+		},
+	}
+
+	return res
+}
+
+// HandleExport serves the export over HTTP. The query parameters match the /export command options.
+func (s *Server) HandleExport(ctx *gin.Context) {
+	opts, err := parseExportOptions(
+		ctx.Query("format"),
+		ctx.Query("columns"),
+		ctx.Query("tiers"),
+		ctx.Query("status"),
+		ctx.Query("charge_status"),
+	)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJson(err.Error()))
+		return
+	}
+
+	patrons, ok := s.exportPatrons(opts)
+	if !ok {
+		ctx.JSON(http.StatusServiceUnavailable, errorJson("Pledge data has not been loaded yet"))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.writeExport(&buf, opts, patrons); err != nil {
+		_ = ctx.Error(errors.Wrap(err, "Failed to write export"))
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, opts.fileName()))
+	ctx.Data(http.StatusOK, opts.contentType(), buf.Bytes())
+}
@@ -0,0 +1,192 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testApiKey = "test-api-key"
+
+func exportPledges() map[string]patreon.Patron {
+	pledges := testPledges()
+	pledges["declined@example.com"] = patreon.Patron{
+		Attributes: patreon.Attributes{
+			Email:            "declined@example.com",
+			LastChargeDate:   time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+			LastChargeStatus: "Declined",
+			PatronStatus:     patreon.StatusDeclined,
+		},
+		Id:    2,
+		Tiers: []uint64{2},
+	}
+
+	return pledges
+}
+
+func apiRequest(t *testing.T, h *servertest.Harness, path, key string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	return h.DoRequest(req)
+}
+
+func TestExportApi(t *testing.T) {
+	conf := testConfig()
+	conf.Api.Keys = []string{testApiKey}
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "missing key",
+			path:       "/api/v1/export",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid key",
+			path:       "/api/v1/export",
+			key:        "wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "all columns",
+			path:       "/api/v1/export",
+			key:        testApiKey,
+			wantStatus: http.StatusOK,
+			wantBody: "id,email,discord_id,status,last_charge_status,last_charge_date,pledge_start,next_charge_date,tiers,tier_names,provider,subscriber_id\n" +
+				"1,patron@example.com,12345,active_patron,Paid,2023-01-01T00:00:00Z,2022-01-01T00:00:00Z,,1,Premium,patreon,1\n" +
+				"2,declined@example.com,,declined_patron,Declined,2023-02-01T00:00:00Z,,,2,2,patreon,2\n",
+		},
+		{
+			name:       "selected columns and filter",
+			path:       "/api/v1/export?columns=email,status&charge_status=declined",
+			key:        testApiKey,
+			wantStatus: http.StatusOK,
+			wantBody:   "email,status\ndeclined@example.com,declined_patron\n",
+		},
+		{
+			name:       "tier filter",
+			path:       "/api/v1/export?columns=id&tiers=1",
+			key:        testApiKey,
+			wantStatus: http.StatusOK,
+			wantBody:   "id\n1\n",
+		},
+		{
+			name:       "ndjson",
+			path:       "/api/v1/export?format=ndjson&columns=discord_id,id,tier_names&status=active_patron",
+			key:        testApiKey,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"discord_id":"12345","id":1,"tier_names":["Premium"]}` + "\n",
+		},
+		{
+			name:       "unknown column",
+			path:       "/api/v1/export?columns=password",
+			key:        testApiKey,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := servertest.NewHarness(t, conf)
+			h.SetPledges(exportPledges())
+
+			recorder := apiRequest(t, h, tc.path, tc.key)
+			if recorder.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantStatus, recorder.Code, recorder.Body.String())
+			}
+
+			if tc.wantBody != "" && recorder.Body.String() != tc.wantBody {
+				t.Errorf("expected body %q, got %q", tc.wantBody, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportApiDisabledWithoutKeys(t *testing.T) {
+	h := servertest.NewHarness(t, testConfig())
+	h.SetPledges(exportPledges())
+
+	if recorder := apiRequest(t, h, "/api/v1/export", "anything"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", recorder.Code)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	const adminRoleId = 600
+
+	conf := testConfig()
+	conf.Discord.AdminRoles = []uint64{adminRoleId}
+
+	export := func(roles []uint64, options ...servertest.Option) []byte {
+		return servertest.Command{
+			GuildId: allowedGuildId,
+			UserId:  1,
+			Roles:   roles,
+			Name:    "export",
+			Options: options,
+		}.Payload()
+	}
+
+	t.Run("not admin", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(export([]uint64{1})))
+		if res.Data.Content != "This command can only be used by admins" {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res, files := servertest.DecodeMultipartResponse(t, h.Do(export([]uint64{adminRoleId},
+			servertest.StringOption("format", "ndjson"),
+			servertest.StringOption("columns", "email"),
+		)))
+
+		if res.Data.Content != "Exported 2 patrons" || res.Data.Flags != 64 {
+			t.Errorf("unexpected response %+v", res.Data)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(files["patrons.ndjson"])), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %d", len(lines))
+		}
+
+		var row map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil || row["email"] != "patron@example.com" {
+			t.Errorf("unexpected row %q", lines[0])
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(export([]uint64{adminRoleId}, servertest.StringOption("tiers", "abc"))))
+		if res.Data.Content != `Invalid export options: invalid tier ID "abc"` {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGracePeriod(t *testing.T) {
+	conf := entitlementConfig()
+	conf.Grace.DeclinedDays = 7
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	res := servertest.DecodeResponse(t, h.Do(premiumCommand(1, 12345, "assign", "500")))
+	if !strings.HasPrefix(res.Data.Content, "Server `500` now has your premium") {
+		t.Fatalf("unexpected response %q", res.Data.Content)
+	}
+
+	// The patron's payment is declined, and Patreon stops listing their tier
+	declined := func(lastCharge time.Time) map[string]patreon.Patron {
+		pledges := testPledges()
+		patron := pledges["patron@example.com"]
+		patron.PatronStatus = patreon.StatusDeclined
+		patron.LastChargeStatus = patreon.ChargeStatusDeclined
+		patron.LastChargeDate = lastCharge
+		patron.Tiers = nil
+		pledges["patron@example.com"] = patron
+		return pledges
+	}
+
+	lastCharge := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	h.SetPledges(declined(lastCharge))
+
+	type patron struct {
+		Entitlement *struct {
+			Type       string     `json:"type"`
+			GraceUntil *time.Time `json:"grace_until"`
+		} `json:"entitlement"`
+	}
+
+	getPatron := func() patron {
+		t.Helper()
+
+		var got patron
+		recorder := apiRequest(t, h, "/api/v1/patrons/discord/12345", testApiKey)
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return got
+	}
+
+	wantUntil := lastCharge.AddDate(0, 0, 7)
+	if got := getPatron(); got.Entitlement == nil || got.Entitlement.GraceUntil == nil || !got.Entitlement.GraceUntil.Equal(wantUntil) {
+		t.Errorf("expected entitlement in grace period until %s, got %+v", wantUntil, got.Entitlement)
+	}
+
+	res = servertest.DecodeResponse(t, h.Do(servertest.Command{
+		Id:      2,
+		GuildId: allowedGuildId,
+		UserId:  1,
+		Name:    "lookup",
+		Options: []servertest.Option{servertest.StringOption("email", "patron@example.com")},
+	}.Payload()))
+	if len(res.Data.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %+v", res.Data)
+	}
+
+	fields := res.Data.Embeds[0].Fields
+	wantValue := "Premium, 3 server(s), legacy pricing, in grace period until <t:" + strconv.FormatInt(wantUntil.Unix(), 10) + ":D>"
+	if last := fields[len(fields)-1]; last.Value != wantValue {
+		t.Errorf("expected entitlement field %q, got %q", wantValue, last.Value)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/guilds/500/premium", testApiKey); !strings.Contains(recorder.Body.String(), `"premium":true`) {
+		t.Errorf("expected the guild to keep premium during the grace period, got %s", recorder.Body.String())
+	}
+
+	// Once the grace period has passed, the entitlement and assigned guilds are lost
+	h.SetPledges(declined(time.Now().AddDate(0, 0, -8)))
+
+	if got := getPatron(); got.Entitlement != nil {
+		t.Errorf("expected no entitlement after the grace period, got %+v", got.Entitlement)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/guilds/500/premium", testApiKey); !strings.Contains(recorder.Body.String(), `"premium":false`) {
+		t.Errorf("expected the guild to lose premium, got %s", recorder.Body.String())
+	}
+}
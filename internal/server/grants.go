@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/grants"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects/interaction"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxGrantsListed caps the number of grants listed by /grants, to stay within Discord's message length limit
+const maxGrantsListed = 15
+
+func handleGrant(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	command := data.Data
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	userId, ok := snowflakeOption(command.Options, "user")
+	if !ok {
+		return ephemeralResponse(localizer.T("grants.invalid_user"))
+	}
+
+	entitlementType := stringOption(command.Options, "type")
+	if entitlementType != entitlements.TypePremium && entitlementType != entitlements.TypeWhitelabel {
+		return ephemeralResponse(localizer.T("grants.invalid_type", entitlementType))
+	}
+
+	maxGuilds := 1
+	if option, ok := intOption(command.Options, "max_guilds"); ok {
+		if option < 1 {
+			return ephemeralResponse(localizer.T("grants.invalid_max_guilds"))
+		}
+
+		maxGuilds = option
+	}
+
+	now := time.Now()
+	expiresAt, err := parseExpiry(now, stringOption(command.Options, "expires"))
+	if err != nil {
+		return ephemeralResponse(localizer.T("grants.invalid_expiry"))
+	}
+
+	grant := grants.Grant{
+		UserId:    userId,
+		Type:      entitlementType,
+		MaxGuilds: maxGuilds,
+		Reason:    stringOption(command.Options, "reason"),
+		GrantedBy: interactionUserId(data),
+		GrantedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	replaced, err := s.grants.Grant(grant)
+	if err != nil {
+		s.logger.Error("Failed to store grant", zap.Uint64("user_id", userId), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	s.logger.Info(
+		"Granted entitlement",
+		zap.Uint64("user_id", userId),
+		zap.String("type", grant.Type),
+		zap.Int("max_guilds", grant.MaxGuilds),
+		zap.Uint64("granted_by", grant.GrantedBy),
+		zap.String("reason", grant.Reason),
+	)
+
+	// The new grant may allow fewer guilds than the one it replaced
+	if replaced != nil {
+		s.releasePremium()
+	}
+
+	s.entitlementsChanged()
+
+	content := localizer.T(
+		"grants.granted",
+		localizer.T("entitlement.type."+grant.Type),
+		grant.MaxGuilds,
+		userId,
+		grantExpiry(localizer, grant, now),
+	)
+
+	if replaced != nil {
+		content += "\n" + localizer.T("grants.replaced")
+	}
+
+	return ephemeralResponse(content)
+}
+
+func handleRevoke(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	userId, ok := snowflakeOption(data.Data.Options, "user")
+	if !ok {
+		return ephemeralResponse(localizer.T("grants.invalid_user"))
+	}
+
+	revokedBy := interactionUserId(data)
+	grant, ok, err := s.grants.Revoke(time.Now(), userId, revokedBy)
+	if err != nil {
+		s.logger.Error("Failed to revoke grant", zap.Uint64("user_id", userId), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	if !ok {
+		return ephemeralResponse(localizer.T("grants.not_found", userId))
+	}
+
+	s.logger.Info("Revoked grant", zap.Uint64("user_id", userId), zap.Uint64("revoked_by", revokedBy))
+	s.releasePremium()
+	s.entitlementsChanged()
+
+	return ephemeralResponse(localizer.T("grants.revoked", userId, localizer.T("entitlement.type."+grant.Type)))
+}
+
+func handleGrants(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	now := time.Now()
+	list := s.grants.List(now, boolOption(data.Data.Options, "all"))
+	if len(list) == 0 {
+		return ephemeralResponse(localizer.T("grants.list.none"))
+	}
+
+	lines := make([]string, 0, maxGrantsListed+1)
+	for i, grant := range list {
+		if i == maxGrantsListed {
+			lines = append(lines, localizer.T("grants.list.more", len(list)-maxGrantsListed))
+			break
+		}
+
+		lines = append(lines, localizer.T(
+			"grants.list.entry",
+			grant.UserId,
+			localizer.T("entitlement.type."+grant.Type),
+			grant.MaxGuilds,
+			grant.Reason,
+			grantExpiry(localizer, grant, now),
+		))
+	}
+
+	return ephemeralResponse(strings.Join(lines, "\n"))
+}
+
+// grantExpiry describes when the grant expires, or when it stopped being active
+func grantExpiry(localizer i18n.Localizer, grant grants.Grant, now time.Time) string {
+	switch {
+	case grant.RevokedAt != nil:
+		return localizer.T("grants.status.revoked", discordDate(*grant.RevokedAt))
+	case grant.ExpiresAt == nil:
+		return localizer.T("grants.status.never_expires")
+	case !grant.Active(now):
+		return localizer.T("grants.status.expired", discordDate(*grant.ExpiresAt))
+	default:
+		return localizer.T("grants.status.expires", discordDate(*grant.ExpiresAt))
+	}
+}
+
+func discordDate(t time.Time) string {
+	return fmt.Sprintf("<t:%d:D>", t.Unix())
+}
+
+// parseExpiry parses the expires option of /grant: either a number of days such as 30d, a duration such as 12h, or
+// a date in the form YYYY-MM-DD. An empty value means the grant never expires.
+func parseExpiry(now time.Time, value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var expiresAt time.Time
+	if strings.HasSuffix(value, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return nil, err
+		}
+
+		expiresAt = now.AddDate(0, 0, n)
+	} else if duration, err := time.ParseDuration(value); err == nil {
+		expiresAt = now.Add(duration)
+	} else {
+		expiresAt, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !expiresAt.After(now) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	return &expiresAt, nil
+}
+
+// grantEntitlement returns the entitlement from the user's active grant, or nil if they do not have one. Grants are
+// ranked alongside tiers with the highest priority configured for their type.
+func (s *Server) grantEntitlement(userId uint64) *entitlements.Entitlement {
+	grant, ok := s.grants.Active(time.Now(), userId)
+	if !ok {
+		return nil
+	}
+
+	return &entitlements.Entitlement{
+		Type:      grant.Type,
+		MaxGuilds: grant.MaxGuilds,
+		Priority:  s.entitlements.Priority(grant.Type),
+		Source:    entitlements.SourceGrant,
+		ExpiresAt: grant.ExpiresAt,
+	}
+}
+
+// activeGrant returns the user's active grant, or nil if they do not have one
+func (s *Server) activeGrant(userId uint64) *grants.Grant {
+	grant, ok := s.grants.Active(time.Now(), userId)
+	if !ok {
+		return nil
+	}
+
+	return &grant
+}
+
+// HandleGrants lists the active grants, or all grants with ?all=true.
+func (s *Server) HandleGrants(ctx *gin.Context) {
+	includeInactive, _ := strconv.ParseBool(ctx.Query("all"))
+
+	list := s.grants.List(time.Now(), includeInactive)
+	if list == nil {
+		list = []grants.Grant{}
+	}
+
+	ctx.JSON(http.StatusOK, list)
+}
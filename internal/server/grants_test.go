@@ -0,0 +1,203 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const grantsAdminRoleId = 600
+
+func grantCommand(id uint64, roles []uint64, name string, options ...servertest.Option) []byte {
+	return servertest.Command{
+		Id:      id,
+		GuildId: allowedGuildId,
+		UserId:  1,
+		Roles:   roles,
+		Name:    name,
+		Options: options,
+	}.Payload()
+}
+
+func userOption(name, id string) servertest.Option {
+	return servertest.Option{
+		Name:  name,
+		Type:  servertest.OptionTypeUser,
+		Value: id,
+	}
+}
+
+func TestGrants(t *testing.T) {
+	conf := entitlementConfig()
+	conf.Discord.AdminRoles = []uint64{grantsAdminRoleId}
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	admin := []uint64{grantsAdminRoleId}
+
+	steps := []struct {
+		name    string
+		roles   []uint64
+		command string
+		options []servertest.Option
+		want    string
+	}{
+		{
+			name:    "not admin",
+			command: "grant",
+			options: []servertest.Option{userOption("user", "777"), servertest.StringOption("type", "premium"), servertest.StringOption("reason", "contest")},
+			want:    "This command can only be used by admins",
+		},
+		{
+			name:    "invalid expiry",
+			roles:   admin,
+			command: "grant",
+			options: []servertest.Option{userOption("user", "777"), servertest.StringOption("type", "premium"), servertest.StringOption("reason", "contest"), servertest.StringOption("expires", "2000-01-01")},
+			want:    "Expires must be a duration",
+		},
+		{
+			name:    "grant",
+			roles:   admin,
+			command: "grant",
+			options: []servertest.Option{userOption("user", "777"), servertest.StringOption("type", "premium"), servertest.StringOption("reason", "contest"), servertest.StringOption("expires", "30d")},
+			want:    "Granted Premium for 1 server(s) to <@777>, expires <t:",
+		},
+		{
+			name:    "grant to patron",
+			roles:   admin,
+			command: "grant",
+			options: []servertest.Option{userOption("user", "12345"), servertest.StringOption("type", "premium"), servertest.StringOption("reason", "partner"), {Name: "max_guilds", Type: servertest.OptionTypeInteger, Value: 5}},
+			want:    "Granted Premium for 5 server(s) to <@12345>, never expires",
+		},
+		{
+			name:    "list",
+			roles:   admin,
+			command: "grants",
+			want:    "<@12345>: Premium, 5 server(s), partner (never expires)\n<@777>: Premium, 1 server(s), contest (expires <t:",
+		},
+	}
+
+	for i, step := range steps {
+		res := servertest.DecodeResponse(t, h.Do(grantCommand(uint64(i+1), step.roles, step.command, step.options...)))
+		if !strings.HasPrefix(res.Data.Content, step.want) {
+			t.Errorf("%s: expected %q, got %q", step.name, step.want, res.Data.Content)
+		}
+	}
+
+	type patron struct {
+		Id          uint64 `json:"id"`
+		Entitlement *struct {
+			MaxGuilds int    `json:"max_guilds"`
+			Source    string `json:"source"`
+		} `json:"entitlement"`
+		Grant *struct {
+			Reason string `json:"reason"`
+		} `json:"grant"`
+	}
+
+	getPatron := func(discordId string) (patron, int) {
+		t.Helper()
+
+		recorder := apiRequest(t, h, "/api/v1/patrons/discord/"+discordId, testApiKey)
+
+		var got patron
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return got, recorder.Code
+	}
+
+	// The grant has more guilds than the patron's tier, so it wins
+	if got, code := getPatron("12345"); code != http.StatusOK || got.Id != 1 || got.Entitlement == nil || got.Entitlement.Source != "grant" || got.Entitlement.MaxGuilds != 5 || got.Grant == nil || got.Grant.Reason != "partner" {
+		t.Errorf("unexpected patron %d %+v", code, got)
+	}
+
+	// Users with only a grant are still returned
+	if got, code := getPatron("777"); code != http.StatusOK || got.Id != 0 || got.Entitlement == nil || got.Entitlement.Source != "grant" {
+		t.Errorf("unexpected grant-only patron %d %+v", code, got)
+	}
+
+	mySubscription := servertest.DecodeResponse(t, h.Do(servertest.Command{Id: 99, GuildId: allowedGuildId, UserId: 777, Name: "mysubscription"}.Payload()))
+	if len(mySubscription.Data.Embeds) != 1 || mySubscription.Data.Embeds[0].Title != "Your Subscription" {
+		t.Errorf("expected grant-only user to see their subscription, got %+v", mySubscription.Data)
+	}
+
+	res := servertest.DecodeResponse(t, h.Do(premiumCommand(100, 777, "assign", "500")))
+	if !strings.HasPrefix(res.Data.Content, "Server `500` now has your premium (1 of 1 servers used)") {
+		t.Errorf("expected grant to allow assigning premium, got %q", res.Data.Content)
+	}
+
+	res = servertest.DecodeResponse(t, h.Do(grantCommand(101, admin, "revoke", userOption("user", "777"))))
+	if res.Data.Content != "Revoked <@777>'s Premium grant" {
+		t.Errorf("unexpected revoke response %q", res.Data.Content)
+	}
+
+	if _, code := getPatron("777"); code != http.StatusNotFound {
+		t.Errorf("expected revoked grant-only patron to be not found, got %d", code)
+	}
+
+	// Revoking releases the guilds assigned with the grant
+	if recorder := apiRequest(t, h, "/api/v1/guilds/500/premium", testApiKey); !strings.Contains(recorder.Body.String(), `"premium":false`) {
+		t.Errorf("expected guild to lose premium, got %s", recorder.Body.String())
+	}
+
+	res = servertest.DecodeResponse(t, h.Do(grantCommand(102, admin, "revoke", userOption("user", "777"))))
+	if res.Data.Content != "<@777> does not have an active grant" {
+		t.Errorf("unexpected revoke response %q", res.Data.Content)
+	}
+
+	var list []struct {
+		UserId    string  `json:"user_id"`
+		RevokedBy *string `json:"revoked_by"`
+	}
+
+	recorder := apiRequest(t, h, "/api/v1/grants?all=true", testApiKey)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[1].UserId != "777" || list[1].RevokedBy == nil || *list[1].RevokedBy != "1" {
+		t.Errorf("unexpected grants %s", recorder.Body.String())
+	}
+
+	recorder = apiRequest(t, h, "/api/v1/grants", testApiKey)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].UserId != "12345" {
+		t.Errorf("expected only the active grant, got %s", recorder.Body.String())
+	}
+}
+
+func TestLookupGrant(t *testing.T) {
+	conf := entitlementConfig()
+	conf.Discord.AdminRoles = []uint64{grantsAdminRoleId}
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	servertest.DecodeResponse(t, h.Do(grantCommand(2, []uint64{grantsAdminRoleId}, "grant",
+		userOption("user", "12345"),
+		servertest.StringOption("type", "premium"),
+		servertest.StringOption("reason", "partner"),
+		servertest.Option{Name: "max_guilds", Type: servertest.OptionTypeInteger, Value: 10},
+	)))
+
+	res := servertest.DecodeResponse(t, h.Do(lookup(allowedGuildId, "patron@example.com")))
+	if len(res.Data.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %+v", res.Data)
+	}
+
+	fields := res.Data.Embeds[0].Fields
+	last := fields[len(fields)-1]
+	if last.Name != "Entitlement" || last.Value != "Premium, 10 server(s), granted" {
+		t.Errorf("unexpected entitlement field %+v", last)
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/grants"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/pkg/subscriptionspb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"strings"
+	"time"
+)
+
+// grpcService implements the gRPC service from the same in-memory state as the HTTP API.
+type grpcService struct {
+	subscriptionspb.UnimplementedSubscriptionsServer
+	server *Server
+}
+
+// GrpcServer builds the gRPC server, with the service registered behind API key authentication. It can be used to
+// serve requests on any listener, e.g. in tests.
+func (s *Server) GrpcServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.authenticateUnary),
+		grpc.ChainStreamInterceptor(s.authenticateStream),
+	)
+
+	subscriptionspb.RegisterSubscriptionsServer(srv, &grpcService{server: s})
+	return srv
+}
+
+// RunGrpc serves the gRPC service on config.Grpc.Addr, and blocks until the context is cancelled. Open streams are
+// given up to config.ShutdownTimeout to finish before they are closed, although WatchChanges streams never finish by
+// themselves.
+func (s *Server) RunGrpc(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.Grpc.Addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for gRPC")
+	}
+
+	srv := s.GrpcServer()
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("Starting gRPC server", zap.String("addr", s.config.Grpc.Addr))
+		errCh <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("Shutting down gRPC server", zap.Duration("timeout", s.config.ShutdownTimeout.Duration()))
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(s.config.ShutdownTimeout.Duration()):
+		srv.Stop()
+	}
+
+	return nil
+}
+
+func (s *Server) authenticateUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authenticateGrpc(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Server) authenticateStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authenticateGrpc(stream.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// authenticateGrpc checks the bearer token in the authorization metadata against the configured API keys, like
+// AuthenticateApi.
+func (s *Server) authenticateGrpc(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "missing API key")
+	}
+
+	token := strings.TrimPrefix(values[0], "Bearer ")
+	if !strings.HasPrefix(values[0], "Bearer ") || token == "" {
+		return status.Error(codes.Unauthenticated, "missing API key")
+	}
+
+	if !s.validApiKey(token) {
+		return status.Error(codes.Unauthenticated, "invalid API key")
+	}
+
+	return nil
+}
+
+var errNotLoaded = status.Error(codes.Unavailable, "pledge data has not been loaded yet")
+
+func (g *grpcService) GetPatronByDiscordId(_ context.Context, req *subscriptionspb.GetPatronByDiscordIdRequest) (*subscriptionspb.Patron, error) {
+	if req.DiscordId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "discord_id is required")
+	}
+
+	if !g.server.directory.Loaded() {
+		return nil, errNotLoaded
+	}
+
+	patron, ok := g.server.directory.ByDiscordId(req.DiscordId)
+
+	// Users with a grant but no subscription are returned with only their Discord ID and grant
+	if !ok && g.server.activeGrant(req.DiscordId) != nil {
+		patron, ok = providers.Subscriber{DiscordId: &req.DiscordId}, true
+	}
+
+	if !ok {
+		return nil, status.Error(codes.NotFound, "patron not found")
+	}
+
+	return g.server.newPatronProto(patron), nil
+}
+
+func (g *grpcService) GetPatronByEmail(_ context.Context, req *subscriptionspb.GetPatronByEmailRequest) (*subscriptionspb.Patron, error) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if !g.server.directory.Loaded() {
+		return nil, errNotLoaded
+	}
+
+	patron, ok := g.server.directory.ByEmail(req.Email)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "patron not found")
+	}
+
+	return g.server.newPatronProto(patron), nil
+}
+
+func (g *grpcService) ListPatrons(req *subscriptionspb.ListPatronsRequest, stream subscriptionspb.Subscriptions_ListPatronsServer) error {
+	if !g.server.directory.Loaded() {
+		return errNotLoaded
+	}
+
+	for _, patron := range g.server.directory.All() {
+		if len(req.Providers) > 0 && !contains(req.Providers, patron.Provider) {
+			continue
+		}
+
+		if len(req.Statuses) > 0 && !containsFold(req.Statuses, patron.Status) {
+			continue
+		}
+
+		if err := stream.Send(g.server.newPatronProto(patron)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *grpcService) GetEntitlement(_ context.Context, req *subscriptionspb.GetEntitlementRequest) (*subscriptionspb.GetEntitlementResponse, error) {
+	if req.DiscordId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "discord_id is required")
+	}
+
+	entitlement, loaded := g.server.userEntitlement(req.DiscordId)
+	if !loaded {
+		return nil, errNotLoaded
+	}
+
+	return &subscriptionspb.GetEntitlementResponse{
+		Entitlement: entitlementProto(entitlement),
+	}, nil
+}
+
+func (g *grpcService) WatchChanges(req *subscriptionspb.WatchChangesRequest, stream subscriptionspb.Subscriptions_WatchChangesServer) error {
+	if g.server.broadcaster == nil {
+		return status.Error(codes.Unimplemented, "the gRPC server is not enabled")
+	}
+
+	subscription := g.server.broadcaster.Subscribe(g.server.config.Grpc.StreamBuffer)
+	defer g.server.broadcaster.Unsubscribe(subscription)
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case event, ok := <-subscription.Events:
+			// The channel is only closed here if the stream fell too far behind
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "stream fell too far behind")
+			}
+
+			message := changeEventProto(event)
+			if len(req.Types) > 0 && !contains(req.Types, message.Type) {
+				continue
+			}
+
+			if err := stream.Send(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newPatronProto converts the subscriber like newPatronResponse, except that the ID and status are the provider-neutral
+// ones, as the gRPC service has no older clients to stay compatible with.
+func (s *Server) newPatronProto(patron providers.Subscriber) *subscriptionspb.Patron {
+	res := s.newPatronResponse(patron)
+
+	return &subscriptionspb.Patron{
+		Provider:         patron.Provider,
+		Id:               patron.Id,
+		Email:            res.Email,
+		DiscordId:        res.DiscordId,
+		Status:           patron.Status,
+		LastChargeStatus: res.LastChargeStatus,
+		LastChargeDate:   timestampProto(res.LastChargeDate),
+		PledgeStart:      timestampProto(res.PledgeStart),
+		NextChargeDate:   timestampProto(res.NextChargeDate),
+		Tiers:            res.Tiers,
+		TierNames:        res.TierNames,
+		Entitlement:      entitlementProto(res.Entitlement),
+		Grant:            grantProto(res.Grant),
+	}
+}
+
+func subscriberProto(subscriber providers.Subscriber) *subscriptionspb.Subscriber {
+	return &subscriptionspb.Subscriber{
+		Provider:         subscriber.Provider,
+		Id:               subscriber.Id,
+		Email:            subscriber.Email,
+		DiscordId:        subscriber.DiscordId,
+		Status:           subscriber.Status,
+		LastChargeStatus: subscriber.LastChargeStatus,
+		LastChargeDate:   timestampProto(nonZeroTime(subscriber.LastChargeDate)),
+		StartedAt:        timestampProto(nonZeroTime(subscriber.StartedAt)),
+		NextChargeDate:   timestampProto(nonZeroTime(subscriber.NextChargeDate)),
+		AmountCents:      int64(subscriber.AmountCents),
+		Tiers:            subscriber.Tiers,
+	}
+}
+
+func entitlementProto(entitlement *entitlements.Entitlement) *subscriptionspb.Entitlement {
+	if entitlement == nil {
+		return nil
+	}
+
+	return &subscriptionspb.Entitlement{
+		Type:          entitlement.Type,
+		MaxGuilds:     int32(entitlement.MaxGuilds),
+		Priority:      int32(entitlement.Priority),
+		LegacyPricing: entitlement.LegacyPricing,
+		Source:        entitlement.Source,
+		TierId:        entitlement.TierId,
+		ExpiresAt:     timestampProto(entitlement.ExpiresAt),
+		GraceUntil:    timestampProto(entitlement.GraceUntil),
+	}
+}
+
+func grantProto(grant *grants.Grant) *subscriptionspb.Grant {
+	if grant == nil {
+		return nil
+	}
+
+	return &subscriptionspb.Grant{
+		UserId:    grant.UserId,
+		Type:      grant.Type,
+		MaxGuilds: int32(grant.MaxGuilds),
+		Reason:    grant.Reason,
+		GrantedBy: grant.GrantedBy,
+		GrantedAt: timestamppb.New(grant.GrantedAt),
+		ExpiresAt: timestampProto(grant.ExpiresAt),
+	}
+}
+
+var changeEventTypes = map[string]subscriptionspb.ChangeEvent_Type{
+	changes.TypeCreated:            subscriptionspb.ChangeEvent_TYPE_CREATED,
+	changes.TypeUpdated:            subscriptionspb.ChangeEvent_TYPE_UPDATED,
+	changes.TypeDeleted:            subscriptionspb.ChangeEvent_TYPE_DELETED,
+	changes.TypeEntitlementChanged: subscriptionspb.ChangeEvent_TYPE_ENTITLEMENT_CHANGED,
+}
+
+func changeEventProto(event changes.Event) *subscriptionspb.ChangeEvent {
+	message := &subscriptionspb.ChangeEvent{
+		Type:        changeEventTypes[event.Type],
+		Time:        timestamppb.New(event.Time),
+		DiscordId:   event.DiscordId,
+		Entitlement: entitlementProto(event.Entitlement),
+	}
+
+	if event.Subscriber != nil {
+		message.Subscriber = subscriberProto(*event.Subscriber)
+	}
+
+	return message
+}
+
+func timestampProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
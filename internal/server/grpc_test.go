@@ -0,0 +1,208 @@
+package server_test
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/TicketsBot/subscriptions-app/pkg/subscriptionspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// grpcClient serves the harness's gRPC server on a local port, and returns a client connected to it
+func grpcClient(t *testing.T, h *servertest.Harness) subscriptionspb.SubscriptionsClient {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := h.Server.GrpcServer()
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return subscriptionspb.NewSubscriptionsClient(conn)
+}
+
+func grpcContext(t *testing.T, key string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+key)
+}
+
+func grpcConfig() config.Config {
+	conf := entitlementConfig()
+	conf.Grpc.Addr = "127.0.0.1:0"
+	conf.Grpc.StreamBuffer = 100
+
+	return conf
+}
+
+func TestGrpcAuthentication(t *testing.T) {
+	h := servertest.NewHarness(t, grpcConfig())
+	h.SetPledges(testPledges())
+	client := grpcClient(t, h)
+
+	req := &subscriptionspb.GetEntitlementRequest{DiscordId: 12345}
+	if _, err := client.GetEntitlement(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a key, got %v", err)
+	}
+
+	if _, err := client.GetEntitlement(grpcContext(t, "wrong"), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with an invalid key, got %v", err)
+	}
+
+	// Streams are authenticated before the handler runs
+	stream, err := client.ListPatrons(grpcContext(t, "wrong"), &subscriptionspb.ListPatronsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a stream with an invalid key, got %v", err)
+	}
+
+	if _, err := client.GetEntitlement(grpcContext(t, testApiKey), req); err != nil {
+		t.Errorf("expected a valid key to be accepted, got %v", err)
+	}
+}
+
+func TestGrpcLookups(t *testing.T) {
+	h := servertest.NewHarness(t, grpcConfig())
+	client := grpcClient(t, h)
+	ctx := grpcContext(t, testApiKey)
+
+	if _, err := client.GetPatronByDiscordId(ctx, &subscriptionspb.GetPatronByDiscordIdRequest{DiscordId: 12345}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable before pledges are loaded, got %v", err)
+	}
+
+	h.SetPledges(exportPledges())
+
+	patron, err := client.GetPatronByDiscordId(ctx, &subscriptionspb.GetPatronByDiscordIdRequest{DiscordId: 12345})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patron.Email != "patron@example.com" || patron.GetDiscordId() != 12345 || patron.Entitlement.GetType() != "premium" ||
+		patron.Entitlement.GetMaxGuilds() != 3 || !patron.LastChargeDate.AsTime().Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected patron %v", patron)
+	}
+
+	patron, err = client.GetPatronByEmail(ctx, &subscriptionspb.GetPatronByEmailRequest{Email: "declined@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patron.Id != "2" || patron.DiscordId != nil || patron.Entitlement != nil {
+		t.Errorf("unexpected patron %v", patron)
+	}
+
+	if _, err := client.GetPatronByEmail(ctx, &subscriptionspb.GetPatronByEmailRequest{Email: "unknown@example.com"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	if _, err := client.GetPatronByDiscordId(ctx, &subscriptionspb.GetPatronByDiscordIdRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without a Discord ID, got %v", err)
+	}
+
+	res, err := client.GetEntitlement(ctx, &subscriptionspb.GetEntitlementRequest{DiscordId: 12345})
+	if err != nil || res.Entitlement.GetType() != "premium" || res.Entitlement.GetSource() != "patreon" {
+		t.Errorf("unexpected entitlement %v, %v", res, err)
+	}
+
+	res, err = client.GetEntitlement(ctx, &subscriptionspb.GetEntitlementRequest{DiscordId: 999})
+	if err != nil || res.Entitlement != nil {
+		t.Errorf("expected no entitlement, got %v, %v", res, err)
+	}
+
+	for _, test := range []struct {
+		statuses []string
+		want     []string
+	}{
+		{nil, []string{"1", "2"}},
+		{[]string{"declined"}, []string{"2"}},
+	} {
+		stream, err := client.ListPatrons(ctx, &subscriptionspb.ListPatronsRequest{Statuses: test.statuses})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for {
+			patron, err := stream.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			ids = append(ids, patron.Id)
+		}
+
+		if len(ids) != len(test.want) || (len(ids) > 0 && ids[0] != test.want[0]) {
+			t.Errorf("statuses %v: expected %v, got %v", test.statuses, test.want, ids)
+		}
+	}
+}
+
+func TestGrpcWatchChanges(t *testing.T) {
+	h := servertest.NewHarness(t, grpcConfig())
+	h.SetPledges(testPledges())
+	client := grpcClient(t, h)
+
+	stream, err := client.WatchChanges(grpcContext(t, testApiKey), &subscriptionspb.WatchChangesRequest{
+		Types: []subscriptionspb.ChangeEvent_Type{subscriptionspb.ChangeEvent_TYPE_ENTITLEMENT_CHANGED},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the stream is watching, so that the update is not missed
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	discordId := uint64(777)
+	pledges := testPledges()
+	pledges["new@example.com"] = patreon.Patron{
+		Attributes: patreon.Attributes{
+			Email:            "new@example.com",
+			LastChargeStatus: "Paid",
+			PatronStatus:     "active_patron",
+		},
+		Id:        2,
+		Tiers:     []uint64{1},
+		DiscordId: &discordId,
+	}
+
+	h.SetPledges(pledges)
+
+	// The created event is filtered out
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Type != subscriptionspb.ChangeEvent_TYPE_ENTITLEMENT_CHANGED || event.DiscordId != discordId || event.Entitlement.GetType() != "premium" {
+		t.Errorf("unexpected event %v", event)
+	}
+}
@@ -2,17 +2,15 @@ package server
 
 import (
 	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/commands"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/pkg/errors"
-	"github.com/rxdn/gdl/objects/channel/embed"
-	"github.com/rxdn/gdl/objects/channel/message"
 	"github.com/rxdn/gdl/objects/interaction"
-	"github.com/rxdn/gdl/objects/user"
+	"github.com/rxdn/gdl/rest/request"
 	"go.uber.org/zap"
 	"net/http"
-	"strings"
-	"time"
 )
 
 func (s *Server) HandleInteraction(ctx *gin.Context) {
@@ -32,147 +30,43 @@ func (s *Server) HandleInteraction(ctx *gin.Context) {
 			return
 		}
 
-		res := handleCommand(s, commandData)
-		ctx.JSON(http.StatusOK, res)
+		res := handleCommand(s, applicationFromContext(ctx), commandData)
+		if len(res.Files) == 0 {
+			ctx.JSON(http.StatusOK, res.ResponseChannelMessage)
+			return
+		}
+
+		body, boundary, err := request.EncodeMultipartFormData(res.multipartPayload())
+		if err != nil {
+			_ = ctx.Error(errors.Wrap(err, "Failed to encode multipart response"))
+			return
+		}
+
+		ctx.Data(http.StatusOK, fmt.Sprintf("%s; boundary=%s", request.MultipartFormData, boundary), body)
 	default:
 		_ = ctx.Error(fmt.Errorf("interaction type %d not implemented", body.Type))
 	}
 }
 
-const (
-	red  = 0xeb4034
-	blue = 0x4287f5
-)
-
-func handleCommand(s *Server, data interaction.ApplicationCommandInteraction) interaction.ResponseChannelMessage {
+func handleCommand(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
 	command := data.Data
+	localizer := s.localizer(data)
 
-	if !contains(s.config.Discord.AllowedGuilds, data.GuildId.Value) {
-		return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-			Content: "This guild is not in the allowed guilds list",
-			Flags:   uint(message.FlagEphemeral),
-		})
+	// Staff commands can only be used in the allowed guilds, while user facing commands can be used anywhere
+	if cmd, ok := commands.Find(command.Name); !(ok && cmd.UserFacing) && !contains(app.AllowedGuilds, data.GuildId.Value) {
+		return ephemeralResponse(localizer.T("errors.guild_not_allowed"))
 	}
 
-	switch command.Name {
-	case "lookup":
-		if len(command.Options) == 0 || command.Options[0].Name != "email" {
-			return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-				Content: "Missing email",
-				Flags:   uint(message.FlagEphemeral),
-			})
-		}
-
-		email, ok := command.Options[0].Value.(string)
-		if !ok {
-			return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-				Content: "Email was wrong type",
-				Flags:   uint(message.FlagEphemeral),
-			})
-		}
-
-		s.mu.RLock()
-		hasInitialData := s.pledges != nil
-		patron, ok := s.pledges[email]
-		s.mu.RUnlock()
-
-		if !hasInitialData {
-			return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-				Content: "Initial data not loaded yet, please try again in a few minutes",
-				Flags:   uint(message.FlagEphemeral),
-			})
-		}
-
-		var user user.User
-		if data.Member != nil {
-			user = data.Member.User
-		} else if data.User != nil {
-			user = *data.User
-		} // Other should be infallible
-
-		if ok {
-			tiers := make([]string, len(patron.Tiers))
-			for i, tier := range patron.Tiers {
-				tierName, ok := s.config.Tiers[tier]
-				if !ok {
-					tierName = fmt.Sprintf("Unknown (ID: %d)", tier)
-				}
-
-				tiers[i] = tierName
-			}
-
-			discord := "Not linked"
-			if patron.DiscordId != nil {
-				discord = fmt.Sprintf("<@%d> (%d)", *patron.DiscordId, *patron.DiscordId)
-			}
-
-			return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-				Embeds: []*embed.Embed{
-					{
-						Title:     "Account Found",
-						Url:       fmt.Sprintf("https://www.patreon.com/user?u=%d", patron.Id),
-						Timestamp: ptr(time.Now()),
-						Color:     blue,
-						Author: &embed.EmbedAuthor{
-							Name:    user.Username,
-							IconUrl: user.AvatarUrl(256),
-						},
-						Fields: []*embed.EmbedField{
-							{
-								Name:   "Status",
-								Value:  patron.Attributes.PatronStatus,
-								Inline: true,
-							},
-							{
-								Name:   "Last Charge Status",
-								Value:  patron.Attributes.LastChargeStatus,
-								Inline: true,
-							},
-							{
-								Name:   "Last Charge Date",
-								Value:  fmt.Sprintf("<t:%d>", patron.Attributes.LastChargeDate.Unix()),
-								Inline: true,
-							},
-							{
-								Name:   "Join Date",
-								Value:  fmt.Sprintf("<t:%d>", patron.Attributes.PledgeRelationshipStart.Unix()),
-								Inline: true,
-							},
-							{
-								Name:   "Active Tiers",
-								Value:  strings.Join(tiers, ", "),
-								Inline: true,
-							},
-							{
-								Name:   "Discord Account",
-								Value:  discord,
-								Inline: true,
-							},
-						},
-					},
-				},
-			})
-		} else {
-			return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-				Embeds: []*embed.Embed{
-					{
-						Title:       "Account Not Found",
-						Description: fmt.Sprintf("No Patreon account with email `%s` found", email),
-						Timestamp:   ptr(time.Now()),
-						Color:       red,
-						Author: &embed.EmbedAuthor{
-							Name:    user.Username,
-							IconUrl: user.AvatarUrl(256),
-						},
-					},
-				},
-			})
-		}
-	default:
+	handler, ok := commandHandlers[command.Name]
+	if !ok {
 		s.logger.Warn("Unknown command", zap.String("command", command.Name))
-		return interaction.NewResponseChannelMessage(interaction.ApplicationCommandCallbackData{
-			Content: "Unknown command",
-			Flags:   uint(message.FlagEphemeral),
-		})
+		return ephemeralResponse(localizer.T("errors.unknown_command"))
 	}
+
+	return handler(s, app, data)
+}
+
+// localizer picks the user's locale for responses, falling back to the guild's locale
+func (s *Server) localizer(data interaction.ApplicationCommandInteraction) i18n.Localizer {
+	return s.i18n.Localizer(data.Locale, data.GuildLocale)
 }
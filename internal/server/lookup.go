@@ -0,0 +1,132 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/privacy"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/objects/user"
+	"go.uber.org/zap"
+	"time"
+)
+
+func handleLookup(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	command := data.Data
+	localizer := s.localizer(data)
+
+	emailOption, ok := findOption(command.Options, "email")
+	if !ok {
+		return ephemeralResponse(localizer.T("lookup.missing_email"))
+	}
+
+	email, ok := emailOption.Value.(string)
+	if !ok {
+		return ephemeralResponse(localizer.T("lookup.email_wrong_type"))
+	}
+
+	// Privileged users may override the privacy policy for a single lookup
+	public := s.config.Privacy.PublicLookups
+	showEmail := s.config.Privacy.ShowEmails
+	if boolOption(command.Options, "public") || boolOption(command.Options, "show_email") {
+		if !s.isPrivileged(data) {
+			return ephemeralResponse(localizer.T("lookup.override_not_allowed"))
+		}
+
+		public = public || boolOption(command.Options, "public")
+		showEmail = showEmail || boolOption(command.Options, "show_email")
+	}
+
+	patron, ok := s.directory.ByEmail(email)
+	if !s.directory.Loaded() {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	var user user.User
+	if data.Member != nil {
+		user = data.Member.User
+	} else if data.User != nil {
+		user = *data.User
+	} // Other should be infallible
+
+	embedData := embeds.NewData(localizer)
+	embedData.Query = email
+
+	templateName := embeds.NotFound
+	if ok {
+		templateName = embeds.Lookup
+		embedData.Patron = &patron
+		embedData.TierNames = s.tierNames(localizer, patron.Tiers)
+		embedData.Entitlement = s.resolveEntitlement(patron)
+		embedData.Subscriptions = s.otherSubscriptions(localizer, patron)
+	}
+
+	if !showEmail {
+		embedData.Query = privacy.MaskEmail(email)
+		patron.Email = privacy.MaskEmail(patron.Email)
+
+		for i := range embedData.Subscriptions {
+			embedData.Subscriptions[i].Email = privacy.MaskEmail(embedData.Subscriptions[i].Email)
+		}
+	}
+
+	e, err := s.embeds.Render(templateName, embedData)
+	if err != nil {
+		s.logger.Error("Failed to render embed", zap.String("template", templateName), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	e.Timestamp = ptr(time.Now())
+	e.Author = &embed.EmbedAuthor{
+		Name:    user.Username,
+		IconUrl: user.AvatarUrl(256),
+	}
+
+	var flags uint
+	if !public {
+		flags = uint(message.FlagEphemeral)
+	}
+
+	return messageResponse(interaction.ApplicationCommandCallbackData{
+		Embeds: []*embed.Embed{e},
+		Flags:  flags,
+	})
+}
+
+// otherSubscriptions returns the subscriptions linked to the same Discord account as the subscriber, other than the
+// subscriber itself
+func (s *Server) otherSubscriptions(localizer i18n.Localizer, patron providers.Subscriber) []embeds.Subscription {
+	if patron.DiscordId == nil {
+		return nil
+	}
+
+	var subscriptions []embeds.Subscription
+	for _, subscription := range s.directory.ForDiscordId(*patron.DiscordId) {
+		if subscription.Key() == patron.Key() {
+			continue
+		}
+
+		subscriptions = append(subscriptions, embeds.Subscription{
+			Subscriber: subscription,
+			TierNames:  s.tierNames(localizer, subscription.Tiers),
+		})
+	}
+
+	return subscriptions
+}
+
+func (s *Server) tierNames(localizer i18n.Localizer, tiers []uint64) []string {
+	names := make([]string, len(tiers))
+	for i, tier := range tiers {
+		tierName, ok := s.config.Tiers[tier]
+		if !ok {
+			tierName = localizer.T("lookup.unknown_tier", tier)
+		}
+
+		names[i] = tierName
+	}
+
+	return names
+}
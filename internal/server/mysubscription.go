@@ -0,0 +1,54 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/privacy"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"go.uber.org/zap"
+	"time"
+)
+
+// handleMySubscription lets a subscriber check their own subscription, by resolving their Discord account against the
+// accounts linked with each provider. Responses are always ephemeral.
+func handleMySubscription(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	localizer := s.localizer(data)
+
+	userId := interactionUserId(data)
+
+	patron, ok := s.directory.ByDiscordId(userId)
+	if !s.directory.Loaded() {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	// Users with a grant but no linked subscription still have a subscription to show
+	if !ok && s.activeGrant(userId) != nil {
+		patron, ok = providers.Subscriber{DiscordId: &userId}, true
+	}
+
+	embedData := embeds.NewData(localizer)
+
+	templateName := embeds.MySubscriptionNotFound
+	if ok {
+		templateName = embeds.MySubscription
+		patron.Email = privacy.MaskEmail(patron.Email)
+		embedData.Patron = &patron
+		embedData.TierNames = s.tierNames(localizer, patron.Tiers)
+		embedData.Entitlement = s.resolveEntitlement(patron)
+	}
+
+	e, err := s.embeds.Render(templateName, embedData)
+	if err != nil {
+		s.logger.Error("Failed to render embed", zap.String("template", templateName), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	e.Timestamp = ptr(time.Now())
+
+	return messageResponse(interaction.ApplicationCommandCallbackData{
+		Embeds: []*embed.Embed{e},
+		Flags:  uint(message.FlagEphemeral),
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/outbox"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// webhookEndpointResponse describes a webhook endpoint, without its secret
+type webhookEndpointResponse struct {
+	Name   string   `json:"name"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	outbox.EndpointStats
+	Pending int `json:"pending"`
+	Failed  int `json:"failed"`
+}
+
+// HandleWebhookEndpoints lists the webhook endpoints, and a summary of the deliveries to each.
+func (s *Server) HandleWebhookEndpoints(ctx *gin.Context) {
+	if s.outbox == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("No webhook endpoints are configured"))
+		return
+	}
+
+	endpoints := s.outbox.Endpoints()
+	res := make([]webhookEndpointResponse, len(endpoints))
+	for i, endpoint := range endpoints {
+		events := endpoint.Events
+		if events == nil {
+			events = []string{}
+		}
+
+		stats, pending, failed := s.outbox.Stats(endpoint.Name)
+		res[i] = webhookEndpointResponse{
+			Name:          endpoint.Name,
+			Url:           endpoint.Url,
+			Events:        events,
+			EndpointStats: stats,
+			Pending:       pending,
+			Failed:        failed,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// HandleWebhookDeliveries lists the queued deliveries, optionally filtered with ?status=pending or ?status=failed.
+func (s *Server) HandleWebhookDeliveries(ctx *gin.Context) {
+	if s.outbox == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("No webhook endpoints are configured"))
+		return
+	}
+
+	status := ctx.Query("status")
+	if status != "" && status != outbox.StatusPending && status != outbox.StatusFailed {
+		ctx.JSON(http.StatusBadRequest, errorJson("status must be pending or failed"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"deliveries": s.outbox.Deliveries(status),
+	})
+}
+
+// HandleRetryWebhookDelivery requeues a failed delivery.
+func (s *Server) HandleRetryWebhookDelivery(ctx *gin.Context) {
+	if s.outbox == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("No webhook endpoints are configured"))
+		return
+	}
+
+	if !s.outbox.Retry(ctx.Param("id"), time.Now()) {
+		ctx.JSON(http.StatusNotFound, errorJson("Failed delivery not found"))
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
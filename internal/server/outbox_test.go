@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/outbox"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	var mu sync.Mutex
+	var received []outbox.Payload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var payload outbox.Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+
+	conf := entitlementConfig()
+	conf.Webhooks.Endpoints = []config.WebhookEndpoint{{Name: "bot", Url: receiver.URL, Secret: "secret"}}
+	conf.Webhooks.MaxPending = 100
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	// The first update is the baseline, so nothing is queued for it
+	recorder := apiRequest(t, h, "/api/v1/webhooks/deliveries", testApiKey)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `{"deliveries":[]}` {
+		t.Fatalf("expected no deliveries, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	discordId := uint64(777)
+	pledges := testPledges()
+	pledges["new@example.com"] = patreon.Patron{
+		Attributes: patreon.Attributes{
+			Email:            "new@example.com",
+			LastChargeStatus: "Paid",
+			PatronStatus:     "active_patron",
+		},
+		Id:        2,
+		Tiers:     []uint64{1},
+		DiscordId: &discordId,
+	}
+
+	h.SetPledges(pledges)
+
+	var deliveries struct {
+		Deliveries []outbox.Delivery `json:"deliveries"`
+	}
+
+	recorder = apiRequest(t, h, "/api/v1/webhooks/deliveries?status=pending", testApiKey)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &deliveries); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries.Deliveries) != 2 || deliveries.Deliveries[0].EventType != "created" || deliveries.Deliveries[1].EventType != "entitlement_changed" {
+		t.Fatalf("expected the new patron and their entitlement to be queued, got %+v", deliveries.Deliveries)
+	}
+
+	h.Server.Outbox().DeliverDue(context.Background())
+
+	mu.Lock()
+	if len(received) != 2 || received[0].Subscriber == nil || received[0].Subscriber.Key() != "patreon:2" ||
+		received[1].DiscordId != discordId || received[1].Entitlement == nil || received[1].Entitlement.Type != "premium" {
+		t.Errorf("unexpected payloads %+v", received)
+	}
+	mu.Unlock()
+
+	var endpoints []struct {
+		Name      string `json:"name"`
+		Secret    string `json:"secret"`
+		Delivered int    `json:"delivered"`
+		Pending   int    `json:"pending"`
+	}
+
+	recorder = apiRequest(t, h, "/api/v1/webhooks", testApiKey)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &endpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(endpoints) != 1 || endpoints[0].Name != "bot" || endpoints[0].Secret != "" || endpoints[0].Delivered != 2 || endpoints[0].Pending != 0 {
+		t.Errorf("unexpected endpoints %+v", endpoints)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/webhooks/deliveries?status=delivered", testApiKey); recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown status, got %d", recorder.Code)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/webhooks/deliveries/unknown/retry", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+testApiKey)
+	if recorder := h.DoRequest(req); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 when retrying an unknown delivery, got %d", recorder.Code)
+	}
+}
+
+func TestOutboxDisabled(t *testing.T) {
+	h := servertest.NewHarness(t, entitlementConfig())
+
+	if recorder := apiRequest(t, h, "/api/v1/webhooks", testApiKey); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/grants"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// patronResponse is the representation of a subscriber returned by the API
+type patronResponse struct {
+	Id               uint64                    `json:"id"` // The Patreon ID, or 0 for subscribers with other providers
+	Email            string                    `json:"email"`
+	DiscordId        *uint64                   `json:"discord_id,string"`
+	Status           string                    `json:"status"`
+	LastChargeStatus string                    `json:"last_charge_status"`
+	LastChargeDate   *time.Time                `json:"last_charge_date"`
+	PledgeStart      *time.Time                `json:"pledge_start"`
+	NextChargeDate   *time.Time                `json:"next_charge_date"`
+	Tiers            []uint64                  `json:"tiers"`
+	TierNames        []string                  `json:"tier_names"`
+	Entitlement      *entitlements.Entitlement `json:"entitlement"`
+	Grant            *grants.Grant             `json:"grant"`
+	Provider         string                    `json:"provider"`
+	SubscriberId     string                    `json:"subscriber_id"` // The ID with the provider, which is set for every provider
+}
+
+func (s *Server) newPatronResponse(patron providers.Subscriber) patronResponse {
+	tiers := patron.Tiers
+	if tiers == nil {
+		tiers = []uint64{}
+	}
+
+	var grant *grants.Grant
+	if patron.DiscordId != nil {
+		grant = s.activeGrant(*patron.DiscordId)
+	}
+
+	return patronResponse{
+		Id:               patron.PatronId(),
+		Email:            patron.Email,
+		DiscordId:        patron.DiscordId,
+		Status:           patron.PatronStatus(),
+		LastChargeStatus: patron.LastChargeStatus,
+		LastChargeDate:   nonZeroTime(patron.LastChargeDate),
+		PledgeStart:      nonZeroTime(patron.StartedAt),
+		NextChargeDate:   nonZeroTime(patron.NextChargeDate),
+		Tiers:            tiers,
+		TierNames:        s.config.TierNames(tiers),
+		Entitlement:      s.resolveEntitlement(patron),
+		Grant:            grant,
+		Provider:         patron.Provider,
+		SubscriberId:     patron.Id,
+	}
+}
+
+// resolveEntitlement returns the subscriber's effective entitlement, or nil if they are not entitled to anything. If
+// the subscriber's Discord account has an active grant, or subscriptions with other providers, the best is used.
+func (s *Server) resolveEntitlement(patron providers.Subscriber) *entitlements.Entitlement {
+	subscriptions := []providers.Subscriber{patron}
+
+	var best *entitlements.Entitlement
+	if patron.DiscordId != nil {
+		best = s.grantEntitlement(*patron.DiscordId)
+		subscriptions = append(subscriptions, s.directory.ForDiscordId(*patron.DiscordId)...)
+	}
+
+	return s.bestEntitlement(best, subscriptions)
+}
+
+// bestEntitlement returns the better of the granted entitlement, which may be nil, and those of the subscriptions.
+func (s *Server) bestEntitlement(best *entitlements.Entitlement, subscriptions []providers.Subscriber) *entitlements.Entitlement {
+	for _, subscription := range subscriptions {
+		entitlement, ok := s.subscriptionEntitlement(subscription)
+		if ok && (best == nil || entitlements.Better(entitlement, *best)) {
+			best = &entitlement
+		}
+	}
+
+	return best
+}
+
+// subscriptionEntitlement resolves the entitlement from the subscriber's tiers, or from the tiers they had while active
+// if their subscription has lapsed within the grace period
+func (s *Server) subscriptionEntitlement(patron providers.Subscriber) (entitlements.Entitlement, bool) {
+	if s.grace != nil {
+		if window, ok := s.grace.Window(time.Now(), patron); ok {
+			if entitlement, ok := s.entitlements.Resolve(providers.Subscriber{Provider: patron.Provider, Tiers: window.Tiers}); ok {
+				entitlement.GraceUntil = &window.Until
+				return entitlement, true
+			}
+		}
+	}
+
+	return s.entitlements.Resolve(patron)
+}
+
+func (s *Server) HandlePatronByDiscordId(ctx *gin.Context) {
+	discordId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJson("Invalid Discord ID"))
+		return
+	}
+
+	patron, ok := s.directory.ByDiscordId(discordId)
+
+	// Users with a grant but no subscription are returned with only their Discord ID and grant
+	if !ok && s.activeGrant(discordId) != nil {
+		patron, ok = providers.Subscriber{DiscordId: &discordId}, true
+	}
+
+	s.writePatron(ctx, patron, ok)
+}
+
+func (s *Server) HandlePatronByEmail(ctx *gin.Context) {
+	patron, ok := s.directory.ByEmail(ctx.Param("email"))
+	s.writePatron(ctx, patron, ok)
+}
+
+func (s *Server) writePatron(ctx *gin.Context, patron providers.Subscriber, found bool) {
+	if !s.directory.Loaded() {
+		ctx.JSON(http.StatusServiceUnavailable, errorJson("Pledge data has not been loaded yet"))
+		return
+	}
+
+	if !found {
+		ctx.JSON(http.StatusNotFound, errorJson("Patron not found"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, s.newPatronResponse(patron))
+}
+
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,156 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"testing"
+)
+
+func entitlementConfig() config.Config {
+	conf := testConfig()
+	conf.Api.Keys = []string{testApiKey}
+	conf.Entitlements = []config.TierEntitlement{
+		{TierId: 1, Type: "premium", MaxGuilds: 3, Priority: 1, LegacyPricing: true},
+	}
+
+	return conf
+}
+
+func TestPatronsApi(t *testing.T) {
+	h := servertest.NewHarness(t, entitlementConfig())
+	h.SetPledges(exportPledges())
+
+	type entitlement struct {
+		Type          string `json:"type"`
+		MaxGuilds     int    `json:"max_guilds"`
+		LegacyPricing bool   `json:"legacy_pricing"`
+		Source        string `json:"source"`
+		TierId        uint64 `json:"tier_id"`
+	}
+
+	type patron struct {
+		Id          uint64       `json:"id"`
+		Email       string       `json:"email"`
+		DiscordId   *string      `json:"discord_id"`
+		Status      string       `json:"status"`
+		TierNames   []string     `json:"tier_names"`
+		Entitlement *entitlement `json:"entitlement"`
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       *patron
+	}{
+		{
+			name:       "by Discord ID",
+			path:       "/api/v1/patrons/discord/12345",
+			wantStatus: http.StatusOK,
+			want: &patron{
+				Id:          1,
+				Email:       "patron@example.com",
+				DiscordId:   ptr("12345"),
+				Status:      "active_patron",
+				TierNames:   []string{"Premium"},
+				Entitlement: &entitlement{Type: "premium", MaxGuilds: 3, LegacyPricing: true, Source: "patreon", TierId: 1},
+			},
+		},
+		{
+			name:       "by email without entitlement",
+			path:       "/api/v1/patrons/email/declined@example.com",
+			wantStatus: http.StatusOK,
+			want: &patron{
+				Id:        2,
+				Email:     "declined@example.com",
+				Status:    "declined_patron",
+				TierNames: []string{"2"},
+			},
+		},
+		{
+			name:       "not found",
+			path:       "/api/v1/patrons/discord/1",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid ID",
+			path:       "/api/v1/patrons/discord/abc",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := apiRequest(t, h, tc.path, testApiKey)
+			if recorder.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantStatus, recorder.Code, recorder.Body.String())
+			}
+
+			if tc.want == nil {
+				return
+			}
+
+			var got patron
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			gotJson, _ := json.Marshal(got)
+			wantJson, _ := json.Marshal(tc.want)
+			if string(gotJson) != string(wantJson) {
+				t.Errorf("expected %s, got %s", wantJson, gotJson)
+			}
+		})
+	}
+}
+
+func TestLookupEntitlement(t *testing.T) {
+	h := servertest.NewHarness(t, entitlementConfig())
+	h.SetPledges(testPledges())
+
+	res := servertest.DecodeResponse(t, h.Do(lookup(allowedGuildId, "patron@example.com")))
+	if len(res.Data.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %+v", res.Data)
+	}
+
+	fields := res.Data.Embeds[0].Fields
+	last := fields[len(fields)-1]
+	if last.Name != "Entitlement" || last.Value != "Premium, 3 server(s), legacy pricing" {
+		t.Errorf("unexpected entitlement field %+v", last)
+	}
+}
+
+func TestLookupMasksOtherSubscriptions(t *testing.T) {
+	conf := testConfig()
+	conf.Embeds = map[string]config.EmbedTemplate{
+		"lookup": {
+			Title:  "Found",
+			Fields: []config.EmbedFieldTemplate{{Name: "Emails", Value: `{{ .Patron.Email }} {{ range .Subscriptions }}{{ .Email }}{{ end }}`}},
+		},
+	}
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+	h.Server.ApplyUpdate(providers.Update{
+		Provider: "kofi",
+		Subscribers: []providers.Subscriber{
+			{Provider: "kofi", Id: "other@example.com", Email: "other@example.com", DiscordId: ptr(uint64(12345)), Status: providers.StatusActive},
+		},
+	})
+
+	res := servertest.DecodeResponse(t, h.Do(lookup(allowedGuildId, "patron@example.com")))
+	if len(res.Data.Embeds) != 1 || len(res.Data.Embeds[0].Fields) != 1 {
+		t.Fatalf("expected 1 embed with 1 field, got %+v", res.Data)
+	}
+
+	if got := res.Data.Embeds[0].Fields[0].Value; got != "p***@example.com o***@example.com" {
+		t.Errorf("expected every email to be masked, got %q", got)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,27 @@
+package server
+
+import "github.com/rxdn/gdl/objects/interaction"
+
+// isPrivileged returns true if the user running the command has one of the privileged roles
+func (s *Server) isPrivileged(data interaction.ApplicationCommandInteraction) bool {
+	return hasAnyRole(data, s.config.Privacy.PrivilegedRoles)
+}
+
+// isAdmin returns true if the user running the command has one of the admin roles
+func (s *Server) isAdmin(data interaction.ApplicationCommandInteraction) bool {
+	return hasAnyRole(data, s.config.Discord.AdminRoles)
+}
+
+func hasAnyRole(data interaction.ApplicationCommandInteraction, roles []uint64) bool {
+	if data.Member == nil {
+		return false
+	}
+
+	for _, roleId := range roles {
+		if data.Member.HasRole(roleId) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/premium"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rxdn/gdl/objects/interaction"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handlePremium lets a patron choose which guilds receive their premium, up to the number of guilds their
+// entitlement allows. Responses are always ephemeral.
+func handlePremium(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	localizer := s.localizer(data)
+
+	if len(data.Data.Options) == 0 {
+		return ephemeralResponse(localizer.T("errors.unknown_command"))
+	}
+
+	subCommand := data.Data.Options[0]
+	userId := interactionUserId(data)
+
+	switch subCommand.Name {
+	case "assign":
+		return s.assignPremium(localizer, userId, subCommand.Options)
+	case "unassign":
+		return s.unassignPremium(localizer, userId, subCommand.Options)
+	case "list":
+		return s.listPremium(localizer, userId)
+	default:
+		return ephemeralResponse(localizer.T("errors.unknown_command"))
+	}
+}
+
+func (s *Server) assignPremium(localizer i18n.Localizer, userId uint64, options []interaction.ApplicationCommandInteractionDataOption) commandResponse {
+	guildId, err := strconv.ParseUint(stringOption(options, "guild"), 10, 64)
+	if err != nil || guildId == 0 {
+		return ephemeralResponse(localizer.T("premium.invalid_guild"))
+	}
+
+	entitlement, hasInitialData := s.userEntitlement(userId)
+	if !hasInitialData {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	if entitlement == nil || entitlement.MaxGuilds == 0 {
+		return ephemeralResponse(localizer.T("premium.not_entitled"))
+	}
+
+	err = s.premium.Assign(time.Now(), userId, guildId, entitlement.MaxGuilds)
+	switch {
+	case errors.Is(err, premium.ErrAlreadyAssigned):
+		return ephemeralResponse(localizer.T("premium.already_assigned", guildId))
+	case errors.Is(err, premium.ErrLimitReached):
+		return ephemeralResponse(localizer.T("premium.limit_reached", entitlement.MaxGuilds))
+	case err != nil:
+		s.logger.Error("Failed to assign premium", zap.Uint64("user_id", userId), zap.Uint64("guild_id", guildId), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	used := len(s.premium.ForUser(userId))
+	return ephemeralResponse(localizer.T("premium.assigned", guildId, used, entitlement.MaxGuilds))
+}
+
+func (s *Server) unassignPremium(localizer i18n.Localizer, userId uint64, options []interaction.ApplicationCommandInteractionDataOption) commandResponse {
+	guildId, err := strconv.ParseUint(stringOption(options, "guild"), 10, 64)
+	if err != nil || guildId == 0 {
+		return ephemeralResponse(localizer.T("premium.invalid_guild"))
+	}
+
+	removed, err := s.premium.Unassign(userId, guildId)
+	if err != nil {
+		s.logger.Error("Failed to unassign premium", zap.Uint64("user_id", userId), zap.Uint64("guild_id", guildId), zap.Error(err))
+		return ephemeralResponse(localizer.T("errors.internal"))
+	}
+
+	if !removed {
+		return ephemeralResponse(localizer.T("premium.not_assigned", guildId))
+	}
+
+	return ephemeralResponse(localizer.T("premium.unassigned", guildId))
+}
+
+func (s *Server) listPremium(localizer i18n.Localizer, userId uint64) commandResponse {
+	entitlement, hasInitialData := s.userEntitlement(userId)
+	if !hasInitialData {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	maxGuilds := 0
+	if entitlement != nil {
+		maxGuilds = entitlement.MaxGuilds
+	}
+
+	assignments := s.premium.ForUser(userId)
+	if len(assignments) == 0 {
+		if maxGuilds == 0 {
+			return ephemeralResponse(localizer.T("premium.not_entitled"))
+		}
+
+		return ephemeralResponse(localizer.T("premium.list.none", maxGuilds))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(localizer.T("premium.list.header", len(assignments), maxGuilds))
+	for _, assignment := range assignments {
+		sb.WriteString(fmt.Sprintf("\n`%d` <t:%d:R>", assignment.GuildId, assignment.AssignedAt.Unix()))
+	}
+
+	return ephemeralResponse(sb.String())
+}
+
+// userEntitlement returns the effective entitlement of the Discord user, from their linked subscription and any grant,
+// or nil if they are not entitled to anything. The second return value is false if subscriber data has not been loaded
+// yet.
+func (s *Server) userEntitlement(userId uint64) (*entitlements.Entitlement, bool) {
+	hasInitialData := s.directory.Loaded()
+	patron, ok := s.directory.ByDiscordId(userId)
+
+	if !ok {
+		return s.grantEntitlement(userId), hasInitialData
+	}
+
+	return s.resolveEntitlement(patron), hasInitialData
+}
+
+// releasePremium removes premium assignments beyond each user's current guild limit, e.g. after their pledge has
+// lapsed or been downgraded. Nothing is released until every provider has been loaded.
+func (s *Server) releasePremium() {
+	if !s.complete() {
+		return
+	}
+
+	released, err := s.premium.Release(func(userId uint64) int {
+		if entitlement, _ := s.userEntitlement(userId); entitlement != nil {
+			return entitlement.MaxGuilds
+		}
+
+		return 0
+	})
+	if err != nil {
+		s.logger.Error("Failed to release premium assignments", zap.Error(err))
+		return
+	}
+
+	for _, assignment := range released {
+		s.logger.Info(
+			"Released premium assignment",
+			zap.Uint64("user_id", assignment.UserId),
+			zap.Uint64("guild_id", assignment.GuildId),
+		)
+	}
+}
+
+// premiumAssignmentResponse is the representation of a premium assignment returned by the API
+type premiumAssignmentResponse struct {
+	UserId     uint64    `json:"user_id,string"`
+	GuildId    uint64    `json:"guild_id,string"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+func newPremiumAssignmentResponses(assignments []premium.Assignment) []premiumAssignmentResponse {
+	res := make([]premiumAssignmentResponse, len(assignments))
+	for i, assignment := range assignments {
+		res[i] = premiumAssignmentResponse{
+			UserId:     assignment.UserId,
+			GuildId:    assignment.GuildId,
+			AssignedAt: assignment.AssignedAt,
+		}
+	}
+
+	return res
+}
+
+// HandleGuildPremium reports whether a guild has premium, and the best entitlement assigned to it.
+func (s *Server) HandleGuildPremium(ctx *gin.Context) {
+	guildId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJson("Invalid guild ID"))
+		return
+	}
+
+	assignments := s.premium.ForGuild(guildId)
+
+	var best *entitlements.Entitlement
+	for _, assignment := range assignments {
+		entitlement, _ := s.userEntitlement(assignment.UserId)
+		if entitlement != nil && (best == nil || entitlements.Better(*entitlement, *best)) {
+			best = entitlement
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"guild_id":    strconv.FormatUint(guildId, 10),
+		"premium":     best != nil,
+		"entitlement": best,
+		"assignments": newPremiumAssignmentResponses(assignments),
+	})
+}
+
+// HandlePremiumAssignments lists all premium assignments, optionally filtered by ?user_id=.
+func (s *Server) HandlePremiumAssignments(ctx *gin.Context) {
+	assignments := s.premium.All()
+	if rawUserId := ctx.Query("user_id"); rawUserId != "" {
+		userId, err := strconv.ParseUint(rawUserId, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, errorJson("Invalid user ID"))
+			return
+		}
+
+		assignments = s.premium.ForUser(userId)
+	}
+
+	ctx.JSON(http.StatusOK, newPremiumAssignmentResponses(assignments))
+}
@@ -0,0 +1,158 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func premiumCommand(id, userId uint64, subCommand string, guildId string) []byte {
+	var options []servertest.Option
+	if guildId != "" {
+		options = append(options, servertest.StringOption("guild", guildId))
+	}
+
+	return servertest.Command{
+		Id:      id,
+		GuildId: allowedGuildId,
+		UserId:  userId,
+		Name:    "premium",
+		Options: []servertest.Option{servertest.SubCommandOption(subCommand, options...)},
+	}.Payload()
+}
+
+func TestPremium(t *testing.T) {
+	conf := entitlementConfig()
+	conf.Entitlements[0].MaxGuilds = 2
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	steps := []struct {
+		name       string
+		userId     uint64
+		subCommand string
+		guildId    string
+		want       string
+	}{
+		{"not a patron", 1, "assign", "500", "Your subscription does not include premium for any servers"},
+		{"invalid guild", 12345, "assign", "abc", "The guild option must be a server ID"},
+		{"empty list", 12345, "list", "", "You have not given premium to any servers yet, you can give it to up to 2"},
+		{"assign", 12345, "assign", "500", "Server `500` now has your premium (1 of 2 servers used)"},
+		{"assign again", 12345, "assign", "500", "Server `500` already has your premium"},
+		{"assign second", 12345, "assign", "600", "Server `600` now has your premium (2 of 2 servers used)"},
+		{"limit reached", 12345, "assign", "700", "You have already given premium to 2 server(s), remove one with /premium unassign first"},
+		{"unassign", 12345, "unassign", "500", "Removed your premium from server `500`"},
+		{"unassign missing", 12345, "unassign", "500", "Server `500` does not have your premium"},
+		{"list", 12345, "list", "", "1 of 2 servers used:\n`600` <t:"},
+	}
+
+	for i, step := range steps {
+		res := servertest.DecodeResponse(t, h.Do(premiumCommand(uint64(i+1), step.userId, step.subCommand, step.guildId)))
+		if !strings.HasPrefix(res.Data.Content, step.want) {
+			t.Errorf("%s: expected %q, got %q", step.name, step.want, res.Data.Content)
+		}
+
+		if res.Data.Flags&64 == 0 {
+			t.Errorf("%s: expected an ephemeral response", step.name)
+		}
+	}
+
+	// Patrons can give premium from the server they are giving it to, which is not one of the allowed guilds
+	res := servertest.DecodeResponse(t, h.Do(servertest.Command{
+		Id:      100,
+		GuildId: disallowedGuildId,
+		UserId:  12345,
+		Name:    "premium",
+		Options: []servertest.Option{servertest.SubCommandOption("list")},
+	}.Payload()))
+	if !strings.HasPrefix(res.Data.Content, "1 of 2 servers used") {
+		t.Errorf("expected /premium to be accepted outside the allowed guilds, got %q", res.Data.Content)
+	}
+}
+
+func TestPremiumReleasedOnLapse(t *testing.T) {
+	conf := entitlementConfig()
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	res := servertest.DecodeResponse(t, h.Do(premiumCommand(1, 12345, "assign", "500")))
+	if !strings.HasPrefix(res.Data.Content, "Server `500` now has your premium") {
+		t.Fatalf("unexpected response %q", res.Data.Content)
+	}
+
+	type guildPremium struct {
+		GuildId     string `json:"guild_id"`
+		Premium     bool   `json:"premium"`
+		Entitlement *struct {
+			Type string `json:"type"`
+		} `json:"entitlement"`
+		Assignments []struct {
+			UserId string `json:"user_id"`
+		} `json:"assignments"`
+	}
+
+	getGuild := func() guildPremium {
+		t.Helper()
+
+		recorder := apiRequest(t, h, "/api/v1/guilds/500/premium", testApiKey)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		var got guildPremium
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return got
+	}
+
+	got := getGuild()
+	if !got.Premium || got.Entitlement == nil || got.Entitlement.Type != "premium" || len(got.Assignments) != 1 || got.Assignments[0].UserId != "12345" {
+		t.Errorf("unexpected guild premium %+v", got)
+	}
+
+	// The patron's pledge lapses
+	pledges := testPledges()
+	patron := pledges["patron@example.com"]
+	patron.PatronStatus = patreon.StatusFormer
+	patron.Tiers = nil
+	pledges["patron@example.com"] = patron
+	h.SetPledges(pledges)
+
+	if got := getGuild(); got.Premium || len(got.Assignments) != 0 {
+		t.Errorf("expected the assignment to be released, got %+v", got)
+	}
+
+	recorder := apiRequest(t, h, "/api/v1/premium/assignments?user_id=12345", testApiKey)
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != "[]" {
+		t.Errorf("expected no assignments, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestPremiumAssignmentsApi(t *testing.T) {
+	conf := entitlementConfig()
+	conf.Entitlements = append(conf.Entitlements, config.TierEntitlement{TierId: 2, Type: "whitelabel", MaxGuilds: 1, Priority: 2})
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+	servertest.DecodeResponse(t, h.Do(premiumCommand(1, 12345, "assign", "500")))
+
+	recorder := apiRequest(t, h, "/api/v1/premium/assignments", testApiKey)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"guild_id":"500"`) {
+		t.Errorf("unexpected response %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/premium/assignments?user_id=abc", testApiKey); recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", recorder.Code)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/guilds/500/premium", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", recorder.Code)
+	}
+}
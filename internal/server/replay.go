@@ -0,0 +1,56 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// replayCache records recently seen interaction IDs and signatures, so that a captured request cannot be replayed
+// while its timestamp is still inside the accepted window.
+type replayCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	entries    map[string]time.Time // key -> expiry
+	lastPruned time.Time
+}
+
+func newReplayCache(ttl time.Duration) *replayCache {
+	return &replayCache{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// CheckAndAdd returns false if any of the keys have been seen before and have not yet expired. Otherwise, the keys
+// are recorded and true is returned.
+func (c *replayCache) CheckAndAdd(now time.Time, keys ...string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPruned) > c.ttl {
+		c.prune(now)
+	}
+
+	for _, key := range keys {
+		if expiry, ok := c.entries[key]; ok && expiry.After(now) {
+			return false
+		}
+	}
+
+	for _, key := range keys {
+		c.entries[key] = now.Add(c.ttl)
+	}
+
+	return true
+}
+
+func (c *replayCache) prune(now time.Time) {
+	for key, expiry := range c.entries {
+		if !expiry.After(now) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.lastPruned = now
+}
@@ -1,11 +1,30 @@
 package server
 
 import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/changes"
 	"github.com/TicketsBot/subscriptions-app/internal/config"
-	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/TicketsBot/subscriptions-app/internal/declines"
+	"github.com/TicketsBot/subscriptions-app/internal/embeds"
+	"github.com/TicketsBot/subscriptions-app/internal/entitlements"
+	"github.com/TicketsBot/subscriptions-app/internal/grace"
+	"github.com/TicketsBot/subscriptions-app/internal/grants"
+	"github.com/TicketsBot/subscriptions-app/internal/history"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/outbox"
+	"github.com/TicketsBot/subscriptions-app/internal/premium"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/discord"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/kofi"
+	patreonprovider "github.com/TicketsBot/subscriptions-app/internal/providers/patreon"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/stripe"
+	"github.com/TicketsBot/subscriptions-app/internal/redissync"
+	"github.com/TicketsBot/subscriptions-app/internal/store"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -14,18 +33,176 @@ type Server struct {
 	config config.Config
 	logger *zap.Logger
 
-	pledges map[string]patreon.Patron
-	mu      sync.RWMutex
+	directory *providers.Directory
+	updateMu  sync.Mutex        // Serialises updates, so that derived state is updated in the same order as the directory
+	discord   *discord.Provider // nil if Discord subscriptions are disabled
+	stripe    *stripe.Provider  // nil if Stripe is not configured
+	kofi      *kofi.Provider    // nil if Ko-fi is not configured
+
+	changes     *changes.Detector
+	sinks       []changes.Sink       // Receive the changes detected after every update
+	redis       *redissync.Publisher // nil if Redis is not configured
+	outbox      *outbox.Outbox       // nil if no webhook endpoints are configured
+	broadcaster *changes.Broadcaster // Sends changes to WatchChanges streams. nil if the gRPC server is disabled
+
+	applications []Application
+	replayCache  *replayCache
+	i18n         *i18n.Catalogue
+	embeds       *embeds.Renderer
+	httpClient   *http.Client
+	store        *store.Store
+	history      *history.Recorder
+	declines     *declines.Tracker // nil if the workflow is disabled
+	entitlements *entitlements.Resolver
+	premium      *premium.Manager
+	grants       *grants.Manager
+	grace        *grace.Tracker // nil if no grace period is configured
 }
 
-func NewServer(config config.Config, logger *zap.Logger) *Server {
-	return &Server{
-		config: config,
-		logger: logger,
+func NewServer(config config.Config, logger *zap.Logger) (*Server, error) {
+	if err := validateCommandHandlers(); err != nil {
+		return nil, err
+	}
+
+	applications, err := decodeApplications(config)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogue, err := i18n.Load()
+	if err != nil {
+		return nil, err
 	}
+
+	renderer, err := embeds.NewRenderer(config.Embeds)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := entitlements.NewResolver(config.Entitlements)
+	if err != nil {
+		return nil, err
+	}
+
+	dataStore, err := store.Open(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := history.NewRecorder(dataStore, config.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	premiumManager, err := premium.NewManager(dataStore)
+	if err != nil {
+		return nil, err
+	}
+
+	grantManager, err := grants.NewManager(dataStore)
+	if err != nil {
+		return nil, err
+	}
+
+	var graceTracker *grace.Tracker
+	if policy := gracePolicy(config); policy.Enabled() {
+		graceTracker, err = grace.NewTracker(dataStore, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var discordProvider *discord.Provider
+	if config.DiscordSubscriptions.Enabled {
+		discordProvider, err = discord.NewProvider(config, logger.With(zap.String("component", "discord_provider")))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var stripeProvider *stripe.Provider
+	if config.Stripe.WebhookSecret != "" {
+		stripeProvider, err = stripe.NewProvider(config, dataStore, logger.With(zap.String("component", "stripe_provider")))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var kofiProvider *kofi.Provider
+	if config.Kofi.VerificationToken != "" {
+		kofiProvider, err = kofi.NewProvider(config, dataStore, logger.With(zap.String("component", "kofi_provider")))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sinks []changes.Sink
+
+	var redisPublisher *redissync.Publisher
+	if config.Redis.Addr != "" {
+		redisPublisher = redissync.NewPublisher(config, logger.With(zap.String("component", "redis_publisher")))
+		sinks = append(sinks, redisPublisher)
+	}
+
+	var webhookOutbox *outbox.Outbox
+	if len(config.Webhooks.Endpoints) > 0 {
+		webhookOutbox, err = outbox.New(config, dataStore, logger.With(zap.String("component", "outbox")))
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, webhookOutbox)
+	}
+
+	var broadcaster *changes.Broadcaster
+	if config.Grpc.Addr != "" {
+		broadcaster = changes.NewBroadcaster()
+		sinks = append(sinks, broadcaster)
+	}
+
+	var tracker *declines.Tracker
+	if config.Declines.Enabled {
+		tracker, err = newDeclinesTracker(config, dataStore, renderer, catalogue, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Server{
+		config:       config,
+		logger:       logger,
+		discord:      discordProvider,
+		stripe:       stripeProvider,
+		kofi:         kofiProvider,
+		changes:      changes.NewDetector(),
+		sinks:        sinks,
+		redis:        redisPublisher,
+		outbox:       webhookOutbox,
+		broadcaster:  broadcaster,
+		applications: applications,
+		i18n:         catalogue,
+		embeds:       renderer,
+		httpClient:   http.DefaultClient,
+		store:        dataStore,
+		history:      recorder,
+		declines:     tracker,
+		entitlements: resolver,
+		premium:      premiumManager,
+		grants:       grantManager,
+		grace:        graceTracker,
+		// Timestamps are accepted up to MaxTimestampAge in either direction, so entries must outlive both
+		replayCache: newReplayCache(config.Discord.MaxTimestampAge.Duration() * 2),
+	}
+
+	// Lookups only wait for Patreon, which is always watched, so that an optional provider which is slow or failing
+	// to load does not take them down. See complete.
+	s.directory = providers.NewDirectory(patreonprovider.Name)
+
+	return s, nil
 }
 
-func (s *Server) Run() error {
+// Handler builds the gin router serving all routes. It can be used to serve requests in-process, e.g. in tests.
+func (s *Server) Handler() http.Handler {
 	router := gin.New()
 
 	router.Use(ginzap.Ginzap(s.logger, time.RFC3339, true))
@@ -33,13 +210,142 @@ func (s *Server) Run() error {
 	router.Use(s.ErrorHandler)
 
 	router.POST("/interaction", s.Authenticate, s.HandleInteraction)
+	router.POST("/webhook-events", s.Authenticate, s.HandleWebhookEvent)
+	router.POST("/webhooks/stripe", s.HandleStripeWebhook)
+	router.POST("/webhooks/kofi", s.HandleKofiWebhook)
+
+	api := router.Group("/api/v1", s.AuthenticateApi)
+	api.GET("/export", s.HandleExport)
+	api.GET("/stats", s.HandleStats)
+	api.GET("/history", s.HandleHistory)
+	api.GET("/declines", s.HandleDeclines)
+	api.GET("/patrons/discord/:id", s.HandlePatronByDiscordId)
+	api.GET("/patrons/email/:email", s.HandlePatronByEmail)
+	api.GET("/guilds/:id/premium", s.HandleGuildPremium)
+	api.GET("/premium/assignments", s.HandlePremiumAssignments)
+	api.GET("/grants", s.HandleGrants)
+	api.GET("/discord/skus", s.HandleDiscordSkus)
+	api.GET("/webhooks", s.HandleWebhookEndpoints)
+	api.GET("/webhooks/deliveries", s.HandleWebhookDeliveries)
+	api.POST("/webhooks/deliveries/:id/retry", s.HandleRetryWebhookDelivery)
 
-	return router.Run(s.config.ServerAddr)
+	return router
 }
 
-func (s *Server) UpdatePledges(pledges map[string]patreon.Patron) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Run starts the HTTP server, and blocks until the context is cancelled, at which point in-flight requests are
+// given up to config.ShutdownTimeout to complete before the server is closed.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    s.config.ServerAddr,
+		Handler: s.Handler(),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("Starting server", zap.String("addr", s.config.ServerAddr))
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("Shutting down server", zap.Duration("timeout", s.config.ShutdownTimeout.Duration()))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout.Duration())
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "failed to shut down server gracefully")
+	}
+
+	return nil
+}
+
+// Providers returns the enabled providers whose subscribers are received by the server, such as through webhooks.
+// Each must be watched alongside Patreon for the server to receive their subscribers.
+func (s *Server) Providers() []providers.Provider {
+	var enabled []providers.Provider
+	if s.discord != nil {
+		enabled = append(enabled, s.discord)
+	}
+
+	if s.stripe != nil {
+		enabled = append(enabled, s.stripe)
+	}
+
+	if s.kofi != nil {
+		enabled = append(enabled, s.kofi)
+	}
+
+	return enabled
+}
+
+// RedisPublisher returns the publisher of entitlements and changes to Redis, which must be run for them to be written,
+// or nil if Redis is not configured.
+func (s *Server) RedisPublisher() *redissync.Publisher {
+	return s.redis
+}
+
+// Outbox returns the outbox of webhook deliveries, which must be run for them to be sent, or nil if no endpoints are
+// configured.
+func (s *Server) Outbox() *outbox.Outbox {
+	return s.outbox
+}
+
+func gracePolicy(config config.Config) grace.Policy {
+	return grace.Policy{
+		DeclinedDays:  config.Grace.DeclinedDays,
+		CancelledDays: config.Grace.CancelledDays,
+	}
+}
+
+// complete reports whether every enabled provider has sent a full update, not just Patreon. Anything that compares
+// the full set of subscribers between updates, such as the history, the declined payment workflow and change events,
+// waits until then, as the subscribers of a provider that has yet to load would otherwise appear to have lapsed.
+func (s *Server) complete() bool {
+	if !s.directory.Loaded() {
+		return false
+	}
+
+	for _, provider := range s.Providers() {
+		if !s.directory.ProviderLoaded(provider.Name()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ApplyUpdate merges an update from a provider into the subscribers the server knows about, and updates the state
+// derived from them.
+func (s *Server) ApplyUpdate(update providers.Update) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.directory.Apply(update)
+	if !s.complete() {
+		return
+	}
+
+	subscribers := s.directory.All()
+
+	if err := s.history.Record(time.Now(), subscribers); err != nil {
+		s.logger.Error("Failed to record history", zap.Error(err))
+	}
+
+	if s.grace != nil {
+		if err := s.grace.Update(time.Now(), subscribers); err != nil {
+			s.logger.Error("Failed to update grace periods", zap.Error(err))
+		}
+	}
+
+	// An empty snapshot is more likely a fetch problem than every subscriber lapsing at once
+	if len(subscribers) > 0 {
+		s.releasePremium()
+	}
 
-	s.pledges = pledges
+	s.publishChanges()
 }
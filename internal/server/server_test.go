@@ -0,0 +1,497 @@
+package server_test
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	allowedGuildId    = 100
+	disallowedGuildId = 200
+)
+
+func testConfig() config.Config {
+	var conf config.Config
+	conf.Discord.AllowedGuilds = []uint64{allowedGuildId}
+	conf.Discord.MaxTimestampAge = config.Duration(time.Minute * 5)
+	conf.Tiers = map[uint64]string{
+		1: "Premium",
+	}
+
+	return conf
+}
+
+func testPledges() map[string]patreon.Patron {
+	discordId := uint64(12345)
+
+	return map[string]patreon.Patron{
+		"patron@example.com": {
+			Attributes: patreon.Attributes{
+				Email:                   "patron@example.com",
+				LastChargeDate:          time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				LastChargeStatus:        "Paid",
+				PatronStatus:            "active_patron",
+				PledgeRelationshipStart: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			Id:        1,
+			Tiers:     []uint64{1},
+			DiscordId: &discordId,
+		},
+	}
+}
+
+func lookup(guildId uint64, email string) []byte {
+	return servertest.Command{
+		GuildId: guildId,
+		UserId:  1,
+		Name:    "lookup",
+		Options: []servertest.Option{servertest.StringOption("email", email)},
+	}.Payload()
+}
+
+func TestInteractions(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     []byte
+		noPledges   bool
+		wantType    int
+		wantTitle   string
+		wantContent string
+		wantFields  map[string]string
+	}{
+		{
+			name:     "ping",
+			payload:  servertest.PingPayload(),
+			wantType: 1,
+		},
+		{
+			name:      "lookup found",
+			payload:   lookup(allowedGuildId, "patron@example.com"),
+			wantType:  4,
+			wantTitle: "Account Found",
+			wantFields: map[string]string{
+				"Status":          "active_patron",
+				"Active Tiers":    "Premium",
+				"Discord Account": "<@12345> (12345)",
+			},
+		},
+		{
+			name: "lookup found localized",
+			payload: servertest.Command{
+				GuildId:     allowedGuildId,
+				UserId:      1,
+				Locale:      "de",
+				GuildLocale: "en-US",
+				Name:        "lookup",
+				Options:     []servertest.Option{servertest.StringOption("email", "patron@example.com")},
+			}.Payload(),
+			wantType:  4,
+			wantTitle: "Konto gefunden",
+			wantFields: map[string]string{
+				"Aktive Stufen": "Premium",
+			},
+		},
+		{
+			name:      "lookup not found",
+			payload:   lookup(allowedGuildId, "unknown@example.com"),
+			wantType:  4,
+			wantTitle: "Account Not Found",
+		},
+		{
+			name: "mysubscription linked",
+			payload: servertest.Command{
+				GuildId: allowedGuildId,
+				UserId:  12345,
+				Name:    "mysubscription",
+			}.Payload(),
+			wantType:  4,
+			wantTitle: "Your Subscription",
+			wantFields: map[string]string{
+				"Status":       "Active",
+				"Active Tiers": "Premium",
+			},
+		},
+		{
+			name: "mysubscription not linked",
+			payload: servertest.Command{
+				GuildId: allowedGuildId,
+				UserId:  999,
+				Name:    "mysubscription",
+			}.Payload(),
+			wantType:  4,
+			wantTitle: "No Linked Subscription",
+		},
+		{
+			name: "mysubscription outside the allowed guilds",
+			payload: servertest.Command{
+				GuildId: disallowedGuildId,
+				UserId:  12345,
+				Name:    "mysubscription",
+			}.Payload(),
+			wantType:  4,
+			wantTitle: "Your Subscription",
+		},
+		{
+			name: "mysubscription in a direct message",
+			payload: servertest.Command{
+				UserId: 12345,
+				Name:   "mysubscription",
+			}.Payload(),
+			wantType:  4,
+			wantTitle: "Your Subscription",
+		},
+		{
+			name:        "initial data not loaded",
+			payload:     lookup(allowedGuildId, "patron@example.com"),
+			noPledges:   true,
+			wantType:    4,
+			wantContent: "Initial data not loaded yet, please try again in a few minutes",
+		},
+		{
+			name:        "guild not allowed",
+			payload:     lookup(disallowedGuildId, "patron@example.com"),
+			wantType:    4,
+			wantContent: "This guild is not in the allowed guilds list",
+		},
+		{
+			name:        "direct message",
+			payload:     lookup(0, "patron@example.com"),
+			wantType:    4,
+			wantContent: "This guild is not in the allowed guilds list",
+		},
+		{
+			name: "unknown command",
+			payload: servertest.Command{
+				GuildId: allowedGuildId,
+				UserId:  1,
+				Name:    "unknown",
+			}.Payload(),
+			wantType:    4,
+			wantContent: "Unknown command",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := servertest.NewHarness(t, testConfig())
+			if !tc.noPledges {
+				h.SetPledges(testPledges())
+			}
+
+			recorder := h.Do(tc.payload)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			res := servertest.DecodeResponse(t, recorder)
+			if res.Type != tc.wantType {
+				t.Errorf("expected response type %d, got %d", tc.wantType, res.Type)
+			}
+
+			if res.Data.Content != tc.wantContent {
+				t.Errorf("expected content %q, got %q", tc.wantContent, res.Data.Content)
+			}
+
+			if tc.wantTitle == "" {
+				return
+			}
+
+			if len(res.Data.Embeds) != 1 {
+				t.Fatalf("expected 1 embed, got %d", len(res.Data.Embeds))
+			}
+
+			embed := res.Data.Embeds[0]
+			if embed.Title != tc.wantTitle {
+				t.Errorf("expected title %q, got %q", tc.wantTitle, embed.Title)
+			}
+
+			for name, want := range tc.wantFields {
+				var found bool
+				for _, field := range embed.Fields {
+					if field.Name == name {
+						found = true
+
+						if field.Value != want {
+							t.Errorf("expected field %q to be %q, got %q", name, want, field.Value)
+						}
+					}
+				}
+
+				if !found {
+					t.Errorf("missing field %q", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	otherSigner, err := servertest.NewSigner()
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+
+	body := servertest.PingPayload()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	staleTimestamp := strconv.FormatInt(time.Now().Add(-time.Minute*10).Unix(), 10)
+	futureTimestamp := strconv.FormatInt(time.Now().Add(time.Minute*10).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		signature  func(h *servertest.Harness) string
+		timestamp  string
+		body       []byte
+		wantStatus int
+	}{
+		{
+			name:       "valid signature",
+			signature:  func(h *servertest.Harness) string { return h.Signer.Sign(timestamp, body) },
+			timestamp:  timestamp,
+			body:       body,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing signature",
+			signature:  func(h *servertest.Harness) string { return "" },
+			timestamp:  timestamp,
+			body:       body,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing timestamp",
+			signature:  func(h *servertest.Harness) string { return h.Signer.Sign(timestamp, body) },
+			body:       body,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "signed by another key",
+			signature:  func(h *servertest.Harness) string { return otherSigner.Sign(timestamp, body) },
+			timestamp:  timestamp,
+			body:       body,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "tampered body",
+			signature:  func(h *servertest.Harness) string { return h.Signer.Sign(timestamp, body) },
+			timestamp:  timestamp,
+			body:       lookup(allowedGuildId, "patron@example.com"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "stale timestamp",
+			signature:  func(h *servertest.Harness) string { return h.Signer.Sign(staleTimestamp, body) },
+			timestamp:  staleTimestamp,
+			body:       body,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "future timestamp",
+			signature:  func(h *servertest.Harness) string { return h.Signer.Sign(futureTimestamp, body) },
+			timestamp:  futureTimestamp,
+			body:       body,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "signature not hex",
+			signature:  func(h *servertest.Harness) string { return "not hex" },
+			timestamp:  timestamp,
+			body:       body,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := servertest.NewHarness(t, testConfig())
+
+			recorder := h.DoRequest(servertest.NewRawRequest(tc.body, tc.signature(h), tc.timestamp))
+			if recorder.Code != tc.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.wantStatus, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	h := servertest.NewHarness(t, testConfig())
+	h.SetPledges(testPledges())
+
+	body := lookup(allowedGuildId, "patron@example.com")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := h.Signer.Sign(timestamp, body)
+
+	if recorder := h.DoRequest(servertest.NewRawRequest(body, signature, timestamp)); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Exact replay of the same request
+	if recorder := h.DoRequest(servertest.NewRawRequest(body, signature, timestamp)); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed request to be rejected, got %d", recorder.Code)
+	}
+
+	// Hex decoding ignores case, so the same signature can be sent in upper case
+	if recorder := h.DoRequest(servertest.NewRawRequest(body, strings.ToUpper(signature), timestamp)); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed request with an upper case signature to be rejected, got %d", recorder.Code)
+	}
+
+	// Same interaction ID, re-signed with a different timestamp
+	otherTimestamp := strconv.FormatInt(time.Now().Add(-time.Second*30).Unix(), 10)
+	otherSignature := h.Signer.Sign(otherTimestamp, body)
+	if recorder := h.DoRequest(servertest.NewRawRequest(body, otherSignature, otherTimestamp)); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected duplicate interaction ID to be rejected, got %d", recorder.Code)
+	}
+
+	// A different interaction should still be accepted
+	other := servertest.Command{
+		Id:      2,
+		GuildId: allowedGuildId,
+		UserId:  1,
+		Name:    "lookup",
+		Options: []servertest.Option{servertest.StringOption("email", "patron@example.com")},
+	}.Payload()
+
+	if recorder := h.Do(other); recorder.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestMultipleApplications(t *testing.T) {
+	const stagingGuildId = 300
+
+	oldKey, _ := servertest.NewSigner()
+	newKey, _ := servertest.NewSigner()
+	stagingKey, _ := servertest.NewSigner()
+
+	conf := testConfig()
+	conf.Discord.Applications = []config.Application{
+		{ApplicationId: 1, PublicKey: oldKey.PublicKeyHex()},
+		{ApplicationId: 1, PublicKey: newKey.PublicKeyHex()},
+		{ApplicationId: 2, PublicKey: stagingKey.PublicKeyHex(), AllowedGuilds: []uint64{stagingGuildId}},
+	}
+
+	tests := []struct {
+		name        string
+		signer      *servertest.Signer
+		guildId     uint64
+		wantContent string
+	}{
+		{name: "old production key", signer: oldKey, guildId: allowedGuildId},
+		{name: "new production key", signer: newKey, guildId: allowedGuildId},
+		{name: "staging key in staging guild", signer: stagingKey, guildId: stagingGuildId},
+		{
+			name:        "staging key in production guild",
+			signer:      stagingKey,
+			guildId:     allowedGuildId,
+			wantContent: "This guild is not in the allowed guilds list",
+		},
+		{
+			name:        "production key in staging guild",
+			signer:      newKey,
+			guildId:     stagingGuildId,
+			wantContent: "This guild is not in the allowed guilds list",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := servertest.NewHarness(t, conf)
+			h.SetPledges(testPledges())
+
+			recorder := h.DoRequest(tc.signer.NewRequest(lookup(tc.guildId, "patron@example.com")))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			res := servertest.DecodeResponse(t, recorder)
+			if res.Data.Content != tc.wantContent {
+				t.Errorf("expected content %q, got %q", tc.wantContent, res.Data.Content)
+			}
+		})
+	}
+
+	// The harness' own key was not configured, so should be rejected
+	h := servertest.NewHarness(t, conf)
+	if recorder := h.Do(servertest.PingPayload()); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected unconfigured key to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestPrivacy(t *testing.T) {
+	const privilegedRoleId = 500
+
+	conf := testConfig()
+	conf.Privacy.PrivilegedRoles = []uint64{privilegedRoleId}
+
+	lookupWithOptions := func(email string, roles []uint64, options ...servertest.Option) []byte {
+		return servertest.Command{
+			GuildId: allowedGuildId,
+			UserId:  1,
+			Roles:   roles,
+			Name:    "lookup",
+			Options: append([]servertest.Option{servertest.StringOption("email", email)}, options...),
+		}.Payload()
+	}
+
+	publicOption := servertest.Option{Name: "public", Type: servertest.OptionTypeBoolean, Value: true}
+	showEmailOption := servertest.Option{Name: "show_email", Type: servertest.OptionTypeBoolean, Value: true}
+
+	tests := []struct {
+		name            string
+		payload         []byte
+		wantFlags       uint
+		wantContent     string
+		wantDescription string
+	}{
+		{
+			name:      "ephemeral by default",
+			payload:   lookupWithOptions("patron@example.com", nil),
+			wantFlags: 64,
+		},
+		{
+			name:            "not found masks email",
+			payload:         lookupWithOptions("someone@example.com", nil),
+			wantFlags:       64,
+			wantDescription: "No subscription with email `s***@example.com` found",
+		},
+		{
+			name:            "privileged override",
+			payload:         lookupWithOptions("someone@example.com", []uint64{privilegedRoleId}, publicOption, showEmailOption),
+			wantFlags:       0,
+			wantDescription: "No subscription with email `someone@example.com` found",
+		},
+		{
+			name:        "unprivileged override",
+			payload:     lookupWithOptions("someone@example.com", []uint64{1}, publicOption),
+			wantFlags:   64,
+			wantContent: "You do not have permission to override the privacy settings",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := servertest.NewHarness(t, conf)
+			h.SetPledges(testPledges())
+
+			res := servertest.DecodeResponse(t, h.Do(tc.payload))
+			if res.Data.Flags != tc.wantFlags {
+				t.Errorf("expected flags %d, got %d", tc.wantFlags, res.Data.Flags)
+			}
+
+			if res.Data.Content != tc.wantContent {
+				t.Errorf("expected content %q, got %q", tc.wantContent, res.Data.Content)
+			}
+
+			if tc.wantDescription != "" {
+				if len(res.Data.Embeds) != 1 || res.Data.Embeds[0].Description != tc.wantDescription {
+					t.Errorf("expected description %q, got %+v", tc.wantDescription, res.Data.Embeds)
+				}
+			}
+		})
+	}
+}
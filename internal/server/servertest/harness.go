@@ -0,0 +1,158 @@
+// Package servertest provides helpers for exercising server.Server in-process, with interaction payloads signed in
+// the same way that Discord signs them.
+package servertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	patreonprovider "github.com/TicketsBot/subscriptions-app/internal/providers/patreon"
+	"github.com/TicketsBot/subscriptions-app/internal/server"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type Harness struct {
+	Server  *server.Server
+	Handler http.Handler
+	Signer  *Signer
+	Config  config.Config
+}
+
+// NewHarness creates a server using the given config. If the config has no Discord public keys, the generated
+// signer's key is used, and if it has no data directory, a temporary directory is used.
+func NewHarness(t testing.TB, conf config.Config) *Harness {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	signer, err := NewSigner()
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+
+	if len(conf.Applications()) == 0 {
+		conf.Discord.PublicKey = signer.PublicKeyHex()
+	}
+
+	if conf.DataDir == "" {
+		conf.DataDir = t.TempDir()
+	}
+
+	s, err := server.NewServer(conf, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	return &Harness{
+		Server:  s,
+		Handler: s.Handler(),
+		Signer:  signer,
+		Config:  conf,
+	}
+}
+
+// SetPledges replaces the server's Patreon subscribers with the given pledges.
+func (h *Harness) SetPledges(pledges map[string]patreon.Patron) {
+	subscribers := make([]providers.Subscriber, 0, len(pledges))
+	for _, patron := range pledges {
+		subscribers = append(subscribers, patreonprovider.Subscriber(patron))
+	}
+
+	h.Server.ApplyUpdate(providers.Update{
+		Provider:    patreonprovider.Name,
+		Subscribers: subscribers,
+		Full:        true,
+	})
+}
+
+// Do signs and sends the payload to the interaction endpoint.
+func (h *Harness) Do(body []byte) *httptest.ResponseRecorder {
+	return h.DoRequest(h.Signer.NewRequest(body))
+}
+
+// DoRequest sends an arbitrary request to the server.
+func (h *Harness) DoRequest(req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.Handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// Response is a loosely typed interaction response, for making assertions against.
+type Response struct {
+	Type int `json:"type"`
+	Data struct {
+		Content string `json:"content"`
+		Flags   uint   `json:"flags"`
+		Embeds  []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Url         string `json:"url"`
+			Color       int    `json:"color"`
+			Fields      []struct {
+				Name   string `json:"name"`
+				Value  string `json:"value"`
+				Inline bool   `json:"inline"`
+			} `json:"fields"`
+		} `json:"embeds"`
+	} `json:"data"`
+}
+
+// DecodeResponse decodes the recorded body into a Response, failing the test if it cannot be decoded.
+func DecodeResponse(t testing.TB, recorder *httptest.ResponseRecorder) Response {
+	t.Helper()
+
+	var res Response
+	if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode response %q: %v", recorder.Body.String(), err)
+	}
+
+	return res
+}
+
+// DecodeMultipartResponse decodes a multipart/form-data response, as sent when a response includes files, returning
+// the decoded payload_json and the contents of each file keyed by filename.
+func DecodeMultipartResponse(t testing.TB, recorder *httptest.ResponseRecorder) (Response, map[string][]byte) {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("expected multipart/form-data response, got %q", recorder.Header().Get("Content-Type"))
+	}
+
+	var res Response
+	files := make(map[string][]byte)
+
+	reader := multipart.NewReader(bytes.NewReader(recorder.Body.Bytes()), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read multipart response: %v", err)
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("failed to read multipart part: %v", err)
+		}
+
+		if part.FormName() == "payload_json" {
+			if err := json.Unmarshal(content, &res); err != nil {
+				t.Fatalf("failed to decode payload_json %q: %v", content, err)
+			}
+		} else {
+			files[part.FileName()] = content
+		}
+	}
+
+	return res, files
+}
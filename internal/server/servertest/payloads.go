@@ -0,0 +1,179 @@
+package servertest
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Option is a command option included in an application command payload.
+type Option struct {
+	Name    string
+	Type    int
+	Value   any
+	Options []Option // for subcommands
+}
+
+const (
+	OptionTypeSubCommand = 1
+	OptionTypeString     = 3
+	OptionTypeInteger    = 4
+	OptionTypeBoolean    = 5
+	OptionTypeUser       = 6
+	OptionTypeAttachment = 11
+)
+
+// StringOption is a shorthand for a string command option.
+func StringOption(name, value string) Option {
+	return Option{
+		Name:  name,
+		Type:  OptionTypeString,
+		Value: value,
+	}
+}
+
+// SubCommandOption is a shorthand for a subcommand option, with its own nested options.
+func SubCommandOption(name string, options ...Option) Option {
+	return Option{
+		Name:    name,
+		Type:    OptionTypeSubCommand,
+		Options: options,
+	}
+}
+
+// Command describes an application command interaction, to be converted to a payload with Payload.
+type Command struct {
+	Id            uint64
+	ApplicationId uint64
+	GuildId       uint64 // 0 for DMs
+	UserId        uint64
+	Username      string
+	Roles         []uint64
+	Locale        string
+	GuildLocale   string
+	Name          string
+	Options       []Option
+	Attachments   map[uint64]Attachment // resolved attachments, keyed by ID
+}
+
+// Attachment is a resolved attachment referenced by an attachment option.
+type Attachment struct {
+	Filename string
+	Url      string
+	Size     int
+}
+
+// AttachmentOption is a shorthand for an attachment command option referencing a resolved attachment.
+func AttachmentOption(name string, id uint64) Option {
+	return Option{
+		Name:  name,
+		Type:  OptionTypeAttachment,
+		Value: strconv.FormatUint(id, 10),
+	}
+}
+
+// PingPayload returns the payload Discord sends when validating the interaction endpoint URL.
+func PingPayload() []byte {
+	return mustMarshal(map[string]any{
+		"id":      "1",
+		"type":    1,
+		"version": 1,
+	})
+}
+
+// Payload converts the command into the JSON payload that Discord would send.
+func (c Command) Payload() []byte {
+	username := c.Username
+	if username == "" {
+		username = "test-user"
+	}
+
+	user := map[string]any{
+		"id":            strconv.FormatUint(c.UserId, 10),
+		"username":      username,
+		"discriminator": "0",
+	}
+
+	id := c.Id
+	if id == 0 {
+		id = 1
+	}
+
+	data := map[string]any{
+		"id":      "1",
+		"name":    c.Name,
+		"type":    1,
+		"options": optionsPayload(c.Options),
+	}
+
+	if len(c.Attachments) > 0 {
+		attachments := make(map[string]any, len(c.Attachments))
+		for id, attachment := range c.Attachments {
+			attachments[strconv.FormatUint(id, 10)] = map[string]any{
+				"id":       strconv.FormatUint(id, 10),
+				"filename": attachment.Filename,
+				"url":      attachment.Url,
+				"size":     attachment.Size,
+			}
+		}
+
+		data["resolved"] = map[string]any{
+			"attachments": attachments,
+		}
+	}
+
+	payload := map[string]any{
+		"id":             strconv.FormatUint(id, 10),
+		"application_id": strconv.FormatUint(c.ApplicationId, 10),
+		"type":           2,
+		"version":        1,
+		"token":          "interaction-token",
+		"channel_id":     "1",
+		"locale":         c.Locale,
+		"data":           data,
+	}
+
+	if c.GuildId != 0 {
+		roles := make([]string, len(c.Roles))
+		for i, role := range c.Roles {
+			roles[i] = strconv.FormatUint(role, 10)
+		}
+
+		payload["guild_id"] = strconv.FormatUint(c.GuildId, 10)
+		payload["guild_locale"] = c.GuildLocale
+		payload["member"] = map[string]any{
+			"user":  user,
+			"roles": roles,
+		}
+	} else {
+		payload["user"] = user
+	}
+
+	return mustMarshal(payload)
+}
+
+func optionsPayload(options []Option) []map[string]any {
+	payload := make([]map[string]any, len(options))
+	for i, option := range options {
+		payload[i] = map[string]any{
+			"name": option.Name,
+			"type": option.Type,
+		}
+
+		if option.Type == OptionTypeSubCommand {
+			payload[i]["options"] = optionsPayload(option.Options)
+		} else {
+			payload[i]["value"] = option.Value
+		}
+	}
+
+	return payload
+}
+
+func mustMarshal(v any) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return encoded
+}
@@ -0,0 +1,79 @@
+package servertest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+)
+
+// Signer signs interaction payloads in the same way that Discord does, using a freshly generated Ed25519 key pair.
+type Signer struct {
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+func NewSigner() (*Signer, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// PublicKeyHex returns the public key in the format shown on the Discord developer portal.
+func (s *Signer) PublicKeyHex() string {
+	return hex.EncodeToString(s.PublicKey)
+}
+
+// Sign returns the hex-encoded signature of timestamp + body, as sent in the X-Signature-Ed25519 header.
+func (s *Signer) Sign(timestamp string, body []byte) string {
+	payload := append([]byte(timestamp), body...)
+	return hex.EncodeToString(ed25519.Sign(s.PrivateKey, payload))
+}
+
+// NewRequest builds a signed POST request to /interaction, using the current time as the timestamp.
+func (s *Signer) NewRequest(body []byte) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return NewRawRequest(body, s.Sign(timestamp, body), timestamp)
+}
+
+// NewWebhookEventRequest builds a signed POST request to /webhook-events, using the current time as the timestamp.
+func (s *Signer) NewWebhookEventRequest(body []byte) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return newRawRequest("/webhook-events", body, s.Sign(timestamp, body), timestamp)
+}
+
+// NewRawRequest builds a POST request to /interaction with the given signature headers. Empty headers are omitted.
+func NewRawRequest(body []byte, signature, timestamp string) *http.Request {
+	return newRawRequest("/interaction", body, signature, timestamp)
+}
+
+// NewRawWebhookEventRequest builds a POST request to /webhook-events with the given signature headers. Empty headers
+// are omitted.
+func NewRawWebhookEventRequest(body []byte, signature, timestamp string) *http.Request {
+	return newRawRequest("/webhook-events", body, signature, timestamp)
+}
+
+func newRawRequest(path string, body []byte, signature, timestamp string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if signature != "" {
+		req.Header.Set("X-Signature-Ed25519", signature)
+	}
+
+	if timestamp != "" {
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+	}
+
+	return req
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/gin-gonic/gin"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsMonths = 12
+	maxStatsMonths     = 120
+)
+
+// campaignStats are aggregates computed from the current subscribers of every provider
+type campaignStats struct {
+	GeneratedAt    time.Time      `json:"generated_at"`
+	Total          int            `json:"total"`
+	Active         int            `json:"active"`
+	ByProvider     map[string]int `json:"by_provider"`
+	ByStatus       map[string]int `json:"by_status"` // Keyed by status in Patreon's form, e.g. active_patron
+	ByChargeStatus map[string]int `json:"by_charge_status"`
+	// Active patrons only, keyed by tier name (or ID, if the tier has no configured name). Patrons entitled to more
+	// than one tier are counted once for each.
+	ActiveByTier map[string]int `json:"active_by_tier"`
+	NewPatrons   struct {
+		Last7Days  int            `json:"last_7_days"`
+		Last30Days int            `json:"last_30_days"`
+		ByMonth    map[string]int `json:"by_month"` // Keyed by YYYY-MM, for each of the requested number of months
+	} `json:"new_patrons"`
+	DiscordLinked      int     `json:"discord_linked"`
+	DiscordLinkedShare float64 `json:"discord_linked_share"` // Between 0 and 1
+	// The sum of the entitled amounts of active patrons, spread over each month of their billing period
+	EstimatedMonthlyRevenueCents int `json:"estimated_monthly_revenue_cents"`
+}
+
+// computeStats aggregates the current subscribers. The second return value is false if the initial subscriber data
+// has not been loaded yet.
+func (s *Server) computeStats(now time.Time, months int) (campaignStats, bool) {
+	if !s.directory.Loaded() {
+		return campaignStats{}, false
+	}
+
+	stats := campaignStats{
+		GeneratedAt:    now,
+		ByProvider:     make(map[string]int),
+		ByStatus:       make(map[string]int),
+		ByChargeStatus: make(map[string]int),
+		ActiveByTier:   make(map[string]int),
+	}
+
+	stats.NewPatrons.ByMonth = make(map[string]int, months)
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < months; i++ {
+		stats.NewPatrons.ByMonth[currentMonth.AddDate(0, -i, 0).Format("2006-01")] = 0
+	}
+
+	for _, patron := range s.directory.All() {
+		stats.Total++
+		stats.ByProvider[patron.Provider]++
+		if patron.Status == providers.StatusActive {
+			stats.Active++
+		}
+		stats.ByStatus[statusKey(patron.PatronStatus())]++
+		stats.ByChargeStatus[statusKey(patron.LastChargeStatus)]++
+
+		if patron.DiscordId != nil {
+			stats.DiscordLinked++
+		}
+
+		if patron.Active() {
+			for _, name := range s.config.TierNames(patron.Tiers) {
+				stats.ActiveByTier[name]++
+			}
+
+			stats.EstimatedMonthlyRevenueCents += patron.MonthlyAmountCentsIn(s.config.Currency)
+		}
+
+		if start := patron.StartedAt; !start.IsZero() {
+			age := now.Sub(start)
+			if age <= time.Hour*24*7 {
+				stats.NewPatrons.Last7Days++
+			}
+
+			if age <= time.Hour*24*30 {
+				stats.NewPatrons.Last30Days++
+			}
+
+			if month := start.UTC().Format("2006-01"); hasKey(stats.NewPatrons.ByMonth, month) {
+				stats.NewPatrons.ByMonth[month]++
+			}
+		}
+	}
+
+	if stats.Total > 0 {
+		stats.DiscordLinkedShare = float64(stats.DiscordLinked) / float64(stats.Total)
+	}
+
+	return stats, true
+}
+
+func statusKey(status string) string {
+	if status == "" {
+		return "none"
+	}
+
+	return status
+}
+
+func hasKey[K comparable, V any](m map[K]V, key K) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func handleStats(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	stats, ok := s.computeStats(time.Now(), 1)
+	if !ok {
+		return ephemeralResponse(localizer.T("errors.not_loaded"))
+	}
+
+	return messageResponse(interaction.ApplicationCommandCallbackData{
+		Embeds: []*embed.Embed{
+			{
+				Title:     localizer.T("stats.title"),
+				Timestamp: ptr(stats.GeneratedAt),
+				Color:     0x4287f5,
+				Fields: []*embed.EmbedField{
+					{Name: localizer.T("stats.field.total"), Value: strconv.Itoa(stats.Total), Inline: true},
+					{Name: localizer.T("stats.field.active"), Value: strconv.Itoa(stats.Active), Inline: true},
+					{
+						Name:   localizer.T("stats.field.revenue"),
+						Value:  fmt.Sprintf("%d.%02d", stats.EstimatedMonthlyRevenueCents/100, stats.EstimatedMonthlyRevenueCents%100),
+						Inline: true,
+					},
+					{Name: localizer.T("stats.field.by_tier"), Value: formatCounts(localizer, stats.ActiveByTier)},
+					{Name: localizer.T("stats.field.by_status"), Value: formatCounts(localizer, stats.ByStatus), Inline: true},
+					{Name: localizer.T("stats.field.by_charge_status"), Value: formatCounts(localizer, stats.ByChargeStatus), Inline: true},
+					{
+						Name:  localizer.T("stats.field.new_patrons"),
+						Value: localizer.T("stats.new_patrons", stats.NewPatrons.Last7Days, stats.NewPatrons.Last30Days),
+					},
+					{
+						Name:  localizer.T("stats.field.discord_linked"),
+						Value: fmt.Sprintf("%d (%.1f%%)", stats.DiscordLinked, stats.DiscordLinkedShare*100),
+					},
+				},
+			},
+		},
+		Flags: uint(message.FlagEphemeral),
+	})
+}
+
+// formatCounts lists counts in descending order, one per line
+func formatCounts(localizer i18n.Localizer, counts map[string]int) string {
+	if len(counts) == 0 {
+		return localizer.T("stats.none")
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("%s: %d", key, counts[key])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// HandleStats serves the campaign statistics over HTTP. The months query parameter sets how many months of new
+// patron counts are returned.
+func (s *Server) HandleStats(ctx *gin.Context) {
+	months := defaultStatsMonths
+	if raw := ctx.Query("months"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxStatsMonths {
+			ctx.JSON(http.StatusBadRequest, errorJson(fmt.Sprintf("months must be between 1 and %d", maxStatsMonths)))
+			return
+		}
+
+		months = parsed
+	}
+
+	stats, ok := s.computeStats(time.Now(), months)
+	if !ok {
+		ctx.JSON(http.StatusServiceUnavailable, errorJson("Pledge data has not been loaded yet"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatsApi(t *testing.T) {
+	conf := testConfig()
+	conf.Api.Keys = []string{testApiKey}
+
+	discordId := uint64(67890)
+	joined := time.Now().AddDate(0, 0, -3)
+
+	pledges := exportPledges()
+	pledges["new@example.com"] = patreon.Patron{
+		Attributes: patreon.Attributes{
+			Email:                        "new@example.com",
+			LastChargeStatus:             "Paid",
+			PatronStatus:                 patreon.StatusActive,
+			PledgeRelationshipStart:      joined,
+			CurrentlyEntitledAmountCents: 550,
+		},
+		Id:        3,
+		Tiers:     []uint64{1, 2},
+		DiscordId: &discordId,
+	}
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(pledges)
+
+	recorder := apiRequest(t, h, "/api/v1/stats?months=2", testApiKey)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var stats struct {
+		Total          int            `json:"total"`
+		Active         int            `json:"active"`
+		ByStatus       map[string]int `json:"by_status"`
+		ByChargeStatus map[string]int `json:"by_charge_status"`
+		ActiveByTier   map[string]int `json:"active_by_tier"`
+		NewPatrons     struct {
+			Last7Days  int            `json:"last_7_days"`
+			Last30Days int            `json:"last_30_days"`
+			ByMonth    map[string]int `json:"by_month"`
+		} `json:"new_patrons"`
+		DiscordLinked                int     `json:"discord_linked"`
+		DiscordLinkedShare           float64 `json:"discord_linked_share"`
+		EstimatedMonthlyRevenueCents int     `json:"estimated_monthly_revenue_cents"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total != 3 || stats.Active != 2 || stats.ByStatus[patreon.StatusActive] != 2 || stats.ByStatus[patreon.StatusDeclined] != 1 {
+		t.Errorf("unexpected status counts: total %d, %v", stats.Total, stats.ByStatus)
+	}
+
+	if stats.ByChargeStatus["Paid"] != 2 || stats.ByChargeStatus["Declined"] != 1 {
+		t.Errorf("unexpected charge status counts: %v", stats.ByChargeStatus)
+	}
+
+	// The declined patron's tier is not counted, and tier 2 has no configured name
+	if len(stats.ActiveByTier) != 2 || stats.ActiveByTier["Premium"] != 2 || stats.ActiveByTier["2"] != 1 {
+		t.Errorf("unexpected tier counts: %v", stats.ActiveByTier)
+	}
+
+	if stats.NewPatrons.Last7Days != 1 || stats.NewPatrons.Last30Days != 1 || len(stats.NewPatrons.ByMonth) != 2 {
+		t.Errorf("unexpected new patron counts: %+v", stats.NewPatrons)
+	}
+
+	if count := stats.NewPatrons.ByMonth[joined.UTC().Format("2006-01")]; count != 1 {
+		t.Errorf("expected 1 new patron in the month joined, got %d", count)
+	}
+
+	if stats.DiscordLinked != 2 || stats.DiscordLinkedShare < 0.66 || stats.DiscordLinkedShare > 0.67 {
+		t.Errorf("unexpected Discord linked stats: %d, %f", stats.DiscordLinked, stats.DiscordLinkedShare)
+	}
+
+	if stats.EstimatedMonthlyRevenueCents != 550 {
+		t.Errorf("expected revenue of 550 cents, got %d", stats.EstimatedMonthlyRevenueCents)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/stats?months=0", testApiKey); recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid months, got %d", recorder.Code)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	const adminRoleId = 600
+
+	conf := testConfig()
+	conf.Discord.AdminRoles = []uint64{adminRoleId}
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(exportPledges())
+
+	res := servertest.DecodeResponse(t, h.Do(servertest.Command{
+		GuildId: allowedGuildId,
+		UserId:  1,
+		Roles:   []uint64{adminRoleId},
+		Name:    "stats",
+	}.Payload()))
+
+	if len(res.Data.Embeds) != 1 || res.Data.Flags != 64 {
+		t.Fatalf("expected 1 ephemeral embed, got %+v", res.Data)
+	}
+
+	fields := make(map[string]string)
+	for _, field := range res.Data.Embeds[0].Fields {
+		fields[field.Name] = field.Value
+	}
+
+	if fields["Patrons"] != "2" || fields["Active"] != "1" || fields["Active Patrons by Tier"] != "Premium: 1" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if fields["Discord Linked"] != "1 (50.0%)" {
+		t.Errorf("unexpected Discord linked field %q", fields["Discord Linked"])
+	}
+}
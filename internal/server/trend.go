@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/history"
+	"github.com/TicketsBot/subscriptions-app/internal/i18n"
+	"github.com/gin-gonic/gin"
+	"github.com/rxdn/gdl/objects/channel/embed"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"github.com/rxdn/gdl/rest/request"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTrendDays = 30
+	maxTrendDays     = 366
+	defaultApiDays   = 90
+	maxApiDays       = 366 * 3
+
+	trendFormatPng = "png"
+)
+
+// trendMetrics maps the metric option values of /trend to the rollup value they plot
+var trendMetrics = map[string]func(history.Rollup) float64{
+	"active":      func(r history.Rollup) float64 { return float64(r.Active) },
+	"total":       func(r history.Rollup) float64 { return float64(r.Total) },
+	"declined":    func(r history.Rollup) float64 { return float64(r.Declined) },
+	"revenue":     func(r history.Rollup) float64 { return float64(r.RevenueCents) / 100 },
+	"new_pledges": func(r history.Rollup) float64 { return float64(r.NewPledges) },
+	"churned":     func(r history.Rollup) float64 { return float64(r.Churned) },
+	"declines":    func(r history.Rollup) float64 { return float64(r.Declines) },
+}
+
+func handleTrend(s *Server, app Application, data interaction.ApplicationCommandInteraction) commandResponse {
+	command := data.Data
+	localizer := s.localizer(data)
+
+	if !s.isAdmin(data) {
+		return ephemeralResponse(localizer.T("errors.admin_only"))
+	}
+
+	metric := stringOption(command.Options, "metric")
+	if metric == "" {
+		metric = "active"
+	}
+
+	value, ok := trendMetrics[metric]
+	if !ok {
+		return ephemeralResponse(localizer.T("trend.invalid_metric", metric))
+	}
+
+	days := defaultTrendDays
+	if option, ok := intOption(command.Options, "days"); ok {
+		if option < 2 || option > maxTrendDays {
+			return ephemeralResponse(localizer.T("trend.invalid_days", maxTrendDays))
+		}
+
+		days = option
+	}
+
+	// Restricting to a tier only makes sense for the number of active patrons
+	if rawTier := stringOption(command.Options, "tier"); rawTier != "" {
+		tier, err := strconv.ParseUint(rawTier, 10, 64)
+		if err != nil || metric != "active" {
+			return ephemeralResponse(localizer.T("trend.invalid_tier"))
+		}
+
+		value = func(r history.Rollup) float64 { return float64(r.ActiveByTier[tier]) }
+	}
+
+	now := time.Now()
+	rollups := s.history.Rollups(now.AddDate(0, 0, -(days-1)), now)
+	if len(rollups) == 0 {
+		return ephemeralResponse(localizer.T("trend.no_data"))
+	}
+
+	values := make([]float64, len(rollups))
+	for i, rollup := range rollups {
+		values[i] = value(rollup)
+	}
+
+	e := trendEmbed(localizer, metric, days, rollups, values)
+
+	var files []request.Attachment
+	if stringOption(command.Options, "format") == trendFormatPng {
+		chart, err := history.RenderChart(values, 800, 300)
+		if err != nil {
+			s.logger.Error("Failed to render trend chart", zap.Error(err))
+			return ephemeralResponse(localizer.T("errors.internal"))
+		}
+
+		e.Image = &embed.EmbedImage{Url: "attachment://trend.png"}
+		files = append(files, request.Attachment{
+			FileName: "trend.png",
+			File: request.File{
+				ContentType: "image/png",
+				Reader:      bytes.NewReader(chart),
+			},
+		})
+	} else {
+		e.Description = fmt.Sprintf("```\n%s\n```", history.Sparkline(values))
+	}
+
+	res := messageResponse(interaction.ApplicationCommandCallbackData{
+		Embeds: []*embed.Embed{e},
+		Flags:  uint(message.FlagEphemeral),
+	})
+	res.Files = files
+
+	return res
+}
+
+func trendEmbed(localizer i18n.Localizer, metric string, days int, rollups []history.Rollup, values []float64) *embed.Embed {
+	first, last := values[0], values[len(values)-1]
+
+	min, max := first, first
+	for _, value := range values {
+		if value < min {
+			min = value
+		}
+
+		if value > max {
+			max = value
+		}
+	}
+
+	change := formatTrendValue(last - first)
+	if last >= first {
+		change = "+" + change
+	}
+
+	if first != 0 {
+		change += fmt.Sprintf(" (%+.1f%%)", (last-first)/first*100)
+	}
+
+	return &embed.Embed{
+		Title:     localizer.T("trend.title", localizer.T("trend.metric."+metric), days),
+		Timestamp: ptr(time.Now()),
+		Color:     0x4287f5,
+		Fields: []*embed.EmbedField{
+			{Name: localizer.T("trend.field.start", rollups[0].Date), Value: formatTrendValue(first), Inline: true},
+			{Name: localizer.T("trend.field.end", rollups[len(rollups)-1].Date), Value: formatTrendValue(last), Inline: true},
+			{Name: localizer.T("trend.field.change"), Value: change, Inline: true},
+			{Name: localizer.T("trend.field.min"), Value: formatTrendValue(min), Inline: true},
+			{Name: localizer.T("trend.field.max"), Value: formatTrendValue(max), Inline: true},
+		},
+	}
+}
+
+// formatTrendValue prints whole numbers without decimals, and revenue to 2 decimal places
+func formatTrendValue(value float64) string {
+	if value == float64(int64(value)) {
+		return strconv.FormatInt(int64(value), 10)
+	}
+
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// HandleHistory serves the daily rollups over HTTP. Either from and to (YYYY-MM-DD, inclusive) or days may be
+// given, defaulting to the last 90 days.
+func (s *Server) HandleHistory(ctx *gin.Context) {
+	to := time.Now()
+	if raw := ctx.Query("to"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, errorJson("to must be a date in the format YYYY-MM-DD"))
+			return
+		}
+
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -(defaultApiDays - 1))
+	if raw := ctx.Query("from"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, errorJson("from must be a date in the format YYYY-MM-DD"))
+			return
+		}
+
+		from = parsed
+	} else if raw := ctx.Query("days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 1 || days > maxApiDays {
+			ctx.JSON(http.StatusBadRequest, errorJson(fmt.Sprintf("days must be between 1 and %d", maxApiDays)))
+			return
+		}
+
+		from = to.AddDate(0, 0, -(days - 1))
+	}
+
+	rollups := s.history.Rollups(from, to)
+	if rollups == nil {
+		rollups = []history.Rollup{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"rollups": rollups,
+	})
+}
@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrend(t *testing.T) {
+	const adminRoleId = 600
+
+	conf := testConfig()
+	conf.Discord.AdminRoles = []uint64{adminRoleId}
+	conf.Api.Keys = []string{testApiKey}
+
+	trend := func(options ...servertest.Option) []byte {
+		return servertest.Command{
+			GuildId: allowedGuildId,
+			UserId:  1,
+			Roles:   []uint64{adminRoleId},
+			Name:    "trend",
+			Options: options,
+		}.Payload()
+	}
+
+	t.Run("no data", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+
+		res := servertest.DecodeResponse(t, h.Do(trend()))
+		if res.Data.Content != "No history has been recorded for this period yet" {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(trend(servertest.StringOption("metric", "total"))))
+		if len(res.Data.Embeds) != 1 {
+			t.Fatalf("expected 1 embed, got %+v", res.Data)
+		}
+
+		e := res.Data.Embeds[0]
+		if e.Title != "All patrons, last 30 days" || !strings.Contains(e.Description, "▁") {
+			t.Errorf("unexpected embed %+v", e)
+		}
+
+		today := time.Now().UTC().Format("2006-01-02")
+		if e.Fields[1].Name != "End ("+today+")" || e.Fields[1].Value != "2" || e.Fields[2].Value != "+0 (+0.0%)" {
+			t.Errorf("unexpected fields %+v", e.Fields)
+		}
+	})
+
+	t.Run("chart", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res, files := servertest.DecodeMultipartResponse(t, h.Do(trend(servertest.StringOption("format", "png"))))
+		if len(res.Data.Embeds) != 1 {
+			t.Fatalf("expected 1 embed, got %+v", res.Data)
+		}
+
+		if png := files["trend.png"]; len(png) < 8 || string(png[1:4]) != "PNG" {
+			t.Errorf("expected a PNG attachment, got %d bytes", len(png))
+		}
+	})
+
+	t.Run("tier with wrong metric", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		res := servertest.DecodeResponse(t, h.Do(trend(
+			servertest.StringOption("metric", "revenue"),
+			servertest.StringOption("tier", "1"),
+		)))
+		if !strings.HasPrefix(res.Data.Content, "The tier option must be a tier ID") {
+			t.Errorf("unexpected content %q", res.Data.Content)
+		}
+	})
+
+	t.Run("api", func(t *testing.T) {
+		h := servertest.NewHarness(t, conf)
+		h.SetPledges(exportPledges())
+
+		recorder := apiRequest(t, h, "/api/v1/history?days=7", testApiKey)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", recorder.Code)
+		}
+
+		var body struct {
+			Rollups []struct {
+				Date         string         `json:"date"`
+				Active       int            `json:"active"`
+				ActiveByTier map[string]int `json:"active_by_tier"`
+			} `json:"rollups"`
+		}
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Rollups) != 1 || body.Rollups[0].Active != 1 || body.Rollups[0].ActiveByTier["1"] != 1 {
+			t.Errorf("unexpected rollups %+v", body.Rollups)
+		}
+
+		if recorder := apiRequest(t, h, "/api/v1/history?from=yesterday", testApiKey); recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for invalid date, got %d", recorder.Code)
+		}
+	})
+}
@@ -1,6 +1,12 @@
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rxdn/gdl/objects/channel/message"
+	"github.com/rxdn/gdl/objects/interaction"
+	"strconv"
+	"strings"
+)
 
 func errorJson(message string) gin.H {
 	return gin.H{
@@ -21,3 +27,95 @@ func contains[T comparable](slice []T, item T) bool {
 func ptr[T any](value T) *T {
 	return &value
 }
+
+func ephemeralResponse(content string) commandResponse {
+	return messageResponse(interaction.ApplicationCommandCallbackData{
+		Content: content,
+		Flags:   uint(message.FlagEphemeral),
+	})
+}
+
+// interactionUserId returns the ID of the user who invoked the command, whether in a guild or a DM
+func interactionUserId(data interaction.ApplicationCommandInteraction) uint64 {
+	if data.Member != nil {
+		return data.Member.User.Id
+	} else if data.User != nil {
+		return data.User.Id
+	}
+
+	return 0
+}
+
+func findOption(options []interaction.ApplicationCommandInteractionDataOption, name string) (interaction.ApplicationCommandInteractionDataOption, bool) {
+	for _, option := range options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+
+	return interaction.ApplicationCommandInteractionDataOption{}, false
+}
+
+// boolOption returns the value of a boolean option, or false if it was not provided
+func boolOption(options []interaction.ApplicationCommandInteractionDataOption, name string) bool {
+	option, ok := findOption(options, name)
+	if !ok {
+		return false
+	}
+
+	value, _ := option.Value.(bool)
+	return value
+}
+
+func stringOption(options []interaction.ApplicationCommandInteractionDataOption, name string) string {
+	option, ok := findOption(options, name)
+	if !ok {
+		return ""
+	}
+
+	value, _ := option.Value.(string)
+	return value
+}
+
+// snowflakeOption returns the ID referenced by a user, channel or role option, which is sent as a string
+func snowflakeOption(options []interaction.ApplicationCommandInteractionDataOption, name string) (uint64, bool) {
+	id, err := strconv.ParseUint(stringOption(options, name), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// intOption returns the value of an integer option, which is decoded from JSON as a float64
+func intOption(options []interaction.ApplicationCommandInteractionDataOption, name string) (int, bool) {
+	option, ok := findOption(options, name)
+	if !ok {
+		return 0, false
+	}
+
+	value, ok := option.Value.(float64)
+	return int(value), ok
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func containsFold(slice []string, item string) bool {
+	for _, i := range slice {
+		if strings.EqualFold(i, item) {
+			return true
+		}
+	}
+
+	return false
+}
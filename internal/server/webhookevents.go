@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/discord"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Values of webhookEvent.Type
+const (
+	webhookEventTypePing  = 0
+	webhookEventTypeEvent = 1
+)
+
+const (
+	eventEntitlementCreate = "ENTITLEMENT_CREATE"
+	eventEntitlementUpdate = "ENTITLEMENT_UPDATE"
+	eventEntitlementDelete = "ENTITLEMENT_DELETE"
+)
+
+// webhookEvent is the payload Discord sends to the webhook events URL of an application
+type webhookEvent struct {
+	Version       int    `json:"version"`
+	ApplicationId uint64 `json:"application_id,string"`
+	Type          int    `json:"type"`
+	Event         *struct {
+		Type      string          `json:"type"`
+		Timestamp string          `json:"timestamp"`
+		Data      json.RawMessage `json:"data"`
+	} `json:"event"`
+}
+
+// HandleWebhookEvent receives events from Discord, of which only entitlement events are used. Discord expects a 204
+// response to every event, including those that are ignored.
+func (s *Server) HandleWebhookEvent(ctx *gin.Context) {
+	var body webhookEvent
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJson("Failed to parse body"))
+		return
+	}
+
+	if body.Type == webhookEventTypePing || body.Event == nil {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+
+	switch body.Event.Type {
+	case eventEntitlementCreate, eventEntitlementUpdate, eventEntitlementDelete:
+		if s.discord == nil {
+			s.logger.Debug("Ignoring entitlement event, as Discord subscriptions are disabled", zap.String("type", body.Event.Type))
+			break
+		}
+
+		var entitlement discord.Entitlement
+		if err := json.Unmarshal(body.Event.Data, &entitlement); err != nil {
+			ctx.JSON(http.StatusBadRequest, errorJson("Failed to parse entitlement"))
+			return
+		}
+
+		if body.Event.Type == eventEntitlementDelete {
+			entitlement.Deleted = true
+		}
+
+		s.logger.Info(
+			"Received entitlement event",
+			zap.String("type", body.Event.Type),
+			zap.Uint64("entitlement_id", entitlement.Id),
+			zap.Uint64("user_id", entitlement.UserId),
+		)
+
+		if subscriber, ok := s.discord.Subscriber(time.Now(), entitlement); ok {
+			s.ApplyUpdate(providers.Update{
+				Provider:    discord.Name,
+				Subscribers: []providers.Subscriber{subscriber},
+			})
+		}
+	default:
+		s.logger.Debug("Ignoring webhook event", zap.String("type", body.Event.Type))
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+type skuResponse struct {
+	Id            uint64 `json:"id,string"`
+	ApplicationId uint64 `json:"application_id,string"`
+	Type          int    `json:"type"`
+	Name          string `json:"name"`
+	TierName      string `json:"tier_name,omitempty"` // Empty if the SKU is not listed in the configured tiers
+}
+
+// HandleDiscordSkus lists the SKUs of each application as of the last fetch, so that they can be added to the
+// configured tiers.
+func (s *Server) HandleDiscordSkus(ctx *gin.Context) {
+	if s.discord == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("Discord subscriptions are not enabled"))
+		return
+	}
+
+	skus := s.discord.Skus()
+	res := make([]skuResponse, len(skus))
+	for i, sku := range skus {
+		res[i] = skuResponse{
+			Id:            sku.Id,
+			ApplicationId: sku.ApplicationId,
+			Type:          sku.Type,
+			Name:          sku.Name,
+			TierName:      s.config.Tiers[sku.Id],
+		}
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/discord"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const whitelabelSku = 300
+
+func entitlementEvent(eventType string, id, userId uint64, endsAt time.Time) []byte {
+	return []byte(fmt.Sprintf(
+		`{"version":1,"application_id":"1","type":1,"event":{"type":%q,"timestamp":"2024-01-01T00:00:00","data":`+
+			`{"id":"%d","sku_id":"%d","application_id":"1","user_id":"%d","type":8,"deleted":false,"ends_at":%q}}}`,
+		eventType, id, whitelabelSku, userId, endsAt.Format(time.RFC3339),
+	))
+}
+
+func TestWebhookEvents(t *testing.T) {
+	signer, err := servertest.NewSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := entitlementConfig()
+	conf.Discord.Applications = []config.Application{{ApplicationId: 1, PublicKey: signer.PublicKeyHex(), BotToken: "token"}}
+	conf.DiscordSubscriptions.Enabled = true
+	conf.Tiers[whitelabelSku] = "Whitelabel"
+	conf.Entitlements = append(conf.Entitlements, config.TierEntitlement{TierId: whitelabelSku, Type: "whitelabel", MaxGuilds: 1, Priority: 2})
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	// Lookups do not wait for Discord's entitlements to be fetched
+	if recorder := apiRequest(t, h, "/api/v1/patrons/email/patron@example.com", testApiKey); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 before the first fetch, got %d", recorder.Code)
+	}
+
+	h.Server.ApplyUpdate(providers.Update{Provider: discord.Name, Full: true})
+
+	send := func(body []byte) {
+		t.Helper()
+
+		if recorder := h.DoRequest(signer.NewWebhookEventRequest(body)); recorder.Code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+
+	type patron struct {
+		Provider    string `json:"provider"`
+		Status      string `json:"status"`
+		Entitlement *struct {
+			Type   string `json:"type"`
+			Source string `json:"source"`
+		} `json:"entitlement"`
+	}
+
+	getPatron := func(path string) patron {
+		t.Helper()
+
+		recorder := apiRequest(t, h, path, testApiKey)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		var got patron
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return got
+	}
+
+	send([]byte(`{"version":1,"application_id":"1","type":0}`))
+
+	// A Discord subscription for the patron's linked account is shown alongside their pledge, and ranks above it
+	renews := time.Now().AddDate(0, 1, 0)
+	send(entitlementEvent("ENTITLEMENT_CREATE", 1, 12345, renews))
+
+	if got := getPatron("/api/v1/patrons/email/patron@example.com"); got.Provider != "patreon" || got.Entitlement == nil || got.Entitlement.Source != "discord" || got.Entitlement.Type != "whitelabel" {
+		t.Errorf("expected the Discord subscription's entitlement, got %+v", got)
+	}
+
+	res := servertest.DecodeResponse(t, h.DoRequest(signer.NewRequest(lookup(allowedGuildId, "patron@example.com"))))
+	if len(res.Data.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %+v", res.Data)
+	}
+
+	var found bool
+	for _, field := range res.Data.Embeds[0].Fields {
+		if field.Name == "Other Subscriptions" {
+			found = true
+
+			if field.Value != "Discord: Active (Whitelabel)" {
+				t.Errorf("unexpected other subscriptions %q", field.Value)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("missing other subscriptions field")
+	}
+
+	// Users who only subscribe through Discord are found by their Discord ID, until the entitlement is deleted
+	send(entitlementEvent("ENTITLEMENT_CREATE", 2, 777, renews))
+	if got := getPatron("/api/v1/patrons/discord/777"); got.Provider != "discord" || got.Status != "active_patron" || got.Entitlement == nil {
+		t.Errorf("unexpected Discord subscriber %+v", got)
+	}
+
+	send(entitlementEvent("ENTITLEMENT_DELETE", 2, 777, renews))
+	if got := getPatron("/api/v1/patrons/discord/777"); got.Status != "former_patron" || got.Entitlement != nil {
+		t.Errorf("expected the deleted entitlement to be former, got %+v", got)
+	}
+
+	// Other events are acknowledged and ignored
+	send([]byte(`{"version":1,"application_id":"1","type":1,"event":{"type":"APPLICATION_AUTHORIZED","data":{}}}`))
+
+	if recorder := apiRequest(t, h, "/api/v1/discord/skus", testApiKey); recorder.Code != http.StatusOK || recorder.Body.String() != "[]" {
+		t.Errorf("expected no SKUs before the first fetch, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWebhookEventsDisabled(t *testing.T) {
+	h := servertest.NewHarness(t, entitlementConfig())
+	h.SetPledges(testPledges())
+
+	if recorder := h.DoRequest(h.Signer.NewWebhookEventRequest(entitlementEvent("ENTITLEMENT_CREATE", 1, 777, time.Now().AddDate(0, 1, 0)))); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", recorder.Code)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/patrons/discord/777", testApiKey); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected the event to be ignored, got %d", recorder.Code)
+	}
+
+	if recorder := apiRequest(t, h, "/api/v1/discord/skus", testApiKey); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected SKUs to be unavailable, got %d", recorder.Code)
+	}
+}
+
+func TestWebhookEventReplayProtection(t *testing.T) {
+	signer, err := servertest.NewSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := entitlementConfig()
+	conf.Discord.Applications = []config.Application{{ApplicationId: 1, PublicKey: signer.PublicKeyHex(), BotToken: "token"}}
+	conf.DiscordSubscriptions.Enabled = true
+
+	h := servertest.NewHarness(t, conf)
+	h.SetPledges(testPledges())
+
+	body := entitlementEvent("ENTITLEMENT_CREATE", 1, 12345, time.Now().AddDate(0, 1, 0))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if recorder := h.DoRequest(servertest.NewRawWebhookEventRequest(body, signer.Sign(timestamp, body), timestamp)); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Webhook events have no ID, so the same event re-signed with a different timestamp is recognised by its content
+	otherTimestamp := strconv.FormatInt(time.Now().Add(-time.Second*30).Unix(), 10)
+	if recorder := h.DoRequest(servertest.NewRawWebhookEventRequest(body, signer.Sign(otherTimestamp, body), otherTimestamp)); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected the replayed event to be rejected, got %d", recorder.Code)
+	}
+
+	other := entitlementEvent("ENTITLEMENT_DELETE", 1, 12345, time.Now().AddDate(0, 1, 0))
+	if recorder := h.DoRequest(signer.NewWebhookEventRequest(other)); recorder.Code != http.StatusNoContent {
+		t.Errorf("expected a different event to be accepted, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/kofi"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/stripe"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxWebhookBodySize is far larger than any event Stripe or Ko-fi send
+const maxWebhookBodySize = 1 << 20
+
+// HandleStripeWebhook receives subscription and invoice events from Stripe. Anything other than a 2xx response is
+// retried by Stripe, so errors recording an event are returned as a 500.
+func (s *Server) HandleStripeWebhook(ctx *gin.Context) {
+	if s.stripe == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("Stripe is not configured"))
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxWebhookBodySize))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJson("Failed to read body"))
+		return
+	}
+
+	subscribers, err := s.stripe.HandleWebhook(ctx, time.Now(), payload, ctx.GetHeader("Stripe-Signature"))
+	if err != nil {
+		if errors.Is(err, stripe.ErrInvalidSignature) {
+			ctx.JSON(http.StatusUnauthorized, errorJson("Invalid signature"))
+			return
+		}
+
+		s.logger.Error("Failed to handle Stripe event", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, errorJson("Failed to handle event"))
+		return
+	}
+
+	s.applyWebhookUpdate(stripe.Name, subscribers)
+	ctx.Status(http.StatusOK)
+}
+
+// HandleKofiWebhook receives donations and membership payments from Ko-fi, which are sent as a form with the payment
+// in its data field. Ko-fi retries the request unless it receives a 200.
+func (s *Server) HandleKofiWebhook(ctx *gin.Context) {
+	if s.kofi == nil {
+		ctx.JSON(http.StatusNotFound, errorJson("Ko-fi is not configured"))
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxWebhookBodySize)
+
+	data := ctx.PostForm("data")
+	if data == "" {
+		ctx.JSON(http.StatusBadRequest, errorJson("Missing data"))
+		return
+	}
+
+	subscribers, err := s.kofi.HandleWebhook(time.Now(), data)
+	if err != nil {
+		if errors.Is(err, kofi.ErrInvalidToken) {
+			ctx.JSON(http.StatusUnauthorized, errorJson("Invalid verification token"))
+			return
+		}
+
+		s.logger.Error("Failed to handle Ko-fi payment", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, errorJson("Failed to handle payment"))
+		return
+	}
+
+	s.applyWebhookUpdate(kofi.Name, subscribers)
+	ctx.Status(http.StatusOK)
+}
+
+func (s *Server) applyWebhookUpdate(provider string, subscribers []providers.Subscriber) {
+	if len(subscribers) == 0 {
+		return
+	}
+
+	s.ApplyUpdate(providers.Update{
+		Provider:    provider,
+		Subscribers: subscribers,
+	})
+}
@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/internal/providers"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/kofi"
+	"github.com/TicketsBot/subscriptions-app/internal/providers/stripe"
+	"github.com/TicketsBot/subscriptions-app/internal/server/servertest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testStripeSecret = "whsec_test"
+	testKofiToken    = "kofi_token"
+	directTier       = 400
+)
+
+func webhookConfig() config.Config {
+	conf := entitlementConfig()
+	conf.Stripe.WebhookSecret = testStripeSecret
+	conf.Stripe.Prices = map[string]uint64{"price_premium": directTier}
+	conf.Kofi.VerificationToken = testKofiToken
+	conf.Kofi.Tiers = map[string]uint64{"Supporter": directTier}
+	conf.Tiers[directTier] = "Direct"
+	conf.Entitlements = append(conf.Entitlements, config.TierEntitlement{TierId: directTier, Type: "premium", MaxGuilds: 1, Priority: 1})
+
+	return conf
+}
+
+func stripeRequest(t *testing.T, payload []byte, secret string) *http.Request {
+	t.Helper()
+
+	timestamp := time.Now().Unix()
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s", timestamp, payload)))
+
+	req, err := http.NewRequest(http.MethodPost, "/webhooks/stripe", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil))))
+	return req
+}
+
+func kofiRequest(t *testing.T, payment kofi.Payment) *http.Request {
+	t.Helper()
+
+	data, err := json.Marshal(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/webhooks/kofi", strings.NewReader(url.Values{"data": {string(data)}}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestPaymentWebhooks(t *testing.T) {
+	h := servertest.NewHarness(t, webhookConfig())
+	h.SetPledges(testPledges())
+
+	// Both providers send their recorded subscribers when watched, of which there are none yet
+	h.Server.ApplyUpdate(providers.Update{Provider: stripe.Name, Full: true})
+	h.Server.ApplyUpdate(providers.Update{Provider: kofi.Name, Full: true})
+
+	payload := []byte(fmt.Sprintf(
+		`{"id":"evt_1","type":"customer.subscription.created","created":%d,"data":{"object":`+
+			`{"id":"sub_1","customer":"cus_1","status":"active","start_date":%d,"current_period_end":%d,"metadata":{"discord_id":"555"},`+
+			`"items":{"data":[{"quantity":1,"price":{"id":"price_premium","unit_amount":500}}]}}}}`,
+		time.Now().Unix(), time.Now().Unix(), time.Now().AddDate(0, 1, 0).Unix(),
+	))
+
+	if recorder := h.DoRequest(stripeRequest(t, payload, "whsec_other")); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for an invalid signature, got %d", recorder.Code)
+	}
+
+	if recorder := h.DoRequest(stripeRequest(t, payload, testStripeSecret)); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The invoice supplies the customer's email address
+	invoice := []byte(`{"id":"evt_2","type":"invoice.paid","created":1,"data":{"object":{"id":"in_1","customer":"cus_1","customer_email":"stripe@example.com","subscription":"sub_1"}}}`)
+	if recorder := h.DoRequest(stripeRequest(t, invoice, testStripeSecret)); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	type patron struct {
+		Provider    string `json:"provider"`
+		Email       string `json:"email"`
+		Status      string `json:"status"`
+		Entitlement *struct {
+			Type   string `json:"type"`
+			TierId uint64 `json:"tier_id"`
+		} `json:"entitlement"`
+	}
+
+	getPatron := func(path string) patron {
+		t.Helper()
+
+		recorder := apiRequest(t, h, path, testApiKey)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		var got patron
+		if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return got
+	}
+
+	for _, path := range []string{"/api/v1/patrons/discord/555", "/api/v1/patrons/email/stripe@example.com"} {
+		if got := getPatron(path); got.Provider != stripe.Name || got.Status != "active_patron" || got.Entitlement == nil || got.Entitlement.TierId != directTier {
+			t.Errorf("%s: unexpected Stripe subscriber %+v", path, got)
+		}
+	}
+
+	res := servertest.DecodeResponse(t, h.Do(lookup(allowedGuildId, "stripe@example.com")))
+	if len(res.Data.Embeds) != 1 {
+		t.Errorf("expected the Stripe subscriber to be found by /lookup, got %+v", res.Data)
+	}
+
+	// Ko-fi payments are verified by the token included in them
+	payment := kofi.Payment{
+		VerificationToken:     "wrong",
+		Timestamp:             time.Now(),
+		Type:                  kofi.TypeSubscription,
+		Email:                 "kofi@example.com",
+		Amount:                "5.00",
+		IsSubscriptionPayment: true,
+		TierName:              "Supporter",
+		DiscordUserId:         "666",
+	}
+
+	if recorder := h.DoRequest(kofiRequest(t, payment)); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for an invalid token, got %d", recorder.Code)
+	}
+
+	payment.VerificationToken = testKofiToken
+	if recorder := h.DoRequest(kofiRequest(t, payment)); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if got := getPatron("/api/v1/patrons/discord/666"); got.Provider != kofi.Name || got.Email != "kofi@example.com" || got.Entitlement == nil {
+		t.Errorf("unexpected Ko-fi supporter %+v", got)
+	}
+
+	// A distinct interaction ID, so that the lookup is not rejected as a replay of the first
+	res = servertest.DecodeResponse(t, h.Do(servertest.Command{
+		Id:      2,
+		GuildId: allowedGuildId,
+		UserId:  1,
+		Name:    "lookup",
+		Options: []servertest.Option{servertest.StringOption("email", "kofi@example.com")},
+	}.Payload()))
+	if len(res.Data.Embeds) != 1 {
+		t.Errorf("expected the Ko-fi supporter to be found by /lookup, got %+v", res.Data)
+	}
+}
+
+func TestPaymentWebhooksDisabled(t *testing.T) {
+	h := servertest.NewHarness(t, entitlementConfig())
+
+	if recorder := h.DoRequest(stripeRequest(t, []byte(`{}`), testStripeSecret)); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for Stripe, got %d", recorder.Code)
+	}
+
+	if recorder := h.DoRequest(kofiRequest(t, kofi.Payment{VerificationToken: testKofiToken})); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for Ko-fi, got %d", recorder.Code)
+	}
+}
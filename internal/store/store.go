@@ -0,0 +1,111 @@
+// Package store persists small amounts of state as JSON documents in a local data directory. Each document is read
+// and written in full, so it is only suitable for data that comfortably fits in memory.
+package store
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_\-]+$`)
+
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Open creates the data directory if it does not already exist.
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("data directory not set")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed to create data directory")
+	}
+
+	return &Store{
+		dir: dir,
+	}, nil
+}
+
+// Load decodes the named document into v. If the document does not exist, v is left unchanged and no error is
+// returned.
+func (s *Store) Load(name string, v any) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to open %s", name)
+	}
+
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode %s", name)
+	}
+
+	return nil
+}
+
+// Save encodes v as the named document. The document is written to a temporary file first and renamed into place,
+// so a crash part way through never leaves a truncated document behind.
+func (s *Store) Save(name string, v any) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %s", name)
+	}
+
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to replace %s", name)
+	}
+
+	return nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !namePattern.MatchString(name) {
+		return "", errors.Errorf("invalid document name %q", name)
+	}
+
+	return filepath.Join(s.dir, name+".json"), nil
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type document struct {
+		Values map[string]int `json:"values"`
+	}
+
+	var missing document
+	if err := s.Load("missing", &missing); err != nil || missing.Values != nil {
+		t.Fatalf("expected missing document to load as zero value, got %+v, %v", missing, err)
+	}
+
+	if err := s.Save("doc", document{Values: map[string]int{"a": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded document
+	if err := s.Load("doc", &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Values["a"] != 1 {
+		t.Errorf("expected loaded value 1, got %+v", loaded)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "doc.json" {
+		t.Errorf("expected only doc.json in the data directory, got %v", entries)
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Save("../escape", struct{}{}); err == nil {
+		t.Error("expected error for invalid document name")
+	}
+}
@@ -0,0 +1,86 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Supervisor runs long-lived background tasks, restarting them if they panic, and allows the caller to wait for
+// all tasks to exit once the context passed to them has been cancelled.
+type Supervisor struct {
+	logger       *zap.Logger
+	restartDelay time.Duration
+	wg           sync.WaitGroup
+}
+
+type Task func(ctx context.Context)
+
+func New(logger *zap.Logger, restartDelay time.Duration) *Supervisor {
+	return &Supervisor{
+		logger:       logger,
+		restartDelay: restartDelay,
+	}
+}
+
+// Go starts the task in a new goroutine. If the task panics, the panic is logged and the task is restarted after
+// the restart delay. If the task returns normally, it is not restarted.
+func (s *Supervisor) Go(ctx context.Context, name string, task Task) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			if !s.run(ctx, name, task) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.restartDelay):
+				s.logger.Info("Restarting task", zap.String("task", name))
+			}
+		}
+	}()
+}
+
+// Wait blocks until all tasks have exited, or the context is cancelled. Returns false if the context was cancelled
+// before all tasks exited.
+func (s *Supervisor) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run returns true if the task panicked
+func (s *Supervisor) run(ctx context.Context, name string, task Task) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error(
+				"Task panicked",
+				zap.String("task", name),
+				zap.String("panic", fmt.Sprint(r)),
+				zap.ByteString("stack", debug.Stack()),
+			)
+
+			panicked = true
+		}
+	}()
+
+	task(ctx)
+	return false
+}
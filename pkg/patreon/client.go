@@ -20,34 +20,42 @@ type Client struct {
 	config      config.Config
 	logger      *zap.Logger
 	ratelimiter *rate.Limiter
+	options     options
 
 	Tokens Tokens
 }
 
 const UserAgent = "ticketsbot.net/subscriptions-app (https://github.com/TicketsBot/subscriptions-app)"
 
-func NewClient(config config.Config, logger *zap.Logger) *Client {
+func NewClient(config config.Config, logger *zap.Logger, opts ...Option) *Client {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return &Client{
-		httpClient: http.DefaultClient,
+		httpClient: options.buildHttpClient(),
 		config:     config,
 		logger:     logger,
 		ratelimiter: rate.NewLimiter(
 			rate.Every(time.Minute/time.Duration(config.Patreon.RequestsPerMinute)),
 			config.Patreon.RequestsPerMinute,
 		),
+		options: options,
 	}
 }
 
 func (c *Client) FetchPledges(ctx context.Context) (map[string]Patron, error) {
 	url := fmt.Sprintf(
-		"https://www.patreon.com/api/oauth2/v2/campaigns/%d/members?include=currently_entitled_tiers,user&fields%%5Bmember%%5D=last_charge_date,last_charge_status,patron_status,email,pledge_relationship_start&fields%%5Buser%%5D=social_connections",
+		"%s/api/oauth2/v2/campaigns/%d/members?include=currently_entitled_tiers,user&fields%%5Bmember%%5D=last_charge_date,last_charge_status,patron_status,email,pledge_relationship_start,next_charge_date,currently_entitled_amount_cents,pledge_cadence&fields%%5Buser%%5D=social_connections",
+		c.options.baseUrl,
 		c.config.Patreon.CampaignId,
 	)
 
 	// Email -> Data
 	data := make(map[string]Patron)
 	for {
-		res, err := c.FetchPageWithTimeout(ctx, 10*time.Minute, url)
+		res, err := c.FetchPageWithTimeout(ctx, c.options.pageTimeout, url)
 		if err != nil {
 			return nil, err
 		}
@@ -122,7 +130,7 @@ func (c *Client) FetchPage(ctx context.Context, url string) (PledgeResponse, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.Tokens.AccessToken)
-	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("User-Agent", c.options.userAgent)
 
 	if err := c.ratelimiter.Wait(ctx); err != nil {
 		return PledgeResponse{}, err
@@ -168,7 +176,7 @@ func (c *Client) FetchPage(ctx context.Context, url string) (PledgeResponse, err
 func (c *Client) GrantCredentials(ctx context.Context) (Tokens, error) {
 	c.logger.Info("Doing client_credentials grant")
 
-	uri := "https://www.patreon.com/api/oauth2/token"
+	uri := c.options.baseUrl + "/api/oauth2/token"
 
 	form := &url.Values{}
 	form.Add("grant_type", "client_credentials")
@@ -181,7 +189,7 @@ func (c *Client) GrantCredentials(ctx context.Context) (Tokens, error) {
 	}
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("User-Agent", UserAgent)
+	req.Header.Add("User-Agent", c.options.userAgent)
 
 	if err := c.ratelimiter.Wait(ctx); err != nil {
 		return Tokens{}, err
@@ -239,7 +247,8 @@ func (c *Client) DoRefresh(ctx context.Context) (Tokens, error) {
 	}
 
 	url := fmt.Sprintf(
-		"https://www.patreon.com/api/oauth2/token?grant_type=refresh_token&refresh_token=%s&client_id=%s&client_secret=%s",
+		"%s/api/oauth2/token?grant_type=refresh_token&refresh_token=%s&client_id=%s&client_secret=%s",
+		c.options.baseUrl,
 		c.Tokens.RefreshToken,
 		c.config.Patreon.ClientId,
 		c.config.Patreon.ClientSecret,
@@ -250,7 +259,7 @@ func (c *Client) DoRefresh(ctx context.Context) (Tokens, error) {
 		return Tokens{}, err
 	}
 
-	req.Header.Add("User-Agent", UserAgent)
+	req.Header.Add("User-Agent", c.options.userAgent)
 
 	if err := c.ratelimiter.Wait(ctx); err != nil {
 		return Tokens{}, err
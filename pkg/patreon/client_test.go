@@ -0,0 +1,140 @@
+package patreon_test
+
+import (
+	"context"
+	"github.com/TicketsBot/subscriptions-app/internal/config"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon"
+	"github.com/TicketsBot/subscriptions-app/pkg/patreon/patreontest"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const campaignId = 1234
+
+func newTestClient(t *testing.T, server *patreontest.Server) *patreon.Client {
+	t.Helper()
+
+	var conf config.Config
+	conf.Patreon.ClientId = patreontest.ClientId
+	conf.Patreon.ClientSecret = patreontest.ClientSecret
+	conf.Patreon.CampaignId = campaignId
+	conf.Patreon.RequestsPerMinute = 6000
+	conf.Tiers = map[uint64]string{
+		1: "Premium",
+		2: "Whitelabel",
+	}
+
+	return patreon.NewClient(
+		conf,
+		zap.NewNop(),
+		patreon.WithBaseUrl(server.URL),
+		patreon.WithHttpClient(server.Client()),
+		patreon.WithTimeout(time.Second*5),
+	)
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestFetchPledgesPaginated(t *testing.T) {
+	server := patreontest.NewServer(campaignId)
+	defer server.Close()
+
+	server.PageSize = 2
+	server.AddMembers(
+		patreontest.Member{UserId: 1, Email: "a@example.com", PatronStatus: "active_patron", TierIds: []uint64{1}, DiscordId: ptr[uint64](100)},
+		patreontest.Member{UserId: 2, Email: "b@example.com", PatronStatus: "active_patron", TierIds: []uint64{2, 99}},
+		patreontest.Member{UserId: 3, Email: "c@example.com", PatronStatus: "declined_patron"},
+		patreontest.Member{UserId: 4, PatronStatus: "active_patron"}, // No email, should be skipped
+		patreontest.Member{UserId: 5, Email: "e@example.com", PatronStatus: "former_patron"},
+	)
+
+	client := newTestClient(t, server)
+	if _, err := client.GrantCredentials(context.Background()); err != nil {
+		t.Fatalf("failed to grant credentials: %v", err)
+	}
+
+	pledges, err := client.FetchPledges(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch pledges: %v", err)
+	}
+
+	if got := server.RequestCount(patreontest.EndpointMembers); got != 3 {
+		t.Errorf("expected 3 page requests, got %d", got)
+	}
+
+	if len(pledges) != 4 {
+		t.Fatalf("expected 4 pledges, got %d", len(pledges))
+	}
+
+	a := pledges["a@example.com"]
+	if a.Id != 1 || a.DiscordId == nil || *a.DiscordId != 100 || len(a.Tiers) != 1 || a.Tiers[0] != 1 {
+		t.Errorf("unexpected patron: %+v", a)
+	}
+
+	// Unknown tier 99 should be dropped
+	b := pledges["b@example.com"]
+	if b.DiscordId != nil || len(b.Tiers) != 1 || b.Tiers[0] != 2 {
+		t.Errorf("unexpected patron: %+v", b)
+	}
+
+	if pledges["c@example.com"].PatronStatus != "declined_patron" {
+		t.Errorf("unexpected patron status: %s", pledges["c@example.com"].PatronStatus)
+	}
+}
+
+func TestDoRefresh(t *testing.T) {
+	server := patreontest.NewServer(campaignId)
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	granted, err := client.GrantCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("failed to grant credentials: %v", err)
+	}
+
+	refreshed, err := client.DoRefresh(context.Background())
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	if refreshed.AccessToken == granted.AccessToken {
+		t.Errorf("expected a new access token")
+	}
+
+	if client.Tokens.AccessToken != server.AccessToken() {
+		t.Errorf("client is not using the latest access token")
+	}
+
+	if _, err := client.FetchPledges(context.Background()); err != nil {
+		t.Errorf("failed to fetch pledges with refreshed token: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := patreontest.NewServer(campaignId)
+	defer server.Close()
+
+	client := newTestClient(t, server)
+
+	server.FailNext(patreontest.EndpointToken, http.StatusInternalServerError)
+	if _, err := client.GrantCredentials(context.Background()); err == nil {
+		t.Fatalf("expected grant to fail")
+	}
+
+	if _, err := client.GrantCredentials(context.Background()); err != nil {
+		t.Fatalf("failed to grant credentials: %v", err)
+	}
+
+	server.FailNext(patreontest.EndpointMembers, http.StatusTooManyRequests)
+	if _, err := client.FetchPledges(context.Background()); err == nil {
+		t.Fatalf("expected fetch to fail")
+	}
+
+	if _, err := client.FetchPledges(context.Background()); err != nil {
+		t.Fatalf("failed to fetch pledges: %v", err)
+	}
+}
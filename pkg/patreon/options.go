@@ -0,0 +1,97 @@
+package patreon
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultBaseUrl = "https://www.patreon.com"
+
+type Option func(*options)
+
+type options struct {
+	baseUrl     string
+	httpClient  *http.Client
+	transport   http.RoundTripper
+	timeout     time.Duration
+	pageTimeout time.Duration
+	userAgent   string
+}
+
+func defaultOptions() options {
+	return options{
+		baseUrl:     DefaultBaseUrl,
+		pageTimeout: time.Minute * 10,
+		userAgent:   UserAgent,
+	}
+}
+
+// WithBaseUrl overrides the Patreon base URL, e.g. to point the client at a mock server.
+func WithBaseUrl(baseUrl string) Option {
+	return func(o *options) {
+		o.baseUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+// WithHttpClient sets the HTTP client used to make requests. Defaults to http.DefaultClient.
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTransport sets the transport used to make requests. If combined with WithHttpClient, the provided client is
+// copied rather than modified.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithTimeout sets the timeout for each individual HTTP request.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithPageTimeout sets the maximum time spent fetching a single page of members, including time spent waiting on
+// the ratelimiter. Defaults to 10 minutes.
+func WithPageTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.pageTimeout = timeout
+	}
+}
+
+// WithUserAgent overrides the User-Agent header sent with each request.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		o.userAgent = userAgent
+	}
+}
+
+func (o options) buildHttpClient() *http.Client {
+	if o.transport == nil && o.timeout == 0 {
+		if o.httpClient == nil {
+			return http.DefaultClient
+		}
+
+		return o.httpClient
+	}
+
+	var httpClient http.Client
+	if o.httpClient != nil {
+		httpClient = *o.httpClient
+	}
+
+	if o.transport != nil {
+		httpClient.Transport = o.transport
+	}
+
+	if o.timeout != 0 {
+		httpClient.Timeout = o.timeout
+	}
+
+	return &httpClient
+}
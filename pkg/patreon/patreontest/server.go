@@ -0,0 +1,311 @@
+// Package patreontest provides a fake Patreon API server for testing code that uses the patreon package, without
+// making requests to Patreon itself.
+package patreontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ClientId     = "test-client-id"
+	ClientSecret = "test-client-secret"
+)
+
+// Member is a simplified representation of a campaign member, which is converted to the JSON:API format served by
+// Patreon when requested.
+type Member struct {
+	UserId                  uint64
+	Email                   string
+	PatronStatus            string
+	LastChargeStatus        string
+	LastChargeDate          time.Time
+	PledgeRelationshipStart time.Time
+	NextChargeDate          time.Time
+	EntitledAmountCents     int
+	PledgeCadence           int
+	TierIds                 []uint64
+	DiscordId               *uint64
+}
+
+type Server struct {
+	*httptest.Server
+
+	CampaignId int
+	PageSize   int
+	ExpiresIn  time.Duration
+
+	mu            sync.Mutex
+	members       []Member
+	accessToken   string
+	refreshToken  string
+	tokenCounter  int
+	failures      map[string][]int
+	requestCounts map[string]int
+}
+
+const (
+	EndpointToken   = "token"
+	EndpointMembers = "members"
+)
+
+// NewServer starts a new fake Patreon server. The caller must call Close when finished.
+func NewServer(campaignId int) *Server {
+	s := &Server{
+		CampaignId:    campaignId,
+		PageSize:      20,
+		ExpiresIn:     time.Hour * 24 * 30,
+		failures:      make(map[string][]int),
+		requestCounts: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/oauth2/token", s.handleToken)
+	mux.HandleFunc(fmt.Sprintf("/api/oauth2/v2/campaigns/%d/members", campaignId), s.handleMembers)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddMembers appends members to the campaign.
+func (s *Server) AddMembers(members ...Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members = append(s.members, members...)
+}
+
+// SetMembers replaces all members of the campaign.
+func (s *Server) SetMembers(members ...Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members = members
+}
+
+// FailNext causes the next request to the given endpoint (EndpointToken or EndpointMembers) to fail with the given
+// status code. Multiple calls are queued.
+func (s *Server) FailNext(endpoint string, statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[endpoint] = append(s.failures[endpoint], statusCode)
+}
+
+// RequestCount returns the number of requests that have been made to the given endpoint.
+func (s *Server) RequestCount(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requestCounts[endpoint]
+}
+
+// AccessToken returns the most recently issued access token.
+func (s *Server) AccessToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.accessToken
+}
+
+// checkFailure records the request, and writes an error response if a failure has been queued for the endpoint.
+func (s *Server) checkFailure(w http.ResponseWriter, endpoint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requestCounts[endpoint]++
+
+	queue := s.failures[endpoint]
+	if len(queue) == 0 {
+		return false
+	}
+
+	statusCode := queue[0]
+	s.failures[endpoint] = queue[1:]
+
+	writeError(w, statusCode, "Injected failure")
+	return true
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.checkFailure(w, EndpointToken) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid form body")
+		return
+	}
+
+	if r.Form.Get("client_id") != ClientId || r.Form.Get("client_secret") != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "Invalid client credentials")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Form.Get("grant_type") {
+	case "client_credentials":
+	case "refresh_token":
+		if s.refreshToken == "" || r.Form.Get("refresh_token") != s.refreshToken {
+			writeError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "Unsupported grant type")
+		return
+	}
+
+	s.tokenCounter++
+	s.accessToken = fmt.Sprintf("access-token-%d", s.tokenCounter)
+	s.refreshToken = fmt.Sprintf("refresh-token-%d", s.tokenCounter)
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token":  s.accessToken,
+		"refresh_token": s.refreshToken,
+		"expires_in":    int64(s.ExpiresIn.Seconds()),
+		"scope":         "campaigns.members",
+		"token_type":    "Bearer",
+	})
+}
+
+func (s *Server) handleMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.checkFailure(w, EndpointMembers) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken == "" || r.Header.Get("Authorization") != "Bearer "+s.accessToken {
+		writeError(w, http.StatusUnauthorized, "Invalid access token")
+		return
+	}
+
+	offset := 0
+	if cursor := r.URL.Query().Get("page[cursor]"); cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil || parsed < 0 || parsed > len(s.members) {
+			writeError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+
+		offset = parsed
+	}
+
+	end := offset + s.PageSize
+	if end > len(s.members) {
+		end = len(s.members)
+	}
+
+	page := s.members[offset:end]
+
+	data := make([]any, len(page))
+	included := make([]any, 0, len(page))
+	for i, member := range page {
+		tiers := make([]any, len(member.TierIds))
+		for j, tierId := range member.TierIds {
+			tiers[j] = map[string]any{
+				"id":   strconv.FormatUint(tierId, 10),
+				"type": "tier",
+			}
+		}
+
+		data[i] = map[string]any{
+			"type": "member",
+			"attributes": map[string]any{
+				"email":                           member.Email,
+				"last_charge_date":                member.LastChargeDate.Format(time.RFC3339),
+				"last_charge_status":              member.LastChargeStatus,
+				"patron_status":                   member.PatronStatus,
+				"pledge_relationship_start":       member.PledgeRelationshipStart.Format(time.RFC3339),
+				"next_charge_date":                member.NextChargeDate.Format(time.RFC3339),
+				"currently_entitled_amount_cents": member.EntitledAmountCents,
+				"pledge_cadence":                  member.PledgeCadence,
+			},
+			"relationships": map[string]any{
+				"user": map[string]any{
+					"data": map[string]any{
+						"id":   strconv.FormatUint(member.UserId, 10),
+						"type": "user",
+					},
+				},
+				"currently_entitled_tiers": map[string]any{
+					"data": tiers,
+				},
+			},
+		}
+
+		socialConnections := map[string]any{
+			"discord": nil,
+		}
+
+		if member.DiscordId != nil {
+			socialConnections["discord"] = map[string]any{
+				"user_id": strconv.FormatUint(*member.DiscordId, 10),
+			}
+		}
+
+		included = append(included, map[string]any{
+			"id":   strconv.FormatUint(member.UserId, 10),
+			"type": "user",
+			"attributes": map[string]any{
+				"social_connections": socialConnections,
+			},
+		})
+	}
+
+	links := map[string]any{
+		"first": s.pageUrl(r, 0),
+	}
+
+	if end < len(s.members) {
+		links["next"] = s.pageUrl(r, end)
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"data":     data,
+		"included": included,
+		"links":    links,
+	})
+}
+
+func (s *Server) pageUrl(r *http.Request, offset int) string {
+	query := r.URL.Query()
+	query.Set("page[cursor]", strconv.Itoa(offset))
+
+	return fmt.Sprintf("%s%s?%s", s.URL, r.URL.Path, query.Encode())
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJson(w, statusCode, map[string]any{
+		"errors": []map[string]any{
+			{
+				"status": strconv.Itoa(statusCode),
+				"detail": message,
+			},
+		},
+	})
+}
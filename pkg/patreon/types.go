@@ -2,6 +2,19 @@ package patreon
 
 import "time"
 
+// Values of Attributes.PatronStatus
+const (
+	StatusActive   = "active_patron"
+	StatusDeclined = "declined_patron"
+	StatusFormer   = "former_patron"
+)
+
+// Common values of Attributes.LastChargeStatus
+const (
+	ChargeStatusPaid     = "Paid"
+	ChargeStatusDeclined = "Declined"
+)
+
 type (
 	Patron struct {
 		Attributes
@@ -41,6 +54,10 @@ type (
 		LastChargeStatus        string    `json:"last_charge_status"`
 		PatronStatus            string    `json:"patron_status"`
 		PledgeRelationshipStart time.Time `json:"pledge_relationship_start"`
+		NextChargeDate          time.Time `json:"next_charge_date"`
+		// The amount the patron is entitled to per billing period, in the campaign's currency
+		CurrentlyEntitledAmountCents int `json:"currently_entitled_amount_cents"`
+		PledgeCadence                int `json:"pledge_cadence"` // The number of months between charges, e.g. 12 for annual pledges
 	}
 
 	PatronMetadata struct {
@@ -0,0 +1,4 @@
+// Package subscriptionspb contains the gRPC service served alongside the HTTP API, generated from subscriptions.proto.
+package subscriptionspb
+
+//go:generate protoc -I.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative subscriptionspb/subscriptions.proto
@@ -0,0 +1,330 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: subscriptionspb/subscriptions.proto
+
+package subscriptionspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Subscriptions_GetPatronByDiscordId_FullMethodName = "/subscriptions.v1.Subscriptions/GetPatronByDiscordId"
+	Subscriptions_GetPatronByEmail_FullMethodName     = "/subscriptions.v1.Subscriptions/GetPatronByEmail"
+	Subscriptions_ListPatrons_FullMethodName          = "/subscriptions.v1.Subscriptions/ListPatrons"
+	Subscriptions_GetEntitlement_FullMethodName       = "/subscriptions.v1.Subscriptions/GetEntitlement"
+	Subscriptions_WatchChanges_FullMethodName         = "/subscriptions.v1.Subscriptions/WatchChanges"
+)
+
+// SubscriptionsClient is the client API for Subscriptions service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SubscriptionsClient interface {
+	// GetPatronByDiscordId returns the subscriber linked to the Discord account, preferring an active subscription if
+	// they have more than one. Users with a grant but no subscription are returned with only their Discord ID and grant.
+	GetPatronByDiscordId(ctx context.Context, in *GetPatronByDiscordIdRequest, opts ...grpc.CallOption) (*Patron, error)
+	// GetPatronByEmail returns the subscriber with the email address, preferring an active subscription if they have more
+	// than one.
+	GetPatronByEmail(ctx context.Context, in *GetPatronByEmailRequest, opts ...grpc.CallOption) (*Patron, error)
+	// ListPatrons streams every subscriber matching the filters, ordered by provider and ID.
+	ListPatrons(ctx context.Context, in *ListPatronsRequest, opts ...grpc.CallOption) (Subscriptions_ListPatronsClient, error)
+	// GetEntitlement returns the effective entitlement of the Discord user, from their subscriptions and any grant.
+	GetEntitlement(ctx context.Context, in *GetEntitlementRequest, opts ...grpc.CallOption) (*GetEntitlementResponse, error)
+	// WatchChanges streams changes to subscribers and entitlements as they are detected, starting from the next update.
+	// Response headers are sent once the stream is watching, so clients that wait for them will not miss any change made
+	// afterwards. Streams that fall too far behind are closed with RESOURCE_EXHAUSTED, and should be reopened.
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Subscriptions_WatchChangesClient, error)
+}
+
+type subscriptionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSubscriptionsClient(cc grpc.ClientConnInterface) SubscriptionsClient {
+	return &subscriptionsClient{cc}
+}
+
+func (c *subscriptionsClient) GetPatronByDiscordId(ctx context.Context, in *GetPatronByDiscordIdRequest, opts ...grpc.CallOption) (*Patron, error) {
+	out := new(Patron)
+	err := c.cc.Invoke(ctx, Subscriptions_GetPatronByDiscordId_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionsClient) GetPatronByEmail(ctx context.Context, in *GetPatronByEmailRequest, opts ...grpc.CallOption) (*Patron, error) {
+	out := new(Patron)
+	err := c.cc.Invoke(ctx, Subscriptions_GetPatronByEmail_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionsClient) ListPatrons(ctx context.Context, in *ListPatronsRequest, opts ...grpc.CallOption) (Subscriptions_ListPatronsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Subscriptions_ServiceDesc.Streams[0], Subscriptions_ListPatrons_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &subscriptionsListPatronsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Subscriptions_ListPatronsClient interface {
+	Recv() (*Patron, error)
+	grpc.ClientStream
+}
+
+type subscriptionsListPatronsClient struct {
+	grpc.ClientStream
+}
+
+func (x *subscriptionsListPatronsClient) Recv() (*Patron, error) {
+	m := new(Patron)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *subscriptionsClient) GetEntitlement(ctx context.Context, in *GetEntitlementRequest, opts ...grpc.CallOption) (*GetEntitlementResponse, error) {
+	out := new(GetEntitlementResponse)
+	err := c.cc.Invoke(ctx, Subscriptions_GetEntitlement_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionsClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Subscriptions_WatchChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Subscriptions_ServiceDesc.Streams[1], Subscriptions_WatchChanges_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &subscriptionsWatchChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Subscriptions_WatchChangesClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type subscriptionsWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *subscriptionsWatchChangesClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SubscriptionsServer is the server API for Subscriptions service.
+// All implementations must embed UnimplementedSubscriptionsServer
+// for forward compatibility
+type SubscriptionsServer interface {
+	// GetPatronByDiscordId returns the subscriber linked to the Discord account, preferring an active subscription if
+	// they have more than one. Users with a grant but no subscription are returned with only their Discord ID and grant.
+	GetPatronByDiscordId(context.Context, *GetPatronByDiscordIdRequest) (*Patron, error)
+	// GetPatronByEmail returns the subscriber with the email address, preferring an active subscription if they have more
+	// than one.
+	GetPatronByEmail(context.Context, *GetPatronByEmailRequest) (*Patron, error)
+	// ListPatrons streams every subscriber matching the filters, ordered by provider and ID.
+	ListPatrons(*ListPatronsRequest, Subscriptions_ListPatronsServer) error
+	// GetEntitlement returns the effective entitlement of the Discord user, from their subscriptions and any grant.
+	GetEntitlement(context.Context, *GetEntitlementRequest) (*GetEntitlementResponse, error)
+	// WatchChanges streams changes to subscribers and entitlements as they are detected, starting from the next update.
+	// Response headers are sent once the stream is watching, so clients that wait for them will not miss any change made
+	// afterwards. Streams that fall too far behind are closed with RESOURCE_EXHAUSTED, and should be reopened.
+	WatchChanges(*WatchChangesRequest, Subscriptions_WatchChangesServer) error
+	mustEmbedUnimplementedSubscriptionsServer()
+}
+
+// UnimplementedSubscriptionsServer must be embedded to have forward compatible implementations.
+type UnimplementedSubscriptionsServer struct {
+}
+
+func (UnimplementedSubscriptionsServer) GetPatronByDiscordId(context.Context, *GetPatronByDiscordIdRequest) (*Patron, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPatronByDiscordId not implemented")
+}
+func (UnimplementedSubscriptionsServer) GetPatronByEmail(context.Context, *GetPatronByEmailRequest) (*Patron, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPatronByEmail not implemented")
+}
+func (UnimplementedSubscriptionsServer) ListPatrons(*ListPatronsRequest, Subscriptions_ListPatronsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListPatrons not implemented")
+}
+func (UnimplementedSubscriptionsServer) GetEntitlement(context.Context, *GetEntitlementRequest) (*GetEntitlementResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEntitlement not implemented")
+}
+func (UnimplementedSubscriptionsServer) WatchChanges(*WatchChangesRequest, Subscriptions_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedSubscriptionsServer) mustEmbedUnimplementedSubscriptionsServer() {}
+
+// UnsafeSubscriptionsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SubscriptionsServer will
+// result in compilation errors.
+type UnsafeSubscriptionsServer interface {
+	mustEmbedUnimplementedSubscriptionsServer()
+}
+
+func RegisterSubscriptionsServer(s grpc.ServiceRegistrar, srv SubscriptionsServer) {
+	s.RegisterService(&Subscriptions_ServiceDesc, srv)
+}
+
+func _Subscriptions_GetPatronByDiscordId_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPatronByDiscordIdRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionsServer).GetPatronByDiscordId(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Subscriptions_GetPatronByDiscordId_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionsServer).GetPatronByDiscordId(ctx, req.(*GetPatronByDiscordIdRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Subscriptions_GetPatronByEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPatronByEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionsServer).GetPatronByEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Subscriptions_GetPatronByEmail_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionsServer).GetPatronByEmail(ctx, req.(*GetPatronByEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Subscriptions_ListPatrons_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListPatronsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SubscriptionsServer).ListPatrons(m, &subscriptionsListPatronsServer{stream})
+}
+
+type Subscriptions_ListPatronsServer interface {
+	Send(*Patron) error
+	grpc.ServerStream
+}
+
+type subscriptionsListPatronsServer struct {
+	grpc.ServerStream
+}
+
+func (x *subscriptionsListPatronsServer) Send(m *Patron) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Subscriptions_GetEntitlement_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEntitlementRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionsServer).GetEntitlement(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Subscriptions_GetEntitlement_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionsServer).GetEntitlement(ctx, req.(*GetEntitlementRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Subscriptions_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SubscriptionsServer).WatchChanges(m, &subscriptionsWatchChangesServer{stream})
+}
+
+type Subscriptions_WatchChangesServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type subscriptionsWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *subscriptionsWatchChangesServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Subscriptions_ServiceDesc is the grpc.ServiceDesc for Subscriptions service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Subscriptions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "subscriptions.v1.Subscriptions",
+	HandlerType: (*SubscriptionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPatronByDiscordId",
+			Handler:    _Subscriptions_GetPatronByDiscordId_Handler,
+		},
+		{
+			MethodName: "GetPatronByEmail",
+			Handler:    _Subscriptions_GetPatronByEmail_Handler,
+		},
+		{
+			MethodName: "GetEntitlement",
+			Handler:    _Subscriptions_GetEntitlement_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListPatrons",
+			Handler:       _Subscriptions_ListPatrons_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _Subscriptions_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "subscriptionspb/subscriptions.proto",
+}